@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/chain5j/chain5j-pkg/cli"
+	"github.com/spf13/cobra"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/migrate"
+	"github.com/xwc1125/apisix-go/internal/serve"
+	"github.com/xwc1125/apisix-go/params"
+)
+
+// importModes import的--mode与冲突处理方式的对应关系
+var importModes = map[string]migrate.ConflictMode{
+	"fail":      migrate.ModeReturn,
+	"skip":      migrate.ModeSkip,
+	"overwrite": migrate.ModeOverwrite,
+}
+
+type ConfigCmd struct {
+	rootCli *cli.Cli
+	cmd     *cobra.Command
+
+	output string // export的输出文件
+	input  string // import的输入文件
+	mode   string // import时已存在的对象的处理方式
+	dryRun bool   // import时只输出变更
+}
+
+// NewConfigCmd 初始化config命令，用于导出、导入etcd中的路由、上游等配置
+func NewConfigCmd(rootCli *cli.Cli) *cobra.Command {
+	c := &ConfigCmd{
+		rootCli: rootCli,
+	}
+	c.cmd = &cobra.Command{
+		Use:   "config",
+		Short: "Export or import configurations",
+	}
+	c.cmd.AddCommand(c.exportCmd(), c.importCmd())
+	return c.cmd
+}
+
+func (c *ConfigCmd) exportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "export",
+		Short:        "Export routes, services, upstreams, consumers and other configurations",
+		Example:      params.App() + " config export --config conf/config.yaml -o apisix.json",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.export(cmd.OutOrStdout())
+		},
+	}
+	cmd.Flags().StringVarP(&c.output, "output", "o", "", "Output file, print to stdout if empty")
+	return cmd
+}
+
+func (c *ConfigCmd) importCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "import",
+		Short:        "Import configurations exported by config export",
+		Example:      params.App() + " config import --config conf/config.yaml -i apisix.json --mode=skip --dry-run",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.importData(cmd.OutOrStdout())
+		},
+	}
+	cmd.Flags().StringVarP(&c.input, "input", "i", "", "Input file exported by config export")
+	cmd.Flags().StringVar(&c.mode, "mode", "fail", "How to handle existing objects: fail, skip or overwrite")
+	cmd.Flags().BoolVar(&c.dryRun, "dry-run", false, "Print the changes without importing")
+	cmd.MarkFlagRequired("input")
+	return cmd
+}
+
+func (c *ConfigCmd) export(stdout io.Writer) error {
+	if _, err := serve.InitStores(".", nil); err != nil {
+		return err
+	}
+	data, err := migrate.Export(context.Background())
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	if len(c.output) == 0 {
+		_, err = stdout.Write(buf.Bytes())
+		return err
+	}
+	if err := os.WriteFile(c.output, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "exported to %s\n", c.output)
+	return nil
+}
+
+func (c *ConfigCmd) importData(stdout io.Writer) error {
+	mode, ok := importModes[c.mode]
+	if !ok {
+		return fmt.Errorf("invalid mode %s, must be fail, skip or overwrite", c.mode)
+	}
+	data, err := os.ReadFile(c.input)
+	if err != nil {
+		return err
+	}
+	if _, err := serve.InitStores(".", nil); err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	changes, err := migrate.Plan(ctx, data, mode)
+	if err != nil {
+		return err
+	}
+	printChanges(stdout, changes)
+	if c.dryRun {
+		return nil
+	}
+	for _, change := range changes {
+		if change.Action == migrate.ActionInvalid {
+			return fmt.Errorf("%s %s is invalid: %s", change.HubKey, change.Key, change.Error)
+		}
+	}
+
+	conflicts, err := migrate.Import(ctx, data, mode)
+	if errors.Is(err, migrate.ErrConflict) {
+		return fmt.Errorf("%d objects already exist, use --mode=skip or --mode=overwrite", conflictCount(conflicts))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "import finished")
+	return nil
+}
+
+// printChanges 输出导入的变更，覆盖或冲突的对象同时输出字段的差异
+func printChanges(w io.Writer, changes []*migrate.Change) {
+	counts := make(map[migrate.Action]int)
+	for _, change := range changes {
+		counts[change.Action]++
+		key := change.Key
+		if len(key) == 0 {
+			key = "<new>"
+		}
+		fmt.Fprintf(w, "%-8s %s/%s\n", change.Action, change.HubKey, key)
+		for _, line := range change.Diff {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+		if len(change.Error) > 0 {
+			fmt.Fprintf(w, "    %s\n", change.Error)
+		}
+	}
+	fmt.Fprintf(w, "create: %d, update: %d, skip: %d, conflict: %d, invalid: %d\n",
+		counts[migrate.ActionCreate], counts[migrate.ActionUpdate], counts[migrate.ActionSkip],
+		counts[migrate.ActionConflict], counts[migrate.ActionInvalid])
+}
+
+func conflictCount(data *migrate.DataSet) int {
+	if data == nil {
+		return 0
+	}
+	return len(data.Consumers) + len(data.Routes) + len(data.Services) + len(data.SSLs) +
+		len(data.Upstreams) + len(data.Scripts) + len(data.GlobalPlugins) + len(data.PluginConfigs)
+}
@@ -3,8 +3,10 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"net/http"
 
 	"github.com/chain5j/chain5j-pkg/cli"
+	"github.com/chain5j/chain5j-pkg/network"
 	"github.com/chain5j/logger"
 	"github.com/chain5j/logger/zap"
 	"github.com/spf13/cobra"
@@ -13,6 +15,8 @@ import (
 	"github.com/xwc1125/apisix-go/internal/models"
 	"github.com/xwc1125/apisix-go/internal/serve"
 	"github.com/xwc1125/apisix-go/params"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 var (
@@ -84,6 +88,52 @@ func run() error {
 		log.Fatal(err)
 	}
 
+	var controlConfig models.ServerConfig
+	if err := viper.UnmarshalKey("control", &controlConfig); err != nil {
+		logger.Fatal(err)
+	}
+	if controlConfig.Port > 0 {
+		controlEndpoint := fmt.Sprintf("%s:%d", controlConfig.Host, controlConfig.Port)
+		logger.Info("control server", "endpoint", controlEndpoint)
+		go func() {
+			if err := fasthttp.ListenAndServe(controlEndpoint, serve.ControlHandler); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	var adminConfig models.AdminConfig
+	if err := viper.UnmarshalKey("admin", &adminConfig); err != nil {
+		logger.Fatal(err)
+	}
+	if adminConfig.Port > 0 && viper.GetBool("standalone.enable") {
+		logger.Warn("admin server is disabled in standalone mode, edit the standalone config file instead")
+	} else if adminConfig.Port > 0 {
+		adminServe, err := serve.NewAdminServe(adminConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		adminEndpoint := fmt.Sprintf("%s:%d", adminConfig.Host, adminConfig.Port)
+		logger.Info("admin server", "endpoint", adminEndpoint)
+		go func() {
+			if err := fasthttp.ListenAndServe(adminEndpoint, adminServe.Handler); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	var grpcConfig models.ServerConfig
+	if err := viper.UnmarshalKey("grpc", &grpcConfig); err != nil {
+		logger.Fatal(err)
+	}
+	if grpcConfig.Port > 0 {
+		go func() {
+			if err := serveGRPC(grpcConfig, proxyServe); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	endpoint := fmt.Sprintf("%s:%d", serverConfig.Host, serverConfig.Port)
 	logger.Info("proxy server", "endpoint", endpoint)
 	if err := fasthttp.ListenAndServe(endpoint, proxyServe.ProxyHandler); err != nil {
@@ -91,3 +141,16 @@ func run() error {
 	}
 	return nil
 }
+
+// serveGRPC 启动gRPC代理服务，ssl未启用时接收h2c的请求，启用时通过ALPN协商h2
+func serveGRPC(config models.ServerConfig, proxyServe *serve.ProxyServe) error {
+	endpoint := fmt.Sprintf("%s:%d", config.Host, config.Port)
+	logger.Info("grpc proxy server", "endpoint", endpoint, "ssl", config.Ssl.Mod)
+	server := &http.Server{Addr: endpoint}
+	if len(config.Ssl.Mod) == 0 || config.Ssl.Mod == network.Disable {
+		server.Handler = h2c.NewHandler(http.HandlerFunc(proxyServe.GRPCHandler), &http2.Server{})
+		return server.ListenAndServe()
+	}
+	server.Handler = http.HandlerFunc(proxyServe.GRPCHandler)
+	return server.ListenAndServeTLS(config.Ssl.CertFile, config.Ssl.KeyFile)
+}
@@ -18,6 +18,7 @@
 package entity
 
 import (
+	"encoding/json"
 	"reflect"
 	"time"
 
@@ -68,7 +69,7 @@ type Route struct {
 	BaseInfo
 	URI             string                 `json:"uri,omitempty" comment:"单个http请求路径"`
 	Uris            []string               `json:"uris,omitempty" comment:"多个http请求路径"`
-	Name            string                 `json:"name" validate:"max=100" comment:"名称"`
+	Name            string                 `json:"name,omitempty" validate:"max=100" comment:"名称"`
 	Desc            string                 `json:"desc,omitempty" validate:"max=256" default:"描述"`
 	Priority        int                    `json:"priority,omitempty" comment:"优先级"`
 	Methods         []string               `json:"methods,omitempty" comment:"允许的http 方法"`
@@ -91,6 +92,17 @@ type Route struct {
 	Status          Status                 `json:"status" comment:"状态：1已发布，0待发布"`
 }
 
+// UnmarshalJSON 与APISIX的schema一致，未配置status时默认为1
+func (r *Route) UnmarshalJSON(data []byte) error {
+	type route Route
+	v := route{Status: 1}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*r = Route(v)
+	return nil
+}
+
 // --- structures for upstream start  ---
 type TimeoutValue float32
 type Timeout struct {
@@ -137,7 +149,7 @@ type Active struct {
 	Host                   string       `json:"host,omitempty" comment:"主机名"`
 	Port                   int          `json:"port,omitempty" comment:"端口"`
 	HTTPPath               string       `json:"http_path,omitempty" comment:"请求路径"`
-	HTTPSVerifyCertificate bool         `json:"https_verify_certificate,omitempty"`
+	HTTPSVerifyCertificate *bool        `json:"https_verify_certificate,omitempty" comment:"是否校验证书，留空时校验"`
 	Healthy                Healthy      `json:"healthy,omitempty" comment:"健康状态"`
 	UnHealthy              UnHealthy    `json:"unhealthy,omitempty" comment:"不健康状态"`
 	ReqHeaders             []string     `json:"req_headers,omitempty" comment:"额外的请求头"` // 示例：User-Agent: curl/7.29.0
@@ -158,6 +170,7 @@ type HealthChecker struct {
 type UpstreamTLS struct {
 	ClientCert string `json:"client_cert,omitempty"`
 	ClientKey  string `json:"client_key,omitempty"`
+	Verify     bool   `json:"verify,omitempty"` // 是否校验上游的证书
 }
 
 type UpstreamKeepalivePool struct {
@@ -167,20 +180,20 @@ type UpstreamKeepalivePool struct {
 }
 
 type UpstreamDef struct {
-	Nodes   interface{} `json:"nodes,omitempty" comment:"目标节点"`
-	Retries *int        `json:"retries,omitempty" comment:"重试机制将请求发到下一个上游节点。值为 0 表示禁用重试机制，留空表示使用可用后端节点的数量。"`
-	Timeout *Timeout    `json:"timeout,omitempty" comment:"超时"`
-	Type    string      `json:"type,omitempty" comment:"lb负载均衡算法，chash,roundrobin"`
-	HashOn  string      `json:"hash_on,omitempty" comment:"lb哈希位置"`
-	Key     string      `json:"key,omitempty" comment:"lb哈希键"`
-	Checks  interface{} `json:"checks,omitempty" comment:"健康检查配置"`
-	Scheme  string      `json:"scheme,omitempty" comment:"协议:http,https,grpc,grpcs"`
+	Nodes   interface{}    `json:"nodes,omitempty" comment:"目标节点"`
+	Retries *int           `json:"retries,omitempty" comment:"重试机制将请求发到下一个上游节点。值为 0 表示禁用重试机制，留空表示使用可用后端节点的数量。"`
+	Timeout *Timeout       `json:"timeout,omitempty" comment:"超时"`
+	Type    string         `json:"type,omitempty" comment:"lb负载均衡算法，chash,roundrobin"`
+	HashOn  string         `json:"hash_on,omitempty" comment:"lb哈希位置"`
+	Key     string         `json:"key,omitempty" comment:"lb哈希键"`
+	Checks  *HealthChecker `json:"checks,omitempty" comment:"健康检查配置"`
+	Scheme  string         `json:"scheme,omitempty" comment:"协议:http,https,grpc,grpcs"`
 
 	DiscoveryType string            `json:"discovery_type,omitempty" comment:"服务发现类型"`
 	DiscoveryArgs map[string]string `json:"discovery_args,omitempty" comment:"服务发现参数"`
 	ServiceName   string            `json:"服务名称,omitempty"`
 
-	PassHost string `json:"pass_host,omitempty" comment:"Host 请求头"` // node：使用目标节点列表中的主机名活IP，pass：保持与客户端一致的主机名
+	PassHost string `json:"pass_host,omitempty" comment:"Host 请求头"` // pass(默认)：保持与客户端一致的主机名，node：使用目标节点列表中的主机名或IP，rewrite：使用upstream_host
 
 	UpstreamHost  string                 `json:"upstream_host,omitempty"`
 	Name          string                 `json:"name,omitempty" comment:"upstream名称"`
@@ -188,7 +201,8 @@ type UpstreamDef struct {
 	Labels        map[string]string      `json:"labels,omitempty" comment:"标签"`
 	TLS           *UpstreamTLS           `json:"tls,omitempty"`
 	KeepalivePool *UpstreamKeepalivePool `json:"keepalive_pool,omitempty" comment:"连接池配置"`
-	RetryTimeout  TimeoutValue           `json:"retry_timeout,omitempty"`
+	RetryTimeout  TimeoutValue           `json:"retry_timeout,omitempty" comment:"重试的总时间:秒，0表示不限制"`
+	RetryStatuses []int                  `json:"retry_statuses,omitempty" comment:"上游返回这些状态码时重试下一个节点"`
 }
 
 func (u UpstreamDef) GetNodes() []*Node {
@@ -86,14 +86,14 @@ func Import(ctx context.Context, data []byte, mode ConflictMode) (*DataSet, erro
 				}
 			} else {
 				_, e := s.Create(ctx, obj)
-				if err != nil {
+				if e != nil {
 					err = e
 					return false
 				}
 			}
 			return true
 		})
-		return true
+		return err == nil
 	})
 	return nil, err
 }
@@ -0,0 +1,170 @@
+// Package migrate
+//
+// @author: xwc1125
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	store2 "github.com/xwc1125/apisix-go/internal/apisix/core/store"
+)
+
+// Action 导入时对象的操作
+type Action string
+
+const (
+	ActionCreate   Action = "create"   // 新建
+	ActionUpdate   Action = "update"   // 覆盖已有的对象
+	ActionSkip     Action = "skip"     // 已存在，跳过
+	ActionConflict Action = "conflict" // 已存在，导入失败
+	ActionInvalid  Action = "invalid"  // 校验失败
+)
+
+// Change 导入时一个对象的变更，用于dry-run
+type Change struct {
+	HubKey store2.HubKey `json:"type"`
+	Key    string        `json:"key"`
+	Action Action        `json:"action"`
+	Diff   []string      `json:"diff,omitempty"` // 与已有对象的差异，"-"为原值，"+"为导入的值
+	Error  string        `json:"error,omitempty"`
+}
+
+// diffIgnored 比较时忽略的字段，id与key相同，只是类型可能不同
+var diffIgnored = map[string]bool{
+	"id":          true,
+	"create_time": true,
+	"update_time": true,
+}
+
+// Plan 计算按mode导入data时的变更，不会写入store
+func Plan(ctx context.Context, data []byte, mode ConflictMode) ([]*Change, error) {
+	return plan(ctx, data, mode, store2.RangeStore)
+}
+
+func plan(ctx context.Context, data []byte, mode ConflictMode, rangeStore func(f func(key store2.HubKey, store *store2.GenericStore) bool)) ([]*Change, error) {
+	importData := newDataSet()
+	if err := json.Unmarshal(data, &importData); err != nil {
+		return nil, err
+	}
+	changes := make([]*Change, 0)
+	rangeStore(func(hubKey store2.HubKey, s *store2.GenericStore) bool {
+		importData.rangeData(hubKey, func(i int, obj interface{}) bool {
+			change := &Change{
+				HubKey: hubKey,
+				Key:    s.GetObjKey(obj),
+				Action: ActionCreate,
+			}
+			changes = append(changes, change)
+			if setter, ok := obj.(entity.GetBaseInfo); ok && len(change.Key) == 0 {
+				// 与导入时一致，校验前生成id
+				setter.GetBaseInfo().Creating()
+			}
+			if err := s.Validate(obj); err != nil {
+				change.Action = ActionInvalid
+				change.Error = err.Error()
+				return true
+			}
+			if len(change.Key) == 0 {
+				// 未指定id时创建新的对象
+				return true
+			}
+			stored, err := s.Get(ctx, change.Key)
+			if err != nil {
+				return true
+			}
+			diff, err := diffFields(stored, obj)
+			if err != nil {
+				change.Action = ActionInvalid
+				change.Error = err.Error()
+				return true
+			}
+			change.Diff = diff
+			switch mode {
+			case ModeSkip:
+				change.Action = ActionSkip
+			case ModeOverwrite:
+				change.Action = ActionUpdate
+			default:
+				change.Action = ActionConflict
+			}
+			return true
+		})
+		return true
+	})
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].HubKey != changes[j].HubKey {
+			return changes[i].HubKey < changes[j].HubKey
+		}
+		return changes[i].Key < changes[j].Key
+	})
+	return changes, nil
+}
+
+// diffFields 比较两个对象顶层字段的差异
+func diffFields(old, new interface{}) ([]string, error) {
+	oldFields, err := toFields(old)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := toFields(new)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(oldFields)+len(newFields))
+	for name := range oldFields {
+		names = append(names, name)
+	}
+	for name := range newFields {
+		if _, ok := oldFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var diff []string
+	for _, name := range names {
+		if diffIgnored[name] {
+			continue
+		}
+		oldValue, inOld := oldFields[name]
+		newValue, inNew := newFields[name]
+		if inOld && inNew && string(oldValue) == string(newValue) {
+			continue
+		}
+		if inOld {
+			diff = append(diff, fmt.Sprintf("- %s: %s", name, oldValue))
+		}
+		if inNew {
+			diff = append(diff, fmt.Sprintf("+ %s: %s", name, newValue))
+		}
+	}
+	return diff, nil
+}
+
+// toFields 将对象转换为字段名到JSON值的映射，值中对象的key是有序的，可以直接比较
+func toFields(obj interface{}) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]json.RawMessage, len(m))
+	for name, value := range m {
+		if value == nil {
+			continue
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = raw
+	}
+	return fields, nil
+}
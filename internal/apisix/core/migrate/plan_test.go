@@ -0,0 +1,84 @@
+// Package migrate
+//
+// @author: xwc1125
+package migrate
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/chain5j/chain5j-pkg/util/convutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/storage"
+	store2 "github.com/xwc1125/apisix-go/internal/apisix/core/store"
+)
+
+func newRouteStore(t *testing.T, stored ...storage.Keypair) *store2.GenericStore {
+	s, err := store2.NewGenericStore(store2.GenericStoreOption{
+		BasePath: "/apisix/routes",
+		ObjType:  reflect.TypeOf(entity.Route{}),
+		KeyFunc: func(obj interface{}) string {
+			return convutil.ToString(obj.(*entity.Route).ID)
+		},
+		HubKey: store2.HubKeyRoute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mStorage := &storage.MockInterface{}
+	mStorage.On("List", mock.Anything, mock.Anything).Return(stored, nil)
+	mStorage.On("Watch", mock.Anything, mock.Anything).Return((<-chan storage.WatchResponse)(make(chan storage.WatchResponse)))
+	s.Stg = mStorage
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestPlan(t *testing.T) {
+	s := newRouteStore(t,
+		storage.Keypair{Key: "/apisix/routes/1", Value: `{"id":"1","uri":"/a","name":"r1","create_time":1}`},
+		storage.Keypair{Key: "/apisix/routes/2", Value: `{"id":"2","uri":"/b"}`},
+	)
+	rangeStore := func(f func(key store2.HubKey, store *store2.GenericStore) bool) {
+		f(store2.HubKeyRoute, s)
+	}
+	data := []byte(`{"Routes":[
+		{"id":"3","uri":"/c"},
+		{"id":"1","uri":"/a2","desc":"new","create_time":2},
+		{"id":2,"uri":"/b"},
+		{"uri":"/d"}
+	]}`)
+
+	tests := []struct {
+		mode ConflictMode
+		want Action
+	}{
+		{ModeReturn, ActionConflict},
+		{ModeSkip, ActionSkip},
+		{ModeOverwrite, ActionUpdate},
+	}
+	for _, tt := range tests {
+		changes, err := plan(context.Background(), data, tt.mode, rangeStore)
+		if !assert.NoError(t, err) || !assert.Len(t, changes, 4) {
+			continue
+		}
+		// 按key排序，未指定id的在最前
+		assert.Equal(t, &Change{HubKey: store2.HubKeyRoute, Key: "", Action: ActionCreate}, changes[0])
+		assert.Equal(t, &Change{
+			HubKey: store2.HubKeyRoute,
+			Key:    "1",
+			Action: tt.want,
+			Diff:   []string{`+ desc: "new"`, `- name: "r1"`, `- uri: "/a"`, `+ uri: "/a2"`},
+		}, changes[1])
+		assert.Equal(t, &Change{HubKey: store2.HubKeyRoute, Key: "2", Action: tt.want}, changes[2])
+		assert.Equal(t, &Change{HubKey: store2.HubKeyRoute, Key: "3", Action: ActionCreate}, changes[3])
+	}
+
+	_, err := plan(context.Background(), []byte(`{`), ModeReturn, rangeStore)
+	assert.Error(t, err)
+}
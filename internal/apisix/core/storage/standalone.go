@@ -0,0 +1,350 @@
+// Package storage
+//
+// @author: xwc1125
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chain5j/chain5j-pkg/util/convutil"
+	"github.com/chain5j/logger"
+	"github.com/fsnotify/fsnotify"
+	"github.com/xwc1125/apisix-go/internal/apisix/utils/closer"
+	"github.com/xwc1125/apisix-go/internal/apisix/utils/runtime"
+	"gopkg.in/yaml.v3"
+)
+
+// standaloneEndFlag 与APISIX一致，yaml文件需要以"#END"结尾，避免读取到写了一半的文件
+const standaloneEndFlag = "#END"
+
+// standaloneSections 配置文件中的配置项与etcd中目录的对应关系
+var standaloneSections = map[string]string{
+	"routes":         "routes",
+	"services":       "services",
+	"upstreams":      "upstreams",
+	"consumers":      "consumers",
+	"ssls":           "ssl",
+	"global_rules":   "global_rules",
+	"plugin_configs": "plugin_configs",
+	"protos":         "proto",
+	"stream_routes":  "stream_routes",
+}
+
+var (
+	// ErrReadOnly standalone模式下配置只能通过修改配置文件变更
+	ErrReadOnly = errors.New("storage is read-only in standalone mode")
+
+	standaloneStorage *StandaloneStorage
+)
+
+// StandaloneConfig standalone模式的配置
+type StandaloneConfig struct {
+	Enable     bool   `json:"enable" mapstructure:"enable" yaml:"enable"`
+	ConfigFile string `json:"config_file" mapstructure:"config_file" yaml:"config_file"`
+}
+
+// StandaloneStorage 不依赖etcd，从本地的yaml或json文件读取配置的storage。
+// 数据按etcd中的路径组织，如：/apisix/routes/1，文件变化时重新加载并通过Watch发送变更的事件
+type StandaloneStorage struct {
+	path    string
+	prefix  string
+	watcher *fsnotify.Watcher
+
+	mu       sync.RWMutex
+	data     map[string]string
+	watchers map[*standaloneWatcher]struct{}
+}
+
+type standaloneWatcher struct {
+	ctx    context.Context
+	prefix string
+	ch     chan WatchResponse
+}
+
+// InitStandaloneStorage 初始化standalone模式，之后GenStorage返回该storage
+func InitStandaloneStorage(path, prefix string) error {
+	s, err := NewStandaloneStorage(path, prefix)
+	if err != nil {
+		return err
+	}
+	standaloneStorage = s
+	closer.AppendToClosers(s.Close)
+	return nil
+}
+
+// GenStorage store使用的storage，standalone模式下为配置文件，否则为etcd
+func GenStorage() Interface {
+	if standaloneStorage != nil {
+		return standaloneStorage
+	}
+	return GenEtcdStorage()
+}
+
+// NewStandaloneStorage 加载配置文件并监听文件的变化，prefix为etcd中的前缀，如：/apisix
+func NewStandaloneStorage(path, prefix string) (*StandaloneStorage, error) {
+	s := &StandaloneStorage{
+		path:     filepath.Clean(path),
+		prefix:   prefix,
+		watchers: make(map[*standaloneWatcher]struct{}),
+	}
+	data, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.data = data
+
+	// 监听目录，编辑器保存或者替换文件时文件会被重新创建
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(s.path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	s.watcher = watcher
+	go s.watchFile()
+	return s, nil
+}
+
+func (s *StandaloneStorage) Close() error {
+	return s.watcher.Close()
+}
+
+func (s *StandaloneStorage) Get(_ context.Context, key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.data[key]
+	if !ok {
+		return "", fmt.Errorf("key: %s is not found", key)
+	}
+	return value, nil
+}
+
+func (s *StandaloneStorage) List(_ context.Context, key string) ([]Keypair, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ret []Keypair
+	for k, v := range s.data {
+		if strings.HasPrefix(k, key) {
+			ret = append(ret, Keypair{Key: k, Value: v})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+	return ret, nil
+}
+
+func (s *StandaloneStorage) Create(context.Context, string, string) error {
+	return ErrReadOnly
+}
+
+func (s *StandaloneStorage) Update(context.Context, string, string) error {
+	return ErrReadOnly
+}
+
+func (s *StandaloneStorage) BatchDelete(context.Context, []string) error {
+	return ErrReadOnly
+}
+
+// Watch 监听前缀为key的配置的变化，ctx结束后关闭返回的channel
+func (s *StandaloneStorage) Watch(ctx context.Context, key string) <-chan WatchResponse {
+	w := &standaloneWatcher{
+		ctx:    ctx,
+		prefix: key,
+		ch:     make(chan WatchResponse, 1),
+	}
+	s.mu.Lock()
+	s.watchers[w] = struct{}{}
+	s.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		delete(s.watchers, w)
+		close(w.ch)
+		s.mu.Unlock()
+	}()
+	return w.ch
+}
+
+// watchFile 配置文件变化时重新加载
+func (s *StandaloneStorage) watchFile() {
+	defer runtime.HandlePanic()
+	for {
+		select {
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != s.path || !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+			if err := s.reload(); err != nil {
+				logger.Warn("reload standalone config failed, keep the current config", "path", s.path, "err", err)
+			}
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Warn("watch standalone config err", "path", s.path, "err", err)
+		}
+	}
+}
+
+// reload 重新加载配置文件，并将变更发送给Watch的调用者
+func (s *StandaloneStorage) reload() error {
+	data, err := s.load()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	events := diffEvents(s.data, data)
+	s.data = data
+	s.mu.Unlock()
+	if len(events) == 0 {
+		return nil
+	}
+	logger.Info("standalone config reloaded", "path", s.path, "changes", len(events))
+
+	// 持有读锁，避免Watch的channel在发送时被关闭
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for w := range s.watchers {
+		var resp WatchResponse
+		for _, e := range events {
+			if strings.HasPrefix(e.Key, w.prefix) {
+				resp.Events = append(resp.Events, e)
+			}
+		}
+		if len(resp.Events) == 0 {
+			continue
+		}
+		select {
+		case w.ch <- resp:
+		case <-w.ctx.Done():
+		}
+	}
+	return nil
+}
+
+// diffEvents 比较新旧配置，生成put和delete事件
+func diffEvents(old, new map[string]string) []Event {
+	var events []Event
+	for key, value := range new {
+		if oldValue, ok := old[key]; !ok || oldValue != value {
+			events = append(events, Event{Keypair: Keypair{Key: key, Value: value}, Type: EventTypePut})
+		}
+	}
+	for key := range old {
+		if _, ok := new[key]; !ok {
+			events = append(events, Event{Keypair: Keypair{Key: key}, Type: EventTypeDelete})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Key < events[j].Key
+	})
+	return events
+}
+
+// load 读取并解析配置文件
+func (s *StandaloneStorage) load() (map[string]string, error) {
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	requireEnd := filepath.Ext(s.path) != ".json"
+	return parseStandalone(content, s.prefix, requireEnd)
+}
+
+// parseStandalone 解析配置，每个对象以JSON保存在"前缀/目录/id"下，consumer使用username作为id
+func parseStandalone(content []byte, prefix string, requireEnd bool) (map[string]string, error) {
+	if requireEnd && !hasEndFlag(content) {
+		return nil, fmt.Errorf("config file must end with %s", standaloneEndFlag)
+	}
+	var conf map[string]interface{}
+	if err := yaml.Unmarshal(content, &conf); err != nil {
+		return nil, err
+	}
+	data := make(map[string]string)
+	for section, items := range conf {
+		dir, ok := standaloneSections[section]
+		if !ok {
+			logger.Warn("unknown section in standalone config, skip", "section", section)
+			continue
+		}
+		if items == nil {
+			continue
+		}
+		list, ok := items.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be a list", section)
+		}
+		for i, item := range list {
+			obj, ok := normalizeYaml(item).(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s[%d] must be an object", section, i)
+			}
+			idField := "id"
+			if section == "consumers" {
+				idField = "username"
+			}
+			id := ""
+			if v, ok := obj[idField]; ok && v != nil {
+				id = convutil.ToString(v)
+			}
+			if len(id) == 0 {
+				return nil, fmt.Errorf("%s[%d]: %s is required", section, i, idField)
+			}
+			obj[idField] = id
+			key := prefix + "/" + dir + "/" + id
+			if _, ok := data[key]; ok {
+				return nil, fmt.Errorf("%s[%d]: duplicate %s %s", section, i, idField, id)
+			}
+			value, err := json.Marshal(obj)
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d]: %w", section, i, err)
+			}
+			data[key] = string(value)
+		}
+	}
+	return data, nil
+}
+
+// hasEndFlag 最后一个非空行是否为#END
+func hasEndFlag(content []byte) bool {
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	return string(bytes.TrimSpace(lines[len(lines)-1])) == standaloneEndFlag
+}
+
+// normalizeYaml 将yaml中非字符串key的map转换为map[string]interface{}，以便序列化为JSON
+func normalizeYaml(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, item := range t {
+			t[k] = normalizeYaml(item)
+		}
+		return t
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			m[convutil.ToString(k)] = normalizeYaml(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range t {
+			t[i] = normalizeYaml(item)
+		}
+		return t
+	}
+	return v
+}
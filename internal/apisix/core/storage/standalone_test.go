@@ -0,0 +1,146 @@
+// Package storage
+//
+// @author: xwc1125
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const standaloneYaml = `
+routes:
+  - id: 1
+    uri: /hello
+    upstream_id: u1
+upstreams:
+  - id: u1
+    type: roundrobin
+    nodes:
+      "127.0.0.1:1980": 1
+consumers:
+  - username: jack
+    plugins:
+      key-auth:
+        key: user-key
+ssls:
+  - id: 1
+    sni: example.com
+#END
+`
+
+func writeFile(t *testing.T, path, content string) {
+	// 先写入临时文件再替换，与部署工具的行为一致
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseStandalone(t *testing.T) {
+	data, err := parseStandalone([]byte(standaloneYaml), "/apisix", true)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]string{
+		"/apisix/routes/1":       `{"id":"1","upstream_id":"u1","uri":"/hello"}`,
+		"/apisix/upstreams/u1":   `{"id":"u1","nodes":{"127.0.0.1:1980":1},"type":"roundrobin"}`,
+		"/apisix/consumers/jack": `{"plugins":{"key-auth":{"key":"user-key"}},"username":"jack"}`,
+		"/apisix/ssl/1":          `{"id":"1","sni":"example.com"}`,
+	}, data)
+
+	data, err = parseStandalone([]byte(`{"routes":[{"id":1,"uri":"/a"}]}`), "/apisix", false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{"/apisix/routes/1": `{"id":"1","uri":"/a"}`}, data)
+	}
+
+	for _, content := range []string{
+		"routes:\n  - id: 1\n    uri: /hello\n",
+		"routes:\n  - uri: /hello\n#END",
+		"routes:\n  - id: 1\n  - id: 1\n#END",
+		"routes:\n  id: 1\n#END",
+		"consumers:\n  - plugins: {}\n#END",
+	} {
+		_, err := parseStandalone([]byte(content), "/apisix", true)
+		assert.Error(t, err, content)
+	}
+}
+
+func TestStandaloneStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apisix.yaml")
+	writeFile(t, path, standaloneYaml)
+	s, err := NewStandaloneStorage(path, "/apisix")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	value, err := s.Get(ctx, "/apisix/routes/1")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":"1","upstream_id":"u1","uri":"/hello"}`, value)
+	_, err = s.Get(ctx, "/apisix/routes/2")
+	assert.Error(t, err)
+	list, err := s.List(ctx, "/apisix/routes")
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+
+	assert.Equal(t, ErrReadOnly, s.Create(ctx, "/apisix/routes/2", "{}"))
+	assert.Equal(t, ErrReadOnly, s.Update(ctx, "/apisix/routes/1", "{}"))
+	assert.Equal(t, ErrReadOnly, s.BatchDelete(ctx, []string{"/apisix/routes/1"}))
+
+	routes := s.Watch(ctx, "/apisix/routes")
+	upstreams := s.Watch(ctx, "/apisix/upstreams")
+
+	// 修改route 1，新增route 2，删除upstream u1
+	writeFile(t, path, `
+routes:
+  - id: 1
+    uri: /hello2
+  - id: 2
+    uri: /world
+#END
+`)
+	select {
+	case resp := <-routes:
+		assert.Equal(t, []Event{
+			{Keypair: Keypair{Key: "/apisix/routes/1", Value: `{"id":"1","uri":"/hello2"}`}, Type: EventTypePut},
+			{Keypair: Keypair{Key: "/apisix/routes/2", Value: `{"id":"2","uri":"/world"}`}, Type: EventTypePut},
+		}, resp.Events)
+	case <-time.After(5 * time.Second):
+		t.Fatal("route events timeout")
+	}
+	select {
+	case resp := <-upstreams:
+		assert.Equal(t, []Event{
+			{Keypair: Keypair{Key: "/apisix/upstreams/u1"}, Type: EventTypeDelete},
+		}, resp.Events)
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream events timeout")
+	}
+
+	// 无效的配置不会被加载
+	writeFile(t, path, "routes:\n  - id: 3\n    uri: /partial\n")
+	time.Sleep(200 * time.Millisecond)
+	list, err = s.List(ctx, "/apisix/routes")
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+
+	// ctx结束后关闭channel
+	cancel()
+	select {
+	case _, ok := <-routes:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch channel not closed")
+	}
+}
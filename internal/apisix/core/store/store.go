@@ -98,7 +98,7 @@ func NewGenericStore(opt GenericStoreOption) (*GenericStore, error) {
 	s := &GenericStore{
 		opt: opt,
 	}
-	s.Stg = storage2.GenEtcdStorage()
+	s.Stg = storage2.GenStorage()
 
 	return s, nil
 }
@@ -278,6 +278,11 @@ func (s *GenericStore) ingestValidate(obj interface{}) (err error) {
 	return err
 }
 
+// Validate 校验对象是否满足schema及StockCheck，用于在写入前返回校验错误
+func (s *GenericStore) Validate(obj interface{}) error {
+	return s.ingestValidate(obj)
+}
+
 func (s *GenericStore) CreateCheck(obj interface{}) ([]byte, error) {
 
 	if setter, ok := obj.(entity.GetBaseInfo); ok {
@@ -394,6 +399,11 @@ func (s *GenericStore) StringToObjPtr(str, key string) (interface{}, error) {
 	return ret, nil
 }
 
+// GetObjKey 对象在store中的key
+func (s *GenericStore) GetObjKey(obj interface{}) string {
+	return s.opt.KeyFunc(obj)
+}
+
 func (s *GenericStore) GetObjStorageKey(obj interface{}) string {
 	return s.GetStorageKey(s.opt.KeyFunc(obj))
 }
@@ -26,6 +26,7 @@ import (
 	"github.com/tidwall/gjson"
 	"github.com/xeipuuv/gojsonschema"
 	entity2 "github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/utils/expr"
 	"go.uber.org/zap/buffer"
 )
 
@@ -231,6 +232,9 @@ func checkConf(schema gjson.Result, reqBody interface{}) error {
 		if err := checkRemoteAddr(route.RemoteAddrs); err != nil {
 			return err
 		}
+		if _, err := expr.Compile(route.Vars); err != nil {
+			return fmt.Errorf("schema validate failed: invalid field vars: %s", err)
+		}
 	case *entity2.Service:
 		service := reqBody.(*entity2.Service)
 		if err := checkUpstream(schema, service.Upstream); err != nil {
@@ -0,0 +1,340 @@
+// Package healthcheck
+//
+// @author: xwc1125
+package healthcheck
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+const (
+	StatusHealthy   = "healthy"
+	StatusUnhealthy = "unhealthy"
+
+	checkTypeHTTP  = "http"
+	checkTypeHTTPS = "https"
+	checkTypeTCP   = "tcp"
+)
+
+// Counter 节点当前累计的检查结果，节点状态变化后清零
+type Counter struct {
+	Success        int `json:"success"`
+	HTTPFailure    int `json:"http_failure"`
+	TCPFailure     int `json:"tcp_failure"`
+	TimeoutFailure int `json:"timeout_failure"`
+}
+
+func (c *Counter) add(r result) int {
+	switch r {
+	case resultSuccess:
+		c.Success++
+		return c.Success
+	case resultHTTPFailure:
+		c.HTTPFailure++
+		return c.HTTPFailure
+	case resultTCPFailure:
+		c.TCPFailure++
+		return c.TCPFailure
+	case resultTimeout:
+		c.TimeoutFailure++
+		return c.TimeoutFailure
+	}
+	return 0
+}
+
+// node 上游节点的健康状态
+type node struct {
+	host string
+	port int
+
+	healthy atomic.Bool
+	mu      sync.Mutex
+	counter Counter
+}
+
+func (n *node) addr() string {
+	return net.JoinHostPort(n.host, strconv.Itoa(n.port))
+}
+
+// Checker 一组上游节点的健康检查器。
+// 节点的下标与创建时传入的nodes一致，供负载均衡过滤不健康的节点
+type Checker struct {
+	key     string
+	name    string
+	checks  entity.HealthChecker
+	active  thresholds
+	passive thresholds
+	nodes   []*node
+	client  *fasthttp.Client
+
+	refs     int
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// enabled 配置是否启用，未配置的检查为零值
+func enabled(v interface{}) bool {
+	return !reflect.ValueOf(v).IsZero()
+}
+
+func newChecker(key, name string, checks entity.HealthChecker, nodes []*entity.Node) *Checker {
+	c := &Checker{
+		key:     key,
+		name:    name,
+		checks:  checks,
+		active:  activeThresholds(checks.Active),
+		passive: passiveThresholds(checks.Passive),
+		nodes:   make([]*node, len(nodes)),
+		stop:    make(chan struct{}),
+	}
+	for i, n := range nodes {
+		c.nodes[i] = &node{host: n.Host, port: n.Port}
+		c.nodes[i].healthy.Store(true)
+	}
+	if enabled(checks.Active) {
+		a := checks.Active
+		tlsConfig := &tls.Config{InsecureSkipVerify: !verifyCertificate(a)}
+		if len(a.Host) > 0 {
+			tlsConfig.ServerName = a.Host
+		}
+		c.client = &fasthttp.Client{
+			Name:      "apisix-healthcheck",
+			TLSConfig: tlsConfig,
+		}
+	}
+	return c
+}
+
+// Name 检查器的名称
+func (c *Checker) Name() string {
+	return c.name
+}
+
+// Healthy 下标为idx的节点是否健康
+func (c *Checker) Healthy(idx int) bool {
+	if c == nil || idx < 0 || idx >= len(c.nodes) {
+		return true
+	}
+	return c.nodes[idx].healthy.Load()
+}
+
+// ReportStatus 被动检查，记录转发到下标为idx的节点的请求返回的状态码
+func (c *Checker) ReportStatus(idx int, statusCode int) {
+	if n := c.passiveNode(idx); n != nil {
+		if c.checks.Passive.Type == checkTypeTCP {
+			// tcp类型只关心连接是否成功
+			c.report(n, resultSuccess, c.passive)
+			return
+		}
+		if r, ok := c.passive.classify(statusCode); ok {
+			c.report(n, r, c.passive)
+		}
+	}
+}
+
+// grpcFailureStatuses 表示上游服务异常的gRPC状态码：UNKNOWN、RESOURCE_EXHAUSTED、INTERNAL、UNAVAILABLE、DATA_LOSS
+var grpcFailureStatuses = map[int]struct{}{2: {}, 8: {}, 13: {}, 14: {}, 15: {}}
+
+// ReportGRPCStatus 被动检查，记录gRPC请求的结果，grpcStatus为响应的grpc-status，没有时为-1。
+// HTTP状态码不为200或没有grpc-status时与ReportStatus一致，grpc-status表示服务端异常时记为http失败
+func (c *Checker) ReportGRPCStatus(idx int, statusCode int, grpcStatus int) {
+	n := c.passiveNode(idx)
+	if n == nil {
+		return
+	}
+	if _, ok := grpcFailureStatuses[grpcStatus]; ok && statusCode == fasthttp.StatusOK && c.checks.Passive.Type != checkTypeTCP {
+		c.report(n, resultHTTPFailure, c.passive)
+		return
+	}
+	c.ReportStatus(idx, statusCode)
+}
+
+// ReportError 被动检查，记录转发到下标为idx的节点的请求出错，区分超时和连接失败
+func (c *Checker) ReportError(idx int, err error) {
+	if n := c.passiveNode(idx); n != nil && err != nil {
+		c.report(n, errResult(err), c.passive)
+	}
+}
+
+// passiveNode 启用了被动检查时，返回下标为idx的节点
+func (c *Checker) passiveNode(idx int) *node {
+	if c == nil || !enabled(c.checks.Passive) || idx < 0 || idx >= len(c.nodes) {
+		return nil
+	}
+	return c.nodes[idx]
+}
+
+// report 记录一次结果，达到阈值时改变节点的状态
+func (c *Checker) report(n *node, r result, t thresholds) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	healthy := n.healthy.Load()
+	if r == resultSuccess {
+		if healthy {
+			// 健康的节点成功时清除失败的计数
+			n.counter = Counter{}
+			return
+		}
+	} else if !healthy {
+		// 不健康的节点失败时清除成功的计数
+		n.counter = Counter{}
+		return
+	} else {
+		n.counter.Success = 0
+	}
+
+	count := n.counter.add(r)
+	limit := t.limit(r)
+	if limit <= 0 || count < limit {
+		return
+	}
+	n.healthy.Store(r == resultSuccess)
+	n.counter = Counter{}
+	log().Info("upstream node status changed", "checker", c.name, "node", n.addr(), "healthy", r == resultSuccess, "result", r, "count", count)
+}
+
+// start 启动主动检查
+func (c *Checker) start() {
+	if c.client == nil {
+		return
+	}
+	go c.run()
+}
+
+func (c *Checker) close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		if c.client != nil {
+			c.client.CloseIdleConnections()
+		}
+	})
+}
+
+func (c *Checker) run() {
+	a := c.checks.Active
+	healthyTicker := time.NewTicker(interval(a.Healthy.Interval))
+	defer healthyTicker.Stop()
+	unhealthyTicker := time.NewTicker(interval(a.UnHealthy.Interval))
+	defer unhealthyTicker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-healthyTicker.C:
+			c.probeAll(true)
+		case <-unhealthyTicker.C:
+			c.probeAll(false)
+		}
+	}
+}
+
+// probeAll 并行检查状态为healthy的节点，并行数为active.concurrency
+func (c *Checker) probeAll(healthy bool) {
+	concurrency := c.checks.Active.Concurrency
+	if concurrency <= 0 {
+		concurrency = 10
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, n := range c.nodes {
+		if n.healthy.Load() != healthy {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(n *node) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if r, ok := c.probe(n); ok {
+				c.report(n, r, c.active)
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+// probe 对节点进行一次主动检查，返回的状态码不在任一列表中时忽略本次结果
+func (c *Checker) probe(n *node) (result, bool) {
+	a := c.checks.Active
+	timeout := time.Second
+	if a.Timeout > 0 {
+		timeout = time.Duration(float64(a.Timeout) * float64(time.Second))
+	}
+	addr := n.addr()
+	if a.Port > 0 {
+		addr = net.JoinHostPort(n.host, strconv.Itoa(a.Port))
+	}
+
+	if a.Type == checkTypeTCP {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return errResult(err), true
+		}
+		_ = conn.Close()
+		return resultSuccess, true
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	scheme := checkTypeHTTP
+	if a.Type == checkTypeHTTPS {
+		scheme = checkTypeHTTPS
+	}
+	path := a.HTTPPath
+	if len(path) == 0 {
+		path = "/"
+	}
+	req.SetRequestURI(fmt.Sprintf("%s://%s%s", scheme, addr, path))
+	if len(a.Host) > 0 {
+		req.UseHostHeader = true
+		req.Header.SetHost(a.Host)
+	}
+	for _, h := range a.ReqHeaders {
+		if k, v, ok := strings.Cut(h, ":"); ok {
+			req.Header.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+	}
+	req.SetConnectionClose()
+	resp.SkipBody = true
+	if err := c.client.DoTimeout(req, resp, timeout); err != nil {
+		return errResult(err), true
+	}
+	return c.active.classify(resp.StatusCode())
+}
+
+// errResult 请求出错时的结果
+func errResult(err error) result {
+	var netErr net.Error
+	if errors.Is(err, fasthttp.ErrTimeout) || errors.Is(err, fasthttp.ErrDialTimeout) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return resultTimeout
+	}
+	return resultTCPFailure
+}
+
+// verifyCertificate 与APISIX一致，未配置https_verify_certificate时校验证书
+func verifyCertificate(a entity.Active) bool {
+	return a.HTTPSVerifyCertificate == nil || *a.HTTPSVerifyCertificate
+}
+
+func interval(seconds int) time.Duration {
+	if seconds <= 0 {
+		return time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,129 @@
+// Package healthcheck
+//
+// @author: xwc1125
+package healthcheck
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+// checkers 相同的检查配置和节点共用一个Checker，避免多个路由重复检查同一个上游
+var checkers = struct {
+	sync.Mutex
+	m map[string]*Checker
+}{m: make(map[string]*Checker)}
+
+// Acquire 获取上游的健康检查器并启动检查，upstream未配置checks时返回nil。
+// 不再使用时需要调用Release
+func Acquire(name string, upstream *entity.UpstreamDef, nodes []*entity.Node) *Checker {
+	if upstream == nil || upstream.Checks == nil || !enabled(*upstream.Checks) || len(nodes) == 0 {
+		return nil
+	}
+	key := checkerKey(upstream.Checks, nodes)
+
+	checkers.Lock()
+	defer checkers.Unlock()
+	c, ok := checkers.m[key]
+	if !ok {
+		c = newChecker(key, name, *upstream.Checks, nodes)
+		checkers.m[key] = c
+		c.start()
+		log().Info("health checker started", "name", name, "nodes", len(nodes))
+	}
+	c.refs++
+	return c
+}
+
+// Release 释放Acquire获取的检查器，没有使用者时停止检查
+func (c *Checker) Release() {
+	if c == nil {
+		return
+	}
+	checkers.Lock()
+	defer checkers.Unlock()
+	c.refs--
+	if c.refs > 0 {
+		return
+	}
+	delete(checkers.m, c.key)
+	c.close()
+	log().Info("health checker stopped", "name", c.name)
+}
+
+func checkerKey(checks *entity.HealthChecker, nodes []*entity.Node) string {
+	addrs := make([]string, len(nodes))
+	for i, n := range nodes {
+		addrs[i] = n.Host + ":" + strconv.Itoa(n.Port)
+	}
+	key, _ := json.Marshal(struct {
+		Checks *entity.HealthChecker `json:"checks"`
+		Nodes  []string              `json:"nodes"`
+	}{checks, addrs})
+	return string(key)
+}
+
+// NodeStatus 节点的健康状态
+type NodeStatus struct {
+	IP      string  `json:"ip"`
+	Port    int     `json:"port"`
+	Status  string  `json:"status"`
+	Counter Counter `json:"counter"`
+}
+
+// Status 检查器的健康状态，格式与APISIX的Control API一致
+type Status struct {
+	Name  string       `json:"name"`
+	Type  string       `json:"type"`
+	Nodes []NodeStatus `json:"nodes"`
+}
+
+// Status 当前各节点的健康状态
+func (c *Checker) Status() Status {
+	typ := c.checks.Active.Type
+	if len(typ) == 0 {
+		typ = checkTypeHTTP
+	}
+	status := Status{
+		Name:  c.name,
+		Type:  typ,
+		Nodes: make([]NodeStatus, len(c.nodes)),
+	}
+	for i, n := range c.nodes {
+		n.mu.Lock()
+		status.Nodes[i] = NodeStatus{
+			IP:      n.host,
+			Port:    n.port,
+			Status:  StatusUnhealthy,
+			Counter: n.counter,
+		}
+		n.mu.Unlock()
+		if n.healthy.Load() {
+			status.Nodes[i].Status = StatusHealthy
+		}
+	}
+	return status
+}
+
+// Statuses 所有检查器的健康状态，按名称排序
+func Statuses() []Status {
+	checkers.Lock()
+	list := make([]*Checker, 0, len(checkers.m))
+	for _, c := range checkers.m {
+		list = append(list, c)
+	}
+	checkers.Unlock()
+
+	statuses := make([]Status, len(list))
+	for i, c := range list {
+		statuses[i] = c.Status()
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
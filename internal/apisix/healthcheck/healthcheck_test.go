@@ -0,0 +1,180 @@
+// Package healthcheck
+//
+// @author: xwc1125
+package healthcheck
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+func testNode(t *testing.T, rawURL string) *entity.Node {
+	host, port, err := net.SplitHostPort(rawURL[len("http://"):])
+	assert.NoError(t, err)
+	p, _ := strconv.Atoi(port)
+	return &entity.Node{Host: host, Port: p, Weight: 1}
+}
+
+func TestCheckerReport(t *testing.T) {
+	c := newChecker("k", "test", entity.HealthChecker{}, []*entity.Node{{Host: "127.0.0.1", Port: 80}})
+	n := c.nodes[0]
+	tr := activeThresholds(entity.Active{})
+
+	// 连续2次TCP失败后不健康，中间的成功会清除失败计数
+	c.report(n, resultTCPFailure, tr)
+	c.report(n, resultSuccess, tr)
+	c.report(n, resultTCPFailure, tr)
+	assert.True(t, c.Healthy(0))
+	c.report(n, resultTCPFailure, tr)
+	assert.False(t, c.Healthy(0))
+
+	// 连续2次成功后恢复健康
+	c.report(n, resultSuccess, tr)
+	c.report(n, resultTimeout, tr)
+	c.report(n, resultSuccess, tr)
+	assert.False(t, c.Healthy(0))
+	c.report(n, resultSuccess, tr)
+	assert.True(t, c.Healthy(0))
+	assert.Equal(t, Counter{}, n.counter)
+}
+
+func TestCheckerProbe(t *testing.T) {
+	var status int32 = http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/status", r.URL.Path)
+		assert.Equal(t, "example.com", r.Host)
+		assert.Equal(t, "curl/7.29.0", r.Header.Get("User-Agent"))
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+
+	checks := &entity.HealthChecker{Active: entity.Active{
+		HTTPPath:   "/status",
+		Host:       "example.com",
+		ReqHeaders: []string{"User-Agent: curl/7.29.0"},
+	}}
+	upstream := &entity.UpstreamDef{Checks: checks}
+	nodes := []*entity.Node{testNode(t, srv.URL)}
+	c := Acquire("/apisix/routes/1", upstream, nodes)
+	assert.NotNil(t, c)
+	assert.Same(t, c, Acquire("/apisix/routes/2", upstream, nodes))
+	defer c.Release()
+	defer c.Release()
+
+	r, ok := c.probe(c.nodes[0])
+	assert.True(t, ok)
+	assert.Equal(t, resultSuccess, r)
+
+	atomic.StoreInt32(&status, http.StatusBadGateway)
+	r, ok = c.probe(c.nodes[0])
+	assert.True(t, ok)
+	assert.Equal(t, resultHTTPFailure, r)
+
+	// 不在任一列表中的状态码被忽略
+	atomic.StoreInt32(&status, http.StatusTeapot)
+	_, ok = c.probe(c.nodes[0])
+	assert.False(t, ok)
+
+	atomic.StoreInt32(&status, http.StatusBadGateway)
+	for i := 0; i < 5; i++ {
+		c.probeAll(true)
+	}
+	statuses := Statuses()
+	if assert.Len(t, statuses, 1) {
+		assert.Equal(t, "/apisix/routes/1", statuses[0].Name)
+		assert.Equal(t, StatusUnhealthy, statuses[0].Nodes[0].Status)
+	}
+
+	// 关闭的端口为TCP失败
+	srv.Close()
+	r, _ = c.probe(c.nodes[0])
+	assert.Equal(t, resultTCPFailure, r)
+}
+
+func TestAcquireWithoutChecks(t *testing.T) {
+	nodes := []*entity.Node{{Host: "127.0.0.1", Port: 80}}
+	assert.Nil(t, Acquire("r", &entity.UpstreamDef{}, nodes))
+	assert.Nil(t, Acquire("r", &entity.UpstreamDef{Checks: &entity.HealthChecker{}}, nodes))
+	var c *Checker
+	assert.True(t, c.Healthy(0))
+	c.Release()
+}
+
+func TestCheckerPassive(t *testing.T) {
+	nodes := []*entity.Node{{Host: "127.0.0.1", Port: 80}, {Host: "127.0.0.1", Port: 81}}
+	c := newChecker("k", "test", entity.HealthChecker{Passive: entity.Passive{
+		Healthy:   entity.Healthy{Successes: 1},
+		UnHealthy: entity.UnHealthy{HTTPStatuses: []int{502}, HTTPFailures: 2, Timeouts: 1},
+	}}, nodes)
+
+	c.ReportStatus(0, http.StatusInternalServerError) // 不在列表中，忽略
+	c.ReportStatus(0, http.StatusBadGateway)
+	assert.True(t, c.Healthy(0))
+	c.ReportStatus(0, http.StatusBadGateway)
+	assert.False(t, c.Healthy(0))
+	c.ReportStatus(0, http.StatusOK)
+	assert.True(t, c.Healthy(0))
+
+	c.ReportError(1, &net.OpError{Op: "dial", Err: timeoutErr{}})
+	assert.False(t, c.Healthy(1))
+
+	// 未启用被动检查时不记录
+	c = newChecker("k", "test", entity.HealthChecker{}, nodes)
+	for i := 0; i < 10; i++ {
+		c.ReportStatus(0, http.StatusServiceUnavailable)
+	}
+	assert.True(t, c.Healthy(0))
+}
+
+func TestCheckerPassiveGRPC(t *testing.T) {
+	nodes := []*entity.Node{{Host: "127.0.0.1", Port: 80}}
+	c := newChecker("k", "test", entity.HealthChecker{Passive: entity.Passive{
+		Healthy:   entity.Healthy{Successes: 1},
+		UnHealthy: entity.UnHealthy{HTTPStatuses: []int{502}, HTTPFailures: 2},
+	}}, nodes)
+
+	c.ReportGRPCStatus(0, http.StatusOK, 5) // NOT_FOUND是业务错误，不影响节点状态
+	c.ReportGRPCStatus(0, http.StatusOK, 14)
+	assert.True(t, c.Healthy(0))
+	c.ReportGRPCStatus(0, http.StatusOK, 13)
+	assert.False(t, c.Healthy(0))
+	c.ReportGRPCStatus(0, http.StatusOK, 0)
+	assert.True(t, c.Healthy(0))
+
+	// 没有grpc-status时按HTTP状态码处理
+	c.ReportGRPCStatus(0, http.StatusBadGateway, -1)
+	c.ReportGRPCStatus(0, http.StatusBadGateway, -1)
+	assert.False(t, c.Healthy(0))
+
+	var nilChecker *Checker
+	nilChecker.ReportGRPCStatus(0, http.StatusOK, 14)
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestCheckerVerifyCertificate(t *testing.T) {
+	verify, skip := true, false
+	for _, tt := range []struct {
+		verify *bool
+		want   bool
+	}{
+		{nil, false},
+		{&verify, false},
+		{&skip, true},
+	} {
+		checks := entity.HealthChecker{Active: entity.Active{Type: checkTypeHTTPS, HTTPSVerifyCertificate: tt.verify}}
+		c := newChecker("1", "test", checks, nil)
+		assert.Equal(t, tt.want, c.client.TLSConfig.InsecureSkipVerify)
+	}
+}
@@ -0,0 +1,10 @@
+// Package healthcheck
+//
+// @author: xwc1125
+package healthcheck
+
+import "github.com/chain5j/logger"
+
+func log() logger.Logger {
+	return logger.Log("healthcheck")
+}
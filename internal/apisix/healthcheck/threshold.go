@@ -0,0 +1,123 @@
+// Package healthcheck
+//
+// @author: xwc1125
+package healthcheck
+
+import (
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+// result 一次检查或请求的结果
+type result int
+
+const (
+	resultSuccess     result = iota // 成功
+	resultHTTPFailure               // 返回了不健康的状态码
+	resultTCPFailure                // 连接失败
+	resultTimeout                   // 超时
+)
+
+func (r result) String() string {
+	switch r {
+	case resultSuccess:
+		return "success"
+	case resultHTTPFailure:
+		return "http_failure"
+	case resultTCPFailure:
+		return "tcp_failure"
+	case resultTimeout:
+		return "timeout"
+	default:
+		return "unknown"
+	}
+}
+
+// thresholds 节点状态变化的阈值，值为0时表示不按此类结果改变状态
+type thresholds struct {
+	successes         int
+	httpFailures      int
+	tcpFailures       int
+	timeouts          int
+	healthyStatuses   map[int]bool
+	unhealthyStatuses map[int]bool
+}
+
+// activeThresholds 主动检查的阈值，默认值与APISIX一致
+func activeThresholds(a entity.Active) thresholds {
+	return newThresholds(a.Healthy, a.UnHealthy, thresholds{
+		successes:    2,
+		httpFailures: 5,
+		tcpFailures:  2,
+		timeouts:     3,
+	}, []int{200, 302}, []int{429, 404, 500, 501, 502, 503, 504, 505})
+}
+
+// passiveThresholds 被动检查的阈值，默认值与APISIX一致
+func passiveThresholds(p entity.Passive) thresholds {
+	return newThresholds(p.Healthy, p.UnHealthy, thresholds{
+		successes:    5,
+		httpFailures: 5,
+		tcpFailures:  2,
+		timeouts:     7,
+	}, []int{200, 201, 202, 203, 204, 205, 206, 207, 208, 226,
+		300, 301, 302, 303, 304, 305, 306, 307, 308}, []int{429, 500, 503})
+}
+
+func newThresholds(healthy entity.Healthy, unhealthy entity.UnHealthy, def thresholds, healthyStatuses, unhealthyStatuses []int) thresholds {
+	t := def
+	if healthy.Successes > 0 {
+		t.successes = healthy.Successes
+	}
+	if unhealthy.HTTPFailures > 0 {
+		t.httpFailures = unhealthy.HTTPFailures
+	}
+	if unhealthy.TCPFailures > 0 {
+		t.tcpFailures = unhealthy.TCPFailures
+	}
+	if unhealthy.Timeouts > 0 {
+		t.timeouts = unhealthy.Timeouts
+	}
+	if len(healthy.HttpStatuses) > 0 {
+		healthyStatuses = healthy.HttpStatuses
+	}
+	if len(unhealthy.HTTPStatuses) > 0 {
+		unhealthyStatuses = unhealthy.HTTPStatuses
+	}
+	t.healthyStatuses = statusSet(healthyStatuses)
+	t.unhealthyStatuses = statusSet(unhealthyStatuses)
+	return t
+}
+
+func statusSet(statuses []int) map[int]bool {
+	set := make(map[int]bool, len(statuses))
+	for _, s := range statuses {
+		set[s] = true
+	}
+	return set
+}
+
+// classify 根据状态码判断结果，状态码不在任一列表中时返回false
+func (t thresholds) classify(statusCode int) (result, bool) {
+	if t.healthyStatuses[statusCode] {
+		return resultSuccess, true
+	}
+	if t.unhealthyStatuses[statusCode] {
+		return resultHTTPFailure, true
+	}
+	return resultSuccess, false
+}
+
+// limit 结果对应的阈值
+func (t thresholds) limit(r result) int {
+	switch r {
+	case resultSuccess:
+		return t.successes
+	case resultHTTPFailure:
+		return t.httpFailures
+	case resultTCPFailure:
+		return t.tcpFailures
+	case resultTimeout:
+		return t.timeouts
+	}
+	return 0
+}
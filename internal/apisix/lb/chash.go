@@ -4,15 +4,41 @@
 package lb
 
 import (
-	"hash/fnv"
+	"crypto/md5"
+	"encoding/binary"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/valyala/fasthttp"
 	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
 )
 
+const (
+	// chashPoints 每个节点平均的虚拟节点数量，与ketama一致
+	chashPoints = 160
+
+	hashOnVars             = "vars"
+	hashOnHeader           = "header"
+	hashOnCookie           = "cookie"
+	hashOnConsumer         = "consumer"
+	hashOnVarsCombinations = "vars_combinations"
+)
+
+// point hash环上的虚拟节点
+type point struct {
+	hash uint32
+	idx  int64
+}
+
+// HashCBalance 一致性hash的负载均衡，使用ketama的方式将节点按权重放置到hash环上，
+// 节点增减时只有少量的key会重新映射
 type HashCBalance struct {
 	upstreamDef *entity.UpstreamDef
 	weights     []W
+	ring        []point // 按hash排序
 }
 
 // newHashCBalance
@@ -20,33 +46,141 @@ func newHashCBalance(upstreamDef *entity.UpstreamDef, weights []W) LoadBalance {
 	lb := HashCBalance{
 		upstreamDef: upstreamDef,
 		weights:     weights,
+		ring:        buildRing(weights),
 	}
 	return lb
 }
 
-func (lb HashCBalance) Distribute(req *fasthttp.Request) int64 {
-	l := len(lb.weights)
-	if 0 >= l {
-		return 0
+// buildRing 构建hash环。虚拟节点的数量与权重成正比，位置由节点的地址决定，
+// 因此节点增减时其他节点的虚拟节点基本不变
+func buildRing(weights []W) []point {
+	total := 0
+	for _, w := range weights {
+		if w.Weight() > 0 {
+			total += w.Weight()
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	ring := make([]point, 0, chashPoints*len(weights))
+	for idx, w := range weights {
+		if w.Weight() <= 0 {
+			continue
+		}
+		key := strconv.Itoa(idx)
+		if a, ok := w.(addr); ok {
+			key = a.Addr()
+		}
+		n := chashPoints * len(weights) * w.Weight() / total
+		if n == 0 {
+			n = 1
+		}
+		// 每个md5摘要生成4个虚拟节点
+		for i := 0; i < n; i += 4 {
+			digest := md5.Sum([]byte(key + "-" + strconv.Itoa(i/4)))
+			for j := 0; j < 4 && i+j < n; j++ {
+				ring = append(ring, point{
+					hash: binary.LittleEndian.Uint32(digest[j*4:]),
+					idx:  int64(idx),
+				})
+			}
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		if ring[i].hash == ring[j].hash {
+			return ring[i].idx < ring[j].idx
+		}
+		return ring[i].hash < ring[j].hash
+	})
+	return ring
+}
+
+func (lb HashCBalance) Distribute(req *fasthttp.Request, vars Vars) int64 {
+	if idx := lb.distribute(req, vars, nil); idx >= 0 {
+		return idx
+	}
+	return 0
+}
+
+func (lb HashCBalance) Done(idx int64, latency time.Duration, err error) {}
+
+// distribute 从key在hash环上的位置顺时针查找第一个allow允许的节点，跳过的节点不影响其他key的映射。
+// allow为nil时不过滤节点，没有可用的节点时返回-1
+func (lb HashCBalance) distribute(req *fasthttp.Request, vars Vars, allow func(idx int) bool) int64 {
+	if len(lb.ring) == 0 {
+		return -1
+	}
+	hash := crc32.ChecksumIEEE([]byte(lb.hashKey(vars)))
+	start := sort.Search(len(lb.ring), func(i int) bool {
+		return lb.ring[i].hash >= hash
+	})
+	for i := 0; i < len(lb.ring); i++ {
+		p := lb.ring[(start+i)%len(lb.ring)]
+		if allow == nil || allow(int(p.idx)) {
+			return p.idx
+		}
+	}
+	return -1
+}
+
+// hashKey 根据hash_on和key获取请求的hash key，与APISIX一致，为空时使用客户端的地址
+func (lb HashCBalance) hashKey(vars Vars) string {
+	if vars == nil {
+		return ""
 	}
-	hash := fnv.New32a()
-	hashOn := lb.upstreamDef.HashOn
 	key := lb.upstreamDef.Key
+	var hashKey string
+	switch lb.upstreamDef.HashOn {
+	case hashOnHeader:
+		hashKey = vars.Var("http_" + strings.ToLower(key))
+	case hashOnCookie:
+		hashKey = vars.Var("cookie_" + key)
+	case hashOnConsumer:
+		hashKey = vars.Var("consumer_name")
+	case hashOnVarsCombinations:
+		hashKey = resolveVars(key, vars)
+	default:
+		// 默认为vars，如：remote_addr、uri、arg_name
+		if len(key) > 0 {
+			hashKey = vars.Var(key)
+		}
+	}
+	if len(hashKey) == 0 {
+		return vars.Var("remote_addr")
+	}
+	return hashKey
+}
+
+// resolveVars 替换字符串中的变量，如：$request_uri$remote_addr、${http_host}:$uri
+func resolveVars(s string, vars Vars) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '{' {
+			if end := strings.IndexByte(s[i+2:], '}'); end >= 0 {
+				b.WriteString(vars.Var(s[i+2 : i+2+end]))
+				i += end + 2
+				continue
+			}
+		}
+		j := i + 1
+		for j < len(s) && isVarChar(s[j]) {
+			j++
+		}
+		if j == i+1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		b.WriteString(vars.Var(s[i+1 : j]))
+		i = j - 1
+	}
+	return b.String()
+}
 
-	var hashKey []byte
-	switch hashOn {
-	case "vars":
-		// todo
-		hashKey = req.PostArgs().Peek(key)
-	case "header":
-		hashKey = req.Header.Peek(key)
-	case "cookie":
-		// todo
-	case "consumer":
-		// todo
-	case "vars_combinations":
-		// todo
-	}
-	hash.Write(hashKey)
-	return int64(hash.Sum32() % uint32(l))
+func isVarChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
 }
@@ -0,0 +1,98 @@
+// Package lb
+//
+// @author: xwc1125
+package lb
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+// mapVars 测试用的请求变量
+type mapVars map[string]string
+
+func (v mapVars) Var(name string) string {
+	return v[name]
+}
+
+func chashNodes(weights ...int) []W {
+	ws := make([]W, len(weights))
+	for i, w := range weights {
+		ws[i] = Node("127.0.0.1:"+strconv.Itoa(1980+i), w, 0)
+	}
+	return ws
+}
+
+func TestHashCBalanceWeight(t *testing.T) {
+	b := NewBalancer(&entity.UpstreamDef{Type: CHash, Key: "arg_id"}, chashNodes(1, 3, 0))
+	counts := make([]int, 3)
+	for i := 0; i < 10000; i++ {
+		counts[b.Distribute(nil, mapVars{"arg_id": strconv.Itoa(i)})]++
+	}
+	// 权重为0的节点不会被选中，其余节点按权重分配
+	assert.Equal(t, 0, counts[2])
+	ratio := float64(counts[1]) / float64(counts[0])
+	assert.True(t, ratio > 2.4 && ratio < 3.6, "ratio %v", ratio)
+
+	// 相同的key选择相同的节点
+	vars := mapVars{"arg_id": "user"}
+	assert.Equal(t, b.Distribute(nil, vars), b.Distribute(nil, vars))
+}
+
+func TestHashCBalanceRemap(t *testing.T) {
+	upstream := &entity.UpstreamDef{Type: CHash, Key: "arg_id"}
+	nodes := chashNodes(1, 1, 1, 1, 1)
+	before := NewBalancer(upstream, nodes)
+	// 删除最后一个节点，其他节点的下标不变
+	after := NewBalancer(upstream, nodes[:4])
+
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		vars := mapVars{"arg_id": strconv.Itoa(i)}
+		from, to := before.Distribute(nil, vars), after.Distribute(nil, vars)
+		if from != 4 && from != to {
+			moved++
+		}
+	}
+	// 只有被删除节点上的key重新映射
+	assert.Equal(t, 0, moved)
+
+	// 节点的顺序变化不影响映射
+	reversed := []W{nodes[4], nodes[3], nodes[2], nodes[1], nodes[0]}
+	b := NewBalancer(upstream, reversed)
+	for i := 0; i < 1000; i++ {
+		vars := mapVars{"arg_id": strconv.Itoa(i)}
+		assert.Equal(t, 4-before.Distribute(nil, vars), b.Distribute(nil, vars))
+	}
+}
+
+func TestHashCBalanceHashOn(t *testing.T) {
+	vars := mapVars{
+		"remote_addr":   "10.0.0.1",
+		"uri":           "/hello",
+		"http_x-user":   "jack",
+		"cookie_sid":    "s1",
+		"consumer_name": "rose",
+	}
+	tests := []struct {
+		hashOn string
+		key    string
+		want   string
+	}{
+		{"", "uri", "/hello"},
+		{"vars", "uri", "/hello"},
+		{"vars", "arg_none", "10.0.0.1"},
+		{"header", "X-User", "jack"},
+		{"cookie", "sid", "s1"},
+		{"consumer", "", "rose"},
+		{"vars_combinations", "$uri-${remote_addr}$", "/hello-10.0.0.1$"},
+		{"vars_combinations", "$arg_none", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		b := newHashCBalance(&entity.UpstreamDef{Type: CHash, HashOn: tt.hashOn, Key: tt.key}, chashNodes(1, 1)).(HashCBalance)
+		assert.Equal(t, tt.want, b.hashKey(vars), tt.hashOn)
+	}
+}
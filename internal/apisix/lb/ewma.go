@@ -0,0 +1,116 @@
+// Package lb
+//
+// @author: xwc1125
+package lb
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fastrand"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+const (
+	// ewmaDecayTime 响应时间的衰减时间，与APISIX一致。长时间没有请求的节点分数逐渐降低，会被重新尝试
+	ewmaDecayTime = 10 * time.Second
+	// ewmaErrorLatency 转发失败时至少按该响应时间计算，避免快速失败的节点被优先选择
+	ewmaErrorLatency = time.Second
+)
+
+// ewmaBalance 按照响应时间的指数加权移动平均(EWMA)选择节点，与APISIX一致，
+// 随机选择两个节点，使用EWMA/权重较小的节点
+type ewmaBalance struct {
+	upstreamDef *entity.UpstreamDef
+
+	mutex   sync.Mutex
+	weights []int
+	ewma    []float64   // 响应时间的EWMA，单位毫秒
+	touched []time.Time // 最后一次更新EWMA的时间
+}
+
+func newEWMABalance(upstreamDef *entity.UpstreamDef, ws []W) LoadBalance {
+	return &ewmaBalance{
+		upstreamDef: upstreamDef,
+		weights:     positiveWeights(ws),
+		ewma:        make([]float64, len(ws)),
+		touched:     make([]time.Time, len(ws)),
+	}
+}
+
+func (b *ewmaBalance) Distribute(req *fasthttp.Request, vars Vars) int64 {
+	if idx := b.distribute(req, vars, nil); idx >= 0 {
+		return idx
+	}
+	return 0
+}
+
+func (b *ewmaBalance) distribute(req *fasthttp.Request, vars Vars, allow func(idx int) bool) int64 {
+	candidates := make([]int, 0, len(b.weights))
+	for idx, w := range b.weights {
+		if w > 0 && (allow == nil || allow(idx)) {
+			candidates = append(candidates, idx)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return -1
+	case 1:
+		return int64(candidates[0])
+	}
+
+	i := fastrand.Uint32n(uint32(len(candidates)))
+	j := fastrand.Uint32n(uint32(len(candidates) - 1))
+	if j >= i {
+		j++
+	}
+	first, second := candidates[i], candidates[j]
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	if b.score(second, now) < b.score(first, now) {
+		return int64(second)
+	}
+	return int64(first)
+}
+
+func (b *ewmaBalance) Done(idx int64, latency time.Duration, err error) {
+	if idx < 0 || int(idx) >= len(b.ewma) {
+		return
+	}
+	if err != nil && latency < ewmaErrorLatency {
+		latency = ewmaErrorLatency
+	}
+	rtt := float64(latency) / float64(time.Millisecond)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	if b.touched[idx].IsZero() {
+		b.ewma[idx] = rtt
+	} else {
+		w := b.decay(int(idx), now)
+		b.ewma[idx] = b.ewma[idx]*w + rtt*(1-w)
+	}
+	b.touched[idx] = now
+}
+
+// score 节点的分数，越小越优先
+func (b *ewmaBalance) score(idx int, now time.Time) float64 {
+	if b.touched[idx].IsZero() {
+		return 0
+	}
+	return b.ewma[idx] * b.decay(idx, now) / float64(b.weights[idx])
+}
+
+// decay 距离上次更新的衰减系数
+func (b *ewmaBalance) decay(idx int, now time.Time) float64 {
+	td := now.Sub(b.touched[idx])
+	if td < 0 {
+		td = 0
+	}
+	return math.Exp(-float64(td) / float64(ewmaDecayTime))
+}
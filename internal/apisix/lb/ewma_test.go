@@ -0,0 +1,47 @@
+// Package lb
+//
+// @author: xwc1125
+package lb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+func TestEWMABalance(t *testing.T) {
+	b := NewBalancer(&entity.UpstreamDef{Type: EWMA}, []W{Weight(1), Weight(1)}).(*ewmaBalance)
+	b.Done(0, 100*time.Millisecond, nil)
+	b.Done(1, 10*time.Millisecond, nil)
+	// 两个节点时总是选择响应时间短的节点
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, int64(1), b.Distribute(nil, nil))
+	}
+
+	// 响应变慢后选择另一个节点，EWMA按照距离上次更新的时间加权
+	for i := 0; i < 5; i++ {
+		b.touched[1] = b.touched[1].Add(-5 * time.Second)
+		b.Done(1, time.Second, nil)
+	}
+	assert.Equal(t, int64(0), b.Distribute(nil, nil))
+
+	// 转发失败的节点按较长的响应时间计算
+	e := NewBalancer(&entity.UpstreamDef{Type: EWMA}, []W{Weight(1), Weight(1)}).(*ewmaBalance)
+	e.Done(0, time.Millisecond, errors.New("connection refused"))
+	e.Done(1, 50*time.Millisecond, nil)
+	assert.Equal(t, int64(1), e.Distribute(nil, nil))
+
+	// 长时间没有请求的节点分数衰减，会被重新尝试
+	now := time.Now()
+	e.touched[0] = now.Add(-time.Minute)
+	assert.True(t, e.score(0, now) < e.score(1, now))
+
+	// 权重为0的节点不会被选择
+	z := NewBalancer(&entity.UpstreamDef{Type: EWMA}, []W{Weight(0), Weight(1), Weight(1)})
+	for i := 0; i < 20; i++ {
+		assert.NotEqual(t, int64(0), z.Distribute(nil, nil))
+	}
+}
@@ -2,6 +2,7 @@ package lb
 
 import (
 	"hash/fnv"
+	"time"
 
 	"github.com/valyala/fasthttp"
 	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
@@ -24,7 +25,7 @@ func newHashIPBalance(upstreamDef *entity.UpstreamDef, weights []W) LoadBalance
 }
 
 // Distribute select a server from servers using HashIPBalance
-func (lb HashIPBalance) Distribute(ctx *fasthttp.Request) int64 {
+func (lb HashIPBalance) Distribute(ctx *fasthttp.Request, vars Vars) int64 {
 	l := len(lb.weights)
 	if 0 >= l {
 		return 0
@@ -35,3 +36,5 @@ func (lb HashIPBalance) Distribute(ctx *fasthttp.Request) int64 {
 	hash.Write([]byte(key))
 	return int64(hash.Sum32() % uint32(l))
 }
+
+func (lb HashIPBalance) Done(idx int64, latency time.Duration, err error) {}
@@ -0,0 +1,46 @@
+// Package lb
+//
+// @author: xwc1125
+package lb
+
+import (
+	"github.com/valyala/fasthttp"
+)
+
+// healthBalance 跳过不健康节点的负载均衡
+type healthBalance struct {
+	LoadBalance
+	size    int
+	healthy func(idx int) bool
+}
+
+// WithHealth 使用healthy过滤balance选出的节点，size为节点数量。
+// 与APISIX一致，所有节点都不健康时仍按balance的结果选择
+func WithHealth(balance LoadBalance, size int, healthy func(idx int) bool) LoadBalance {
+	if balance == nil || healthy == nil || size <= 1 {
+		return balance
+	}
+	return &healthBalance{
+		LoadBalance: balance,
+		size:        size,
+		healthy:     healthy,
+	}
+}
+
+func (b *healthBalance) Distribute(req *fasthttp.Request, vars Vars) int64 {
+	if idx := Next(b.LoadBalance, req, vars, b.size, b.healthy); idx >= 0 {
+		return idx
+	}
+	return b.LoadBalance.Distribute(req, vars)
+}
+
+// distribute 优先选择allow允许的健康节点，都不健康时忽略健康状态
+func (b *healthBalance) distribute(req *fasthttp.Request, vars Vars, allow func(idx int) bool) int64 {
+	idx := Next(b.LoadBalance, req, vars, b.size, func(idx int) bool {
+		return allow(idx) && b.healthy(idx)
+	})
+	if idx >= 0 {
+		return idx
+	}
+	return Next(b.LoadBalance, req, vars, b.size, allow)
+}
@@ -0,0 +1,34 @@
+// Package lb
+//
+// @author: xwc1125
+package lb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+func TestWithHealth(t *testing.T) {
+	ws := []W{Weight(1), Weight(1), Weight(1)}
+	down := map[int64]bool{1: true}
+	healthy := func(idx int) bool { return !down[int64(idx)] }
+	req := new(fasthttp.Request)
+	vars := mapVars{"http_x-key": "a"}
+
+	for _, typ := range []string{RoundRobin, CHash, Rand} {
+		upstream := &entity.UpstreamDef{Type: typ, HashOn: "header", Key: "X-Key"}
+		b := WithHealth(NewBalancer(upstream, ws), len(ws), healthy)
+		for i := 0; i < 20; i++ {
+			assert.NotEqual(t, int64(1), b.Distribute(req, vars), typ)
+		}
+	}
+
+	// 所有节点都不健康时仍然选择节点
+	down = map[int64]bool{0: true, 1: true, 2: true}
+	b := WithHealth(NewBalancer(&entity.UpstreamDef{Type: RoundRobin}, ws), len(ws), healthy)
+	idx := b.Distribute(req, vars)
+	assert.True(t, idx >= 0 && idx < 3)
+}
@@ -1,6 +1,8 @@
 package lb
 
 import (
+	"time"
+
 	"github.com/valyala/fasthttp"
 	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
 )
@@ -10,11 +12,29 @@ const (
 	IPHash     = "iphash"
 	CHash      = "chash"
 	Rand       = "Rand"
+	EWMA       = "ewma"
+	LeastConn  = "least_conn"
 )
 
 // LoadBalance .
 type LoadBalance interface {
-	Distribute(ctx *fasthttp.Request) int64
+	// Distribute 选择节点，返回节点的下标。vars为请求的变量，chash从中获取hash key，可以为nil
+	Distribute(ctx *fasthttp.Request, vars Vars) int64
+	// Done 请求转发给Distribute选择的节点之后调用，latency为上游的响应时间，err为转发的错误。
+	// 每次Distribute的结果被使用后都需要调用一次，ewma和least_conn依赖该回调
+	Done(idx int64, latency time.Duration, err error)
+}
+
+// filterBalance 可以跳过节点的负载均衡，选择节点有副作用(如least_conn记录连接数)
+// 或者结果固定(如chash)的算法需要实现，避免为了跳过节点而多次调用Distribute
+type filterBalance interface {
+	// distribute 选择allow允许的节点，没有时返回-1
+	distribute(req *fasthttp.Request, vars Vars, allow func(idx int) bool) int64
+}
+
+// Vars 请求的变量，plugins.Context实现了该接口
+type Vars interface {
+	Var(name string) string
 }
 
 // W ...
@@ -22,6 +42,36 @@ type W interface {
 	Weight() int
 }
 
+// addr 带地址的节点，chash根据地址在hash环上放置虚拟节点
+type addr interface {
+	Addr() string
+}
+
+// node 带地址和优先级的权重
+type node struct {
+	addr     string
+	weight   int
+	priority int
+}
+
+// Node 创建带地址和优先级的权重，节点变化时chash只需重新映射少量的key，
+// 优先级高的节点优先被选择
+func Node(addr string, weight, priority int) W {
+	return node{addr: addr, weight: weight, priority: priority}
+}
+
+func (n node) Weight() int {
+	return n.weight
+}
+
+func (n node) Addr() string {
+	return n.addr
+}
+
+func (n node) Priority() int {
+	return n.priority
+}
+
 // Weight .
 type Weight uint
 
@@ -30,7 +80,12 @@ func (w Weight) Weight() int {
 }
 
 // NewBalancer ...
+// 节点存在多个优先级时，优先在高优先级的节点中选择
 func NewBalancer(upstreamDef *entity.UpstreamDef, ws []W) LoadBalance {
+	return withPriority(upstreamDef, ws, newBalancer)
+}
+
+func newBalancer(upstreamDef *entity.UpstreamDef, ws []W) LoadBalance {
 	switch upstreamDef.Type {
 	case RoundRobin:
 		return newRoundRobin(upstreamDef, ws)
@@ -40,7 +95,41 @@ func NewBalancer(upstreamDef *entity.UpstreamDef, ws []W) LoadBalance {
 		return newHashIPBalance(upstreamDef, ws)
 	case Rand:
 		return newRandBalance(upstreamDef, ws)
+	case EWMA:
+		return newEWMABalance(upstreamDef, ws)
+	case LeastConn:
+		return newLeastConnBalance(upstreamDef, ws)
 	default:
 		return newRoundRobin(upstreamDef, ws)
 	}
 }
+
+// Next 在size个节点中选择allow允许的节点，没有时返回-1，用于跳过不健康或者已经转发过的节点。
+// 返回的节点转发后同样需要调用Done
+func Next(balance LoadBalance, req *fasthttp.Request, vars Vars, size int, allow func(idx int) bool) int64 {
+	if b, ok := balance.(filterBalance); ok {
+		return b.distribute(req, vars, allow)
+	}
+	first := balance.Distribute(req, vars)
+	if allow(int(first)) {
+		return first
+	}
+	// 轮询、随机等算法再次选择即可得到其他节点
+	for i := 1; i < size; i++ {
+		idx := balance.Distribute(req, vars)
+		if idx == first {
+			break
+		}
+		if allow(int(idx)) {
+			return idx
+		}
+	}
+	// 哈希类算法的结果不变，顺序选择下一个允许的节点
+	for i := 1; i < size; i++ {
+		idx := (first + int64(i)) % int64(size)
+		if allow(int(idx)) {
+			return idx
+		}
+	}
+	return -1
+}
@@ -0,0 +1,87 @@
+// Package lb
+//
+// @author: xwc1125
+package lb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+// leastConnBalance 最少连接的负载均衡，与APISIX一致选择(连接数+1)/权重最小的节点，
+// 连接数在Distribute时增加，Done时减少
+type leastConnBalance struct {
+	upstreamDef *entity.UpstreamDef
+
+	mutex   sync.Mutex
+	weights []int
+	conns   []int // 正在处理的请求数
+	next    int   // 分数相同时从该节点开始比较，使空闲时请求轮流分配到各节点
+}
+
+func newLeastConnBalance(upstreamDef *entity.UpstreamDef, ws []W) LoadBalance {
+	return &leastConnBalance{
+		upstreamDef: upstreamDef,
+		weights:     positiveWeights(ws),
+		conns:       make([]int, len(ws)),
+	}
+}
+
+func (b *leastConnBalance) Distribute(req *fasthttp.Request, vars Vars) int64 {
+	if idx := b.distribute(req, vars, nil); idx >= 0 {
+		return idx
+	}
+	return 0
+}
+
+func (b *leastConnBalance) distribute(req *fasthttp.Request, vars Vars, allow func(idx int) bool) int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	best := -1
+	for i := 0; i < len(b.weights); i++ {
+		idx := (b.next + i) % len(b.weights)
+		if b.weights[idx] <= 0 || (allow != nil && !allow(idx)) {
+			continue
+		}
+		// (conns[idx]+1)/weights[idx] < (conns[best]+1)/weights[best]
+		if best < 0 || (b.conns[idx]+1)*b.weights[best] < (b.conns[best]+1)*b.weights[idx] {
+			best = idx
+		}
+	}
+	if best < 0 {
+		return -1
+	}
+	b.conns[best]++
+	b.next = (best + 1) % len(b.weights)
+	return int64(best)
+}
+
+func (b *leastConnBalance) Done(idx int64, latency time.Duration, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if idx >= 0 && int(idx) < len(b.conns) && b.conns[idx] > 0 {
+		b.conns[idx]--
+	}
+}
+
+// positiveWeights 获取节点的权重，权重为0的节点不参与选择，所有节点的权重都为0时按相同的权重处理
+func positiveWeights(ws []W) []int {
+	weights := make([]int, len(ws))
+	total := 0
+	for i, w := range ws {
+		if w.Weight() > 0 {
+			weights[i] = w.Weight()
+			total += w.Weight()
+		}
+	}
+	if total == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	return weights
+}
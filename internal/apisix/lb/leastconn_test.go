@@ -0,0 +1,47 @@
+// Package lb
+//
+// @author: xwc1125
+package lb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+func TestLeastConnBalance(t *testing.T) {
+	// 空闲时轮流选择分数相同的节点
+	rr := NewBalancer(&entity.UpstreamDef{Type: LeastConn}, []W{Weight(1), Weight(1)})
+	first := rr.Distribute(nil, nil)
+	rr.Done(first, 0, nil)
+	second := rr.Distribute(nil, nil)
+	rr.Done(second, 0, nil)
+	assert.NotEqual(t, first, second)
+
+	b := NewBalancer(&entity.UpstreamDef{Type: LeastConn}, []W{Weight(1), Weight(2), Weight(0)})
+	// 按(连接数+1)/权重选择，节点1的权重为2，可以承担两倍的连接，权重为0的节点不会被选择
+	counts := make([]int, 3)
+	picked := make([]int64, 0, 6)
+	for i := 0; i < 6; i++ {
+		idx := b.Distribute(nil, nil)
+		counts[idx]++
+		picked = append(picked, idx)
+	}
+	assert.Equal(t, []int{2, 4, 0}, counts)
+
+	// 连接结束后优先选择连接数少的节点
+	for _, idx := range picked {
+		if idx == 1 {
+			b.Done(idx, 0, nil)
+		}
+	}
+	assert.Equal(t, int64(1), b.Distribute(nil, nil))
+
+	// 跳过节点时只记录一次连接
+	lc := NewBalancer(&entity.UpstreamDef{Type: LeastConn}, []W{Weight(1), Weight(1)}).(*leastConnBalance)
+	idx := Next(lc, nil, nil, 2, func(idx int) bool { return idx == 1 })
+	assert.Equal(t, int64(1), idx)
+	assert.Equal(t, []int{0, 1}, lc.conns)
+	assert.Equal(t, int64(-1), Next(lc, nil, nil, 2, func(idx int) bool { return false }))
+}
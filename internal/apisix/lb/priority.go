@@ -0,0 +1,100 @@
+// Package lb
+//
+// @author: xwc1125
+package lb
+
+import (
+	"sort"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+// prioritized 带优先级的节点
+type prioritized interface {
+	Priority() int
+}
+
+// tier 相同优先级的节点
+type tier struct {
+	balance LoadBalance
+	nodes   []int       // 节点在所有节点中的下标
+	local   map[int]int // 所有节点中的下标 -> balance中的下标
+}
+
+// priorityBalance 按节点的优先级分组，与APISIX一致优先使用优先级高的节点，
+// 高优先级的节点都不可用(不健康或者重试时已经转发过)时使用下一个优先级的节点
+type priorityBalance struct {
+	tiers []*tier // 按优先级从高到低排序
+	owner []*tier // 节点下标 -> 所在的tier
+}
+
+// withPriority 节点存在多个优先级时按优先级分组，每组使用newBalance创建负载均衡
+func withPriority(upstreamDef *entity.UpstreamDef, ws []W, newBalance func(*entity.UpstreamDef, []W) LoadBalance) LoadBalance {
+	groups := make(map[int][]int)
+	priorities := make([]int, 0, 1)
+	for idx, w := range ws {
+		priority := 0
+		if p, ok := w.(prioritized); ok {
+			priority = p.Priority()
+		}
+		if _, ok := groups[priority]; !ok {
+			priorities = append(priorities, priority)
+		}
+		groups[priority] = append(groups[priority], idx)
+	}
+	if len(priorities) <= 1 {
+		return newBalance(upstreamDef, ws)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+
+	b := &priorityBalance{
+		tiers: make([]*tier, 0, len(priorities)),
+		owner: make([]*tier, len(ws)),
+	}
+	for _, priority := range priorities {
+		nodes := groups[priority]
+		t := &tier{
+			nodes: nodes,
+			local: make(map[int]int, len(nodes)),
+		}
+		tws := make([]W, len(nodes))
+		for i, idx := range nodes {
+			tws[i] = ws[idx]
+			t.local[idx] = i
+			b.owner[idx] = t
+		}
+		t.balance = newBalance(upstreamDef, tws)
+		b.tiers = append(b.tiers, t)
+	}
+	return b
+}
+
+// Distribute 在最高优先级的节点中选择
+func (b *priorityBalance) Distribute(req *fasthttp.Request, vars Vars) int64 {
+	t := b.tiers[0]
+	return int64(t.nodes[t.balance.Distribute(req, vars)])
+}
+
+func (b *priorityBalance) Done(idx int64, latency time.Duration, err error) {
+	if idx < 0 || int(idx) >= len(b.owner) {
+		return
+	}
+	t := b.owner[idx]
+	t.balance.Done(int64(t.local[int(idx)]), latency, err)
+}
+
+// distribute 按优先级从高到低，选择第一个存在allow允许的节点的分组
+func (b *priorityBalance) distribute(req *fasthttp.Request, vars Vars, allow func(idx int) bool) int64 {
+	for _, t := range b.tiers {
+		t := t
+		idx := Next(t.balance, req, vars, len(t.nodes), func(i int) bool {
+			return allow(t.nodes[i])
+		})
+		if idx >= 0 {
+			return int64(t.nodes[idx])
+		}
+	}
+	return -1
+}
@@ -0,0 +1,63 @@
+// Package lb
+//
+// @author: xwc1125
+package lb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+func TestPriorityBalance(t *testing.T) {
+	// 节点0、2为主数据中心，节点1、3为备用数据中心
+	ws := []W{Node("10.0.0.1:80", 1, 10), Node("10.0.1.1:80", 1, 0), Node("10.0.0.2:80", 1, 10), Node("10.0.1.2:80", 1, -1)}
+	down := map[int]bool{}
+	healthy := func(idx int) bool { return !down[idx] }
+
+	for _, typ := range []string{RoundRobin, CHash, Rand, EWMA, LeastConn} {
+		b := WithHealth(NewBalancer(&entity.UpstreamDef{Type: typ, Key: "uri"}, ws), len(ws), healthy)
+		vars := mapVars{"uri": "/hello", "remote_addr": "127.0.0.1"}
+		down = map[int]bool{}
+		for i := 0; i < 10; i++ {
+			idx := b.Distribute(nil, vars)
+			b.Done(idx, 0, nil)
+			assert.True(t, idx == 0 || idx == 2, typ)
+		}
+
+		// 高优先级的节点都不健康时使用下一个优先级
+		down = map[int]bool{0: true, 2: true}
+		for i := 0; i < 10; i++ {
+			idx := b.Distribute(nil, vars)
+			b.Done(idx, 0, nil)
+			assert.Equal(t, int64(1), idx, typ)
+		}
+
+		// 重试时跳过已经转发过的节点，最后使用优先级最低的节点
+		down = map[int]bool{}
+		tried := map[int]bool{0: true, 2: true}
+		allow := func(idx int) bool { return !tried[idx] }
+		assert.Equal(t, int64(1), Next(b, nil, vars, len(ws), allow), typ)
+		tried[1] = true
+		assert.Equal(t, int64(3), Next(b, nil, vars, len(ws), allow), typ)
+		tried[3] = true
+		assert.Equal(t, int64(-1), Next(b, nil, vars, len(ws), allow), typ)
+
+		// 所有节点都不健康时仍然选择最高优先级的节点
+		down = map[int]bool{0: true, 1: true, 2: true, 3: true}
+		idx := b.Distribute(nil, vars)
+		assert.True(t, idx == 0 || idx == 2, typ)
+	}
+
+	// 只有一个优先级时不分组
+	_, ok := NewBalancer(&entity.UpstreamDef{Type: RoundRobin}, []W{Weight(1), Weight(1)}).(*priorityBalance)
+	assert.False(t, ok)
+
+	// Done按节点所在的分组反馈
+	b := NewBalancer(&entity.UpstreamDef{Type: LeastConn}, ws).(*priorityBalance)
+	idx := b.Distribute(nil, nil)
+	assert.Equal(t, []int{1, 0}, b.tiers[0].balance.(*leastConnBalance).conns[:2])
+	b.Done(idx, 0, nil)
+	assert.Equal(t, []int{0, 0}, b.tiers[0].balance.(*leastConnBalance).conns)
+}
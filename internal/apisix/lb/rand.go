@@ -1,6 +1,8 @@
 package lb
 
 import (
+	"time"
+
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fastrand"
 	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
@@ -21,10 +23,12 @@ func newRandBalance(upstreamDef *entity.UpstreamDef, weights []W) LoadBalance {
 	return lb
 }
 
-func (rb RandBalance) Distribute(req *fasthttp.Request) int64 {
+func (rb RandBalance) Distribute(req *fasthttp.Request, vars Vars) int64 {
 	l := len(rb.weights)
 	if 0 >= l {
 		return 0
 	}
 	return int64(fastrand.Uint32n(uint32(l)))
 }
+
+func (rb RandBalance) Done(idx int64, latency time.Duration, err error) {}
@@ -5,6 +5,7 @@ package lb
 
 import (
 	"sync"
+	"time"
 
 	"github.com/valyala/fasthttp"
 	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
@@ -51,7 +52,7 @@ func newRoundRobin(upstreamDef *entity.UpstreamDef, ws []W) LoadBalance {
 }
 
 // Distribute to implement round robin algorithm, returns the idx of the choosing in ws ([]W)
-func (rrb *roundrobin) Distribute(req *fasthttp.Request) int64 {
+func (rrb *roundrobin) Distribute(req *fasthttp.Request, vars Vars) int64 {
 	rrb.mutex.Lock()
 	defer rrb.mutex.Unlock()
 
@@ -74,6 +75,8 @@ func (rrb *roundrobin) Distribute(req *fasthttp.Request) int64 {
 	}
 }
 
+func (rrb *roundrobin) Done(idx int64, latency time.Duration, err error) {}
+
 // gcd calculates the GCD of a and b.
 func gcd(a, b int) int {
 	if a < b {
@@ -0,0 +1,91 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"errors"
+
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/models"
+)
+
+// Action 插件执行后对插件链的控制
+type Action int
+
+const (
+	// ActionContinue 继续执行后续的插件
+	ActionContinue Action = iota
+	// ActionRespond 终止插件链，将ctx.Response直接返回给客户端，状态码可以是任意值，如201、204、302
+	ActionRespond
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionContinue:
+		return "continue"
+	case ActionRespond:
+		return "respond"
+	default:
+		return "unknown"
+	}
+}
+
+// PluginError 插件返回的错误，终止插件链并将StatusCode、Headers、Body返回给客户端
+type PluginError struct {
+	StatusCode int               // 返回给客户端的状态码
+	Headers    map[string]string // 返回给客户端的响应头
+	Body       []byte            // 返回给客户端的响应体
+	Err        error             // 错误原因
+}
+
+// NewPluginError 创建插件错误，响应体为JSON格式的错误信息
+func NewPluginError(statusCode int, err error) *PluginError {
+	return &PluginError{
+		StatusCode: statusCode,
+		Body:       []byte(models.Response{}.SetErrMsg(err.Error()).String()),
+		Err:        err,
+	}
+}
+
+// AsPluginError 转换为PluginError，非PluginError的错误使用500状态码
+func AsPluginError(err error) *PluginError {
+	var pe *PluginError
+	if errors.As(err, &pe) {
+		return pe
+	}
+	return NewPluginError(fasthttp.StatusInternalServerError, err)
+}
+
+// WithHeader 设置返回给客户端的响应头
+func (e *PluginError) WithHeader(key, value string) *PluginError {
+	if e.Headers == nil {
+		e.Headers = make(map[string]string)
+	}
+	e.Headers[key] = value
+	return e
+}
+
+func (e *PluginError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fasthttp.StatusMessage(e.StatusCode)
+}
+
+func (e *PluginError) Unwrap() error {
+	return e.Err
+}
+
+// WriteTo 将错误写入响应，原有的响应体会被替换
+func (e *PluginError) WriteTo(resp *fasthttp.Response) {
+	resp.ResetBody()
+	resp.SetStatusCode(e.StatusCode)
+	if len(e.Body) > 0 {
+		resp.Header.SetContentType("application/json")
+	}
+	for k, v := range e.Headers {
+		resp.Header.Set(k, v)
+	}
+	resp.SetBody(e.Body)
+}
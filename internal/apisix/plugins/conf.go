@@ -2,6 +2,7 @@ package plugins
 
 import (
 	"encoding/json"
+	"strings"
 	"sync"
 	"time"
 
@@ -43,9 +44,42 @@ func newConfCache(ttl time.Duration) *ConfCache {
 		cache.SkipTTLExtensionOnHit(false)
 		*c = cache
 	}
+	cc.keyCache.SetExpirationCallback(releaseConf)
 	return cc
 }
 
+// confKeySep 合并了consumer的插件链的key中，路由和consumer的配置key的分隔符
+const confKeySep = "|"
+
+// ConfReleaser 需要按配置保存状态的插件实现，配置从缓存中删除或过期时释放key对应的资源。
+// 状态按Context.ConfKey保存时，应释放所有ConfKeyUses(key, released)的资源
+type ConfReleaser interface {
+	ReleaseConf(released string)
+}
+
+// releaseConf 缓存的配置被删除或过期时通知插件。
+// consumer的配置中通常不包含路由的插件，但合并后的插件链同样使用了该配置，因此通知所有的插件
+func releaseConf(key string, _ interface{}) {
+	for _, p := range confReleasers() {
+		p.ReleaseConf(key)
+	}
+}
+
+// mergeConfKey 合并了consumer的插件链的key
+func mergeConfKey(key, consumerKey string) string {
+	return key + confKeySep + consumerKey
+}
+
+// ConfKeyUses 插件链的key是否使用了released的配置，合并了consumer的插件链同时使用路由和consumer的配置
+func ConfKeyUses(key, released string) bool {
+	for _, k := range strings.Split(key, confKeySep) {
+		if k == released {
+			return true
+		}
+	}
+	return false
+}
+
 func (cc *ConfCache) Set(key string, pluginsConf map[string]interface{}) (string, error) {
 	cc.lock.Lock()
 	defer cc.lock.Unlock()
@@ -131,6 +165,14 @@ func PrepareConf(key string, pluginsConf map[string]interface{}) (string, error)
 	return cache.Set(key, pluginsConf)
 }
 
+// DeleteConf 删除缓存的数据
+func DeleteConf(key string) error {
+	if cache == nil {
+		return nil
+	}
+	return cache.Delete(key)
+}
+
 // GetRuleConf 从缓存中取数据
 func GetRuleConf(key string) (RuleConf, error) {
 	return cache.Get(key)
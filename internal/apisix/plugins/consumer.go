@@ -0,0 +1,191 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+const (
+	// HeaderConsumerUsername 认证通过后，传递给上游的consumer用户名
+	HeaderConsumerUsername = "X-Consumer-Username"
+)
+
+// AuthPlugin 认证插件，用于从consumer的插件配置中识别请求对应的consumer。
+// consumer的插件合并到插件链时，认证插件不会被合并
+type AuthPlugin interface {
+	PluginV2
+	// ParseConsumerConf 解析consumer中该插件的配置
+	ParseConsumerConf(in []byte) (conf interface{}, err error)
+}
+
+// IndexedAuthPlugin 可以按凭证中的标识查找consumer的认证插件，如key-auth的key、basic-auth的username。
+// consumer变更时按该标识建立索引，认证时不需要遍历所有的consumer
+type IndexedAuthPlugin interface {
+	AuthPlugin
+	// ConsumerIndex 返回consumer中该插件的配置用于查找consumer的标识
+	ConsumerIndex(conf interface{}) string
+}
+
+var (
+	consumers     atomic.Value // []*entity.Consumer
+	consumerIndex atomic.Value // 插件名 -> 标识 -> consumer
+	consumerConfs sync.Map     // consumerConfKey -> interface{}
+)
+
+func init() {
+	consumers.Store([]*entity.Consumer{})
+	consumerIndex.Store(map[string]map[string]*entity.Consumer{})
+}
+
+type consumerConfKey struct {
+	consumer *entity.Consumer
+	plugin   string
+}
+
+// StoreConsumers 替换所有的consumer，按用户名排序
+func StoreConsumers(list []*entity.Consumer) {
+	sorted := make([]*entity.Consumer, len(list))
+	copy(sorted, list)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Username < sorted[j].Username
+	})
+
+	alive := make(map[*entity.Consumer]struct{}, len(sorted))
+	for _, c := range sorted {
+		alive[c] = struct{}{}
+	}
+	for _, c := range consumers.Load().([]*entity.Consumer) {
+		if _, ok := alive[c]; !ok && cache != nil {
+			cache.Delete(ConsumerConfKey(c))
+		}
+	}
+	consumerConfs.Range(func(key, value interface{}) bool {
+		if _, ok := alive[key.(consumerConfKey).consumer]; !ok {
+			consumerConfs.Delete(key)
+		}
+		return true
+	})
+	consumers.Store(sorted)
+	consumerIndex.Store(indexConsumers(sorted))
+}
+
+// indexConsumers 按IndexedAuthPlugin的标识索引consumer，标识重复时保留用户名排序在前的consumer
+func indexConsumers(list []*entity.Consumer) map[string]map[string]*entity.Consumer {
+	index := make(map[string]map[string]*entity.Consumer)
+	for _, p := range indexedAuthPlugins() {
+		items := make(map[string]*entity.Consumer)
+		for _, c := range list {
+			if _, ok := c.Plugins[p.Name()]; !ok {
+				continue
+			}
+			conf, err := consumerConf(c, p)
+			if err != nil {
+				log().Warn("failed to parse consumer conf", "username", c.Username, "plugin", p.Name(), "err", err)
+				continue
+			}
+			id := p.ConsumerIndex(conf)
+			if exist, ok := items[id]; ok {
+				log().Warn("duplicate consumer credential, skip", "plugin", p.Name(), "username", c.Username, "exist", exist.Username)
+				continue
+			}
+			items[id] = c
+		}
+		index[p.Name()] = items
+	}
+	return index
+}
+
+// LookupConsumer 通过标识查找配置了认证插件p的consumer，同时返回consumer中该插件的配置
+func LookupConsumer(p IndexedAuthPlugin, id string) (*entity.Consumer, interface{}) {
+	index := consumerIndex.Load().(map[string]map[string]*entity.Consumer)
+	c, ok := index[p.Name()][id]
+	if !ok {
+		return nil, nil
+	}
+	conf, err := consumerConf(c, p)
+	if err != nil {
+		return nil, nil
+	}
+	return c, conf
+}
+
+// Consumers 所有的consumer
+func Consumers() []*entity.Consumer {
+	return consumers.Load().([]*entity.Consumer)
+}
+
+// consumerConf 解析并缓存consumer的认证插件配置，consumer变更后为新的对象，缓存随之失效
+func consumerConf(c *entity.Consumer, p AuthPlugin) (interface{}, error) {
+	key := consumerConfKey{consumer: c, plugin: p.Name()}
+	if conf, ok := consumerConfs.Load(key); ok {
+		return conf, nil
+	}
+	in, err := json.Marshal(c.Plugins[p.Name()])
+	if err != nil {
+		return nil, err
+	}
+	conf, err := p.ParseConsumerConf(in)
+	if err != nil {
+		return nil, err
+	}
+	consumerConfs.Store(key, conf)
+	return conf, nil
+}
+
+// ConsumerConfKey consumer插件配置在缓存中的key
+func ConsumerConfKey(c *entity.Consumer) string {
+	return fmt.Sprintf("consumer/%s#%p", c.Username, c)
+}
+
+// MergeConsumer 将ctx中consumer的插件合并到插件链中，返回新的插件链。
+// 同名插件以consumer的配置为准，认证插件不会被合并
+func (c *Chain) MergeConsumer(ctx *Context) *Chain {
+	consumer := ctx.Consumer
+	if consumer == nil || len(consumer.Plugins) == 0 {
+		return c
+	}
+
+	key, err := PrepareConf(ConsumerConfKey(consumer), consumer.Plugins)
+	if err != nil {
+		log().Error("failed to prepare consumer conf", "username", consumer.Username, "err", err)
+		return c
+	}
+	conf, err := GetRuleConf(key)
+	if err != nil {
+		log().Error("failed to get consumer conf", "username", consumer.Username, "err", err)
+		return c
+	}
+
+	overrides := make(map[string]pluginRuntime)
+	for _, rt := range getPluginRuntimes(conf) {
+		if _, ok := rt.plugin.(AuthPlugin); ok {
+			continue
+		}
+		overrides[rt.conf.Name] = rt
+	}
+	if len(overrides) == 0 {
+		return c
+	}
+	merged := make(Plugins, 0, len(c.runtimes)+len(overrides))
+	for _, rt := range c.runtimes {
+		if _, ok := overrides[rt.conf.Name]; !ok {
+			merged = append(merged, rt)
+		}
+	}
+	for _, rt := range overrides {
+		merged = append(merged, rt)
+	}
+	sort.Sort(merged)
+	return &Chain{
+		key:      mergeConfKey(c.key, key),
+		runtimes: merged,
+	}
+}
@@ -0,0 +1,189 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/chain5j/chain5j-pkg/util/convutil"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+// Context 请求上下文，在一个请求的插件链及各个阶段之间共享
+type Context struct {
+	RequestCtx *fasthttp.RequestCtx // 客户端的原始请求
+	Request    *fasthttp.Request    // 转发给上游的请求
+	Response   *fasthttp.Response   // 返回给客户端的响应
+	Route      *entity.Route        // 匹配的路由
+	Consumer   *entity.Consumer     // 认证插件识别的consumer
+	Params     map[string]string    // 路由匹配时提取的路径参数
+	StartTime  time.Time            // 开始处理请求的时间
+	EndTime    time.Time            // 响应发送完成的时间，Detach时设置
+
+	values     map[string]interface{}
+	confKey    string
+	upstream   *Upstream
+	host       string
+	isTLS      bool
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+// NewContext 创建请求上下文
+func NewContext(reqCtx *fasthttp.RequestCtx, route *entity.Route, req *fasthttp.Request, resp *fasthttp.Response) *Context {
+	return &Context{
+		RequestCtx: reqCtx,
+		Request:    req,
+		Response:   resp,
+		Route:      route,
+		StartTime:  time.Now(),
+		host:       string(reqCtx.Host()),
+		isTLS:      reqCtx.IsTLS(),
+		remoteAddr: reqCtx.RemoteAddr(),
+		localAddr:  reqCtx.LocalAddr(),
+	}
+}
+
+// Detach 复制一份与RequestCtx无关的上下文，用于响应发送之后的异步处理。
+// 使用完毕后需要调用Release
+func (c *Context) Detach() *Context {
+	d := *c
+	d.RequestCtx = nil
+	d.EndTime = time.Now()
+	d.Request = fasthttp.AcquireRequest()
+	c.Request.CopyTo(d.Request)
+	d.Response = fasthttp.AcquireResponse()
+	c.Response.CopyTo(d.Response)
+	if c.values != nil {
+		d.values = make(map[string]interface{}, len(c.values))
+		for k, v := range c.values {
+			d.values[k] = v
+		}
+	}
+	return &d
+}
+
+// Release 释放Detach复制的请求和响应
+func (c *Context) Release() {
+	fasthttp.ReleaseRequest(c.Request)
+	fasthttp.ReleaseResponse(c.Response)
+	c.Request = nil
+	c.Response = nil
+}
+
+// Set 保存数据，供后续的插件或阶段使用
+func (c *Context) Set(key string, value interface{}) {
+	if c.values == nil {
+		c.values = make(map[string]interface{})
+	}
+	c.values[key] = value
+}
+
+// Get 获取Set保存的数据
+func (c *Context) Get(key string) (interface{}, bool) {
+	value, ok := c.values[key]
+	return value, ok
+}
+
+// ConfKey 正在执行的插件链的配置在缓存中的key，路由或global rule变更后key随之变化
+func (c *Context) ConfKey() string {
+	return c.confKey
+}
+
+// AttachConsumer 将认证通过的consumer关联到请求，并通过请求头传递给上游
+func (c *Context) AttachConsumer(consumer *entity.Consumer) {
+	c.Consumer = consumer
+	c.Request.Header.Set(HeaderConsumerUsername, consumer.Username)
+}
+
+// ClientIP 客户端的IP
+func (c *Context) ClientIP() string {
+	if addr, ok := c.remoteAddr.(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(c.remoteAddr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// Var 获取APISIX风格的变量，不存在时返回空字符串。支持：
+//
+//	uri、request_uri、host、request_method、scheme、query_string、args、
+//	remote_addr、remote_port、server_port、route_id、route_name、service_id、consumer_name、
+//	arg_<name>、http_<name>、cookie_<name>、以及Set保存的字符串数据
+func (c *Context) Var(name string) string {
+	req := c.Request
+	switch name {
+	case "uri":
+		return string(req.URI().Path())
+	case "request_uri":
+		return string(req.RequestURI())
+	case "host":
+		return c.host
+	case "request_method":
+		return string(req.Header.Method())
+	case "scheme":
+		if c.isTLS {
+			return "https"
+		}
+		return "http"
+	case "query_string", "args":
+		return string(req.URI().QueryString())
+	case "remote_addr":
+		return c.ClientIP()
+	case "remote_port":
+		return port(c.remoteAddr)
+	case "server_port":
+		return port(c.localAddr)
+	case "route_id":
+		if c.Route != nil {
+			return convutil.ToString(c.Route.ID)
+		}
+	case "route_name":
+		if c.Route != nil {
+			return c.Route.Name
+		}
+	case "service_id":
+		if c.Route != nil && c.Route.ServiceID != nil {
+			return convutil.ToString(c.Route.ServiceID)
+		}
+	case "consumer_name":
+		if c.Consumer != nil {
+			return c.Consumer.Username
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(name, "arg_"):
+		return string(req.URI().QueryArgs().Peek(name[len("arg_"):]))
+	case strings.HasPrefix(name, "http_"):
+		// 与nginx一致，变量名中的"_"对应请求头中的"-"
+		return string(req.Header.Peek(strings.ReplaceAll(name[len("http_"):], "_", "-")))
+	case strings.HasPrefix(name, "cookie_"):
+		return string(req.Header.Cookie(name[len("cookie_"):]))
+	}
+	if value, ok := c.Get(name); ok {
+		if s, ok := value.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// port 获取地址中的端口
+func port(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	_, p, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	return p
+}
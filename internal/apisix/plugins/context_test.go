@@ -0,0 +1,77 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+func TestContextVar(t *testing.T) {
+	origin := new(fasthttp.Request)
+	origin.Header.SetHost("example.com")
+	reqCtx := new(fasthttp.RequestCtx)
+	reqCtx.Init(origin, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5678}, nil)
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.SetRequestURI("/foo?name=jack")
+	req.Header.Set("X-User-Id", "1")
+	req.Header.SetCookie("session", "s1")
+
+	ctx := NewContext(reqCtx, &entity.Route{BaseInfo: entity.BaseInfo{ID: 1}, Name: "r1"}, req, new(fasthttp.Response))
+	ctx.AttachConsumer(&entity.Consumer{Username: "jack"})
+	ctx.Set("custom", "value")
+
+	tests := map[string]string{
+		"uri":            "/foo",
+		"request_uri":    "/foo?name=jack",
+		"host":           "example.com",
+		"request_method": "POST",
+		"args":           "name=jack",
+		"remote_addr":    "10.0.0.1",
+		"remote_port":    "5678",
+		"route_id":       "1",
+		"route_name":     "r1",
+		"consumer_name":  "jack",
+		"arg_name":       "jack",
+		"http_x_user_id": "1",
+		"cookie_session": "s1",
+		"custom":         "value",
+		"unknown":        "",
+	}
+	for name, want := range tests {
+		assert.Equal(t, want, ctx.Var(name), name)
+	}
+	assert.Equal(t, "jack", string(req.Header.Peek(HeaderConsumerUsername)))
+}
+
+type legacyPlugin struct {
+	DefaultPlugin
+}
+
+func (*legacyPlugin) Name() string                             { return "legacy" }
+func (*legacyPlugin) Version() string                          { return "0.1" }
+func (*legacyPlugin) Priority() int64                          { return 1 }
+func (*legacyPlugin) ParseConf(in []byte) (interface{}, error) { return nil, nil }
+func (*legacyPlugin) RequestFilter(conf interface{}, r *fasthttp.Request, w *fasthttp.Response) error {
+	r.Header.Set("X-Legacy", "1")
+	return nil
+}
+
+func TestPluginAdapter(t *testing.T) {
+	var p PluginV2 = pluginAdapter{new(legacyPlugin)}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	ctx := NewContext(new(fasthttp.RequestCtx), nil, req, new(fasthttp.Response))
+	action, err := p.RequestFilter(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ActionContinue, action)
+	assert.Equal(t, "1", string(req.Header.Peek("X-Legacy")))
+}
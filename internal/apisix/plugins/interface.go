@@ -3,7 +3,11 @@
 // @author: xwc1125
 package plugins
 
-import "github.com/valyala/fasthttp"
+import (
+	"errors"
+
+	"github.com/valyala/fasthttp"
+)
 
 // Plugin 插件接口
 type Plugin interface {
@@ -27,9 +31,76 @@ type Plugin interface {
 	ResponseFilter(conf interface{}, w *fasthttp.Response) (err error)
 }
 
+// PluginV2 基于请求上下文的插件接口。
+// 与Plugin相比，各阶段都可以通过Context获取客户端信息、路由、consumer，并在阶段之间共享数据
+type PluginV2 interface {
+	// Name 插件名称
+	Name() string
+	// Version 版本信息
+	Version() string
+	// Priority 优先级
+	Priority() int64
+
+	// ParseConf 解析插件配置
+	// 如果无法解析，那么跳过改插件
+	ParseConf(in []byte) (conf interface{}, err error)
+
+	// RequestFilter 根据conf对象进行请求的处理，转发给上游的请求为ctx.Request
+	// 当err不为nil时，代表执行出错，终止插件链并返回错误，err为PluginError时使用其中的状态码、响应头和响应体。
+	// 返回ActionRespond时，终止插件链并将ctx.Response直接返回给客户端
+	RequestFilter(ctx *Context, conf interface{}) (Action, error)
+
+	// ResponseFilter 对响应结果ctx.Response的处理
+	ResponseFilter(ctx *Context, conf interface{}) (Action, error)
+}
+
+// pluginAdapter 将Plugin适配为PluginV2
+type pluginAdapter struct {
+	Plugin
+}
+
+// Unwrap 被适配的插件
+func (a pluginAdapter) Unwrap() Plugin {
+	return a.Plugin
+}
+
+// RequestFilter Plugin通过改写状态码终止插件链，
+// 因此返回错误时使用改写后的状态码，状态码不为fasthttp.StatusOK时返回ActionRespond
+func (a pluginAdapter) RequestFilter(ctx *Context, conf interface{}) (Action, error) {
+	err := a.Plugin.RequestFilter(conf, ctx.Request, ctx.Response)
+	if err != nil {
+		return ActionRespond, legacyError(ctx.Response, err)
+	}
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		return ActionRespond, nil
+	}
+	return ActionContinue, nil
+}
+
+func (a pluginAdapter) ResponseFilter(ctx *Context, conf interface{}) (Action, error) {
+	err := a.Plugin.ResponseFilter(conf, ctx.Response)
+	if err != nil {
+		return ActionRespond, legacyError(ctx.Response, err)
+	}
+	return ActionContinue, nil
+}
+
+// legacyError 将Plugin返回的错误转换为PluginError
+func legacyError(resp *fasthttp.Response, err error) error {
+	var pe *PluginError
+	if errors.As(err, &pe) {
+		return err
+	}
+	statusCode := resp.StatusCode()
+	if statusCode == 0 || statusCode == fasthttp.StatusOK {
+		statusCode = fasthttp.StatusInternalServerError
+	}
+	return NewPluginError(statusCode, err)
+}
+
 type pluginRuntime struct {
 	conf   ConfEntry
-	plugin Plugin
+	plugin PluginV2
 }
 type Plugins []pluginRuntime
 
@@ -37,11 +108,12 @@ func (r Plugins) Len() int {
 	return len(r)
 }
 
+// Less 与APISIX一致，优先级高的插件先执行，相同时按名称排序
 func (r Plugins) Less(i, j int) bool {
-	if r[i].plugin.Priority() < r[j].plugin.Priority() {
-		return true
+	if r[i].plugin.Priority() != r[j].plugin.Priority() {
+		return r[i].plugin.Priority() > r[j].plugin.Priority()
 	}
-	return false
+	return r[i].conf.Name < r[j].conf.Name
 }
 
 func (r Plugins) Swap(i, j int) {
@@ -57,3 +129,13 @@ func (*DefaultPlugin) RequestFilter(conf interface{}, r *fasthttp.Request, w *fa
 func (*DefaultPlugin) ResponseFilter(conf interface{}, w *fasthttp.Response) error {
 	return nil
 }
+
+// DefaultPluginV2 插件接口PluginV2的无操作实现
+type DefaultPluginV2 struct{}
+
+func (*DefaultPluginV2) RequestFilter(ctx *Context, conf interface{}) (Action, error) {
+	return ActionContinue, nil
+}
+func (*DefaultPluginV2) ResponseFilter(ctx *Context, conf interface{}) (Action, error) {
+	return ActionContinue, nil
+}
@@ -0,0 +1,88 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"time"
+)
+
+// LogEntry 与APISIX一致的访问日志格式，供日志类插件使用
+type LogEntry struct {
+	Request   LogRequest   `json:"request"`
+	Response  LogResponse  `json:"response"`
+	Upstream  string       `json:"upstream,omitempty"`
+	ClientIP  string       `json:"client_ip"`
+	StartTime int64        `json:"start_time"` // 毫秒
+	Latency   float64      `json:"latency"`    // 毫秒
+	RouteID   string       `json:"route_id,omitempty"`
+	ServiceID string       `json:"service_id,omitempty"`
+	Consumer  *LogConsumer `json:"consumer,omitempty"`
+}
+
+type LogRequest struct {
+	URL         string            `json:"url"`
+	URI         string            `json:"uri"`
+	Method      string            `json:"method"`
+	Headers     map[string]string `json:"headers"`
+	QueryString map[string]string `json:"querystring"`
+	Size        int               `json:"size"`
+	Body        string            `json:"body,omitempty"`
+}
+
+type LogResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Size    int               `json:"size"`
+}
+
+type LogConsumer struct {
+	Username string `json:"username"`
+}
+
+// NewLogEntry 根据请求上下文生成访问日志
+func NewLogEntry(ctx *Context, includeReqBody bool) *LogEntry {
+	req, resp := ctx.Request, ctx.Response
+	end := ctx.EndTime
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	entry := &LogEntry{
+		Request: LogRequest{
+			URL:         ctx.Var("scheme") + "://" + ctx.Var("host") + string(req.RequestURI()),
+			URI:         string(req.RequestURI()),
+			Method:      string(req.Header.Method()),
+			Headers:     make(map[string]string),
+			QueryString: make(map[string]string),
+			Size:        len(req.Header.Header()) + len(req.Body()),
+		},
+		Response: LogResponse{
+			Status:  resp.StatusCode(),
+			Headers: make(map[string]string),
+			Size:    len(resp.Header.Header()) + len(resp.Body()),
+		},
+		Upstream:  ctx.Var("upstream_addr"),
+		ClientIP:  ctx.ClientIP(),
+		StartTime: ctx.StartTime.UnixMilli(),
+		Latency:   float64(end.Sub(ctx.StartTime).Microseconds()) / 1000,
+		RouteID:   ctx.Var("route_id"),
+		ServiceID: ctx.Var("service_id"),
+	}
+	req.Header.VisitAll(func(key, value []byte) {
+		entry.Request.Headers[string(key)] = string(value)
+	})
+	req.URI().QueryArgs().VisitAll(func(key, value []byte) {
+		entry.Request.QueryString[string(key)] = string(value)
+	})
+	resp.Header.VisitAll(func(key, value []byte) {
+		entry.Response.Headers[string(key)] = string(value)
+	})
+	if includeReqBody {
+		entry.Request.Body = string(req.Body())
+	}
+	if ctx.Consumer != nil {
+		entry.Consumer = &LogConsumer{Username: ctx.Consumer.Username}
+	}
+	return entry
+}
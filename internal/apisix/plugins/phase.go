@@ -0,0 +1,110 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+// Phase 插件的执行阶段，与APISIX一致
+type Phase string
+
+const (
+	PhaseRewrite      Phase = "rewrite"       // 请求改写，认证插件在此阶段识别consumer
+	PhaseAccess       Phase = "access"        // 访问控制，执行PluginV2.RequestFilter
+	PhaseBeforeProxy  Phase = "before_proxy"  // 转发给上游之前
+	PhaseHeaderFilter Phase = "header_filter" // 收到上游的响应后，处理响应头
+	PhaseBodyFilter   Phase = "body_filter"   // 处理响应体，执行PluginV2.ResponseFilter
+	PhaseLog          Phase = "log"           // 响应发送给客户端之后异步执行
+)
+
+// RewritePlugin 实现rewrite阶段的插件
+type RewritePlugin interface {
+	Rewrite(ctx *Context, conf interface{}) (Action, error)
+}
+
+// BeforeProxyPlugin 实现before_proxy阶段的插件
+type BeforeProxyPlugin interface {
+	BeforeProxy(ctx *Context, conf interface{}) (Action, error)
+}
+
+// HeaderFilterPlugin 实现header_filter阶段的插件
+type HeaderFilterPlugin interface {
+	HeaderFilter(ctx *Context, conf interface{}) (Action, error)
+}
+
+// BodyFilterPlugin 实现body_filter阶段的插件，在ResponseFilter之前执行
+type BodyFilterPlugin interface {
+	BodyFilter(ctx *Context, conf interface{}) (Action, error)
+}
+
+// LogPlugin 实现log阶段的插件。
+// log阶段在响应发送之后异步执行，ctx为请求的副本，其中的RequestCtx为nil
+type LogPlugin interface {
+	Log(ctx *Context, conf interface{})
+}
+
+// Chain 按优先级排序的插件链
+type Chain struct {
+	key      string
+	runtimes Plugins
+}
+
+// NewChain 使用PrepareConf缓存的配置创建插件链
+func NewChain(key string) (*Chain, error) {
+	conf, err := GetRuleConf(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Chain{
+		key:      key,
+		runtimes: getPluginRuntimes(conf),
+	}, nil
+}
+
+// Run 执行插件链的phase阶段。
+// 插件返回错误或ActionRespond时，不再执行后续的插件，并将其返回
+func (c *Chain) Run(phase Phase, ctx *Context) (Action, error) {
+	ctx.confKey = c.key
+	for _, rt := range c.runtimes {
+		action, err := rt.run(phase, ctx)
+		if err != nil {
+			log().Debug("plugin run err", "phase", phase, "plugin", rt.conf.Name, "err", err)
+			return ActionRespond, err
+		}
+		if action == ActionRespond {
+			log().Debug("plugin run respond", "phase", phase, "plugin", rt.conf.Name, "statusCode", ctx.Response.StatusCode())
+			return ActionRespond, nil
+		}
+	}
+	return ActionContinue, nil
+}
+
+func (rt pluginRuntime) run(phase Phase, ctx *Context) (Action, error) {
+	switch phase {
+	case PhaseRewrite:
+		if p, ok := rt.plugin.(RewritePlugin); ok {
+			return p.Rewrite(ctx, rt.conf.Value)
+		}
+	case PhaseAccess:
+		return rt.plugin.RequestFilter(ctx, rt.conf.Value)
+	case PhaseBeforeProxy:
+		if p, ok := rt.plugin.(BeforeProxyPlugin); ok {
+			return p.BeforeProxy(ctx, rt.conf.Value)
+		}
+	case PhaseHeaderFilter:
+		if p, ok := rt.plugin.(HeaderFilterPlugin); ok {
+			return p.HeaderFilter(ctx, rt.conf.Value)
+		}
+	case PhaseBodyFilter:
+		if p, ok := rt.plugin.(BodyFilterPlugin); ok {
+			action, err := p.BodyFilter(ctx, rt.conf.Value)
+			if err != nil || action != ActionContinue {
+				return action, err
+			}
+		}
+		return rt.plugin.ResponseFilter(ctx, rt.conf.Value)
+	case PhaseLog:
+		if p, ok := rt.plugin.(LogPlugin); ok {
+			p.Log(ctx, rt.conf.Value)
+		}
+	}
+	return ActionContinue, nil
+}
@@ -0,0 +1,73 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+// stubPlugin 按配置返回Action和错误的插件
+type stubPlugin struct {
+	DefaultPluginV2
+	name   string
+	action Action
+	err    error
+	called *[]string
+}
+
+func (p *stubPlugin) Name() string                             { return p.name }
+func (p *stubPlugin) Version() string                          { return "0.1" }
+func (p *stubPlugin) Priority() int64                          { return 1 }
+func (p *stubPlugin) ParseConf(in []byte) (interface{}, error) { return nil, nil }
+func (p *stubPlugin) RequestFilter(ctx *Context, conf interface{}) (Action, error) {
+	*p.called = append(*p.called, p.name)
+	return p.action, p.err
+}
+
+func TestChainRun(t *testing.T) {
+	errDenied := NewPluginError(fasthttp.StatusForbidden, errors.New("denied")).WithHeader("X-Reason", "test")
+	tests := []struct {
+		name       string
+		first      *stubPlugin
+		wantAction Action
+		wantErr    error
+		wantCalled []string
+	}{
+		{"continue", &stubPlugin{name: "first", action: ActionContinue}, ActionContinue, nil, []string{"first", "second"}},
+		{"respond", &stubPlugin{name: "first", action: ActionRespond}, ActionRespond, nil, []string{"first"}},
+		{"error", &stubPlugin{name: "first", err: errDenied}, ActionRespond, errDenied, []string{"first"}},
+	}
+	for _, tt := range tests {
+		var called []string
+		tt.first.called = &called
+		chain := &Chain{runtimes: Plugins{
+			{conf: ConfEntry{Name: "first"}, plugin: tt.first},
+			{conf: ConfEntry{Name: "second"}, plugin: &stubPlugin{name: "second", called: &called}},
+		}}
+		ctx := NewContext(new(fasthttp.RequestCtx), nil, new(fasthttp.Request), new(fasthttp.Response))
+		// 状态码不再影响插件链的执行
+		ctx.Response.SetStatusCode(fasthttp.StatusNoContent)
+
+		action, err := chain.Run(PhaseAccess, ctx)
+		assert.Equal(t, tt.wantAction, action, tt.name)
+		assert.Equal(t, tt.wantErr, err, tt.name)
+		assert.Equal(t, tt.wantCalled, called, tt.name)
+	}
+}
+
+func TestPluginError(t *testing.T) {
+	resp := new(fasthttp.Response)
+	resp.SetBody([]byte("upstream"))
+	pe := AsPluginError(NewPluginError(fasthttp.StatusUnauthorized, errors.New("missing key")).WithHeader("WWW-Authenticate", "Basic"))
+	pe.WriteTo(resp)
+	assert.Equal(t, fasthttp.StatusUnauthorized, resp.StatusCode())
+	assert.Equal(t, "Basic", string(resp.Header.Peek("WWW-Authenticate")))
+	assert.JSONEq(t, `{"error_msg":"missing key"}`, string(resp.Body()))
+
+	assert.Equal(t, fasthttp.StatusInternalServerError, AsPluginError(errors.New("internal")).StatusCode)
+}
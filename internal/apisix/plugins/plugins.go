@@ -8,20 +8,15 @@ import (
 	"fmt"
 	"sort"
 	"sync"
-
-	"github.com/valyala/fasthttp"
 )
 
 var (
-	pluginRegistry = pluginRegistries{opts: make(map[string]Plugin)}
+	pluginRegistry = pluginRegistries{opts: make(map[string]PluginV2)}
 
 	ErrMissingName                 = errors.New("missing name")
 	ErrMissingParseConfMethod      = errors.New("missing ParseConf method")
 	ErrMissingRequestFilterMethod  = errors.New("missing RequestFilter method")
 	ErrMissingResponseFilterMethod = errors.New("missing ResponseFilter method")
-
-	RequestPhase  = requestPhase{}  // 请求阶段
-	ResponsePhase = responsePhase{} // 响应阶段
 )
 
 type ErrPluginRegistered struct {
@@ -34,10 +29,16 @@ func (err ErrPluginRegistered) Error() string {
 
 type pluginRegistries struct {
 	sync.Mutex
-	opts map[string]Plugin
+	opts map[string]PluginV2
 }
 
+// RegisterPlugin 注册插件，插件通过适配器以PluginV2的方式执行
 func RegisterPlugin(plugin Plugin) error {
+	return RegisterPluginV2(pluginAdapter{plugin})
+}
+
+// RegisterPluginV2 注册基于请求上下文的插件
+func RegisterPluginV2(plugin PluginV2) error {
 	log().Info("register plugin", "name", plugin.Name(), "version", plugin.Version(), "priority", plugin.Priority())
 
 	if plugin.Name() == "" {
@@ -53,14 +54,40 @@ func RegisterPlugin(plugin Plugin) error {
 	return nil
 }
 
-func findPlugin(name string) Plugin {
+func findPlugin(name string) PluginV2 {
 	if opt, found := pluginRegistry.opts[name]; found {
 		return opt
 	}
 	return nil
 }
 
-func getPluginRuntimes(conf RuleConf) []pluginRuntime {
+// confReleasers 实现了ConfReleaser的插件
+func confReleasers() []ConfReleaser {
+	pluginRegistry.Lock()
+	defer pluginRegistry.Unlock()
+	var ret []ConfReleaser
+	for _, p := range pluginRegistry.opts {
+		if r, ok := p.(ConfReleaser); ok {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// indexedAuthPlugins 实现了IndexedAuthPlugin的认证插件
+func indexedAuthPlugins() []IndexedAuthPlugin {
+	pluginRegistry.Lock()
+	defer pluginRegistry.Unlock()
+	var ret []IndexedAuthPlugin
+	for _, p := range pluginRegistry.opts {
+		if a, ok := p.(IndexedAuthPlugin); ok {
+			ret = append(ret, a)
+		}
+	}
+	return ret
+}
+
+func getPluginRuntimes(conf RuleConf) Plugins {
 	plugins := Plugins{}
 	for _, c := range conf {
 		plugin := findPlugin(c.Name)
@@ -77,68 +104,3 @@ func getPluginRuntimes(conf RuleConf) []pluginRuntime {
 	sort.Sort(plugins)
 	return plugins
 }
-
-type requestPhase struct {
-}
-
-func (ph *requestPhase) filter(conf RuleConf, req *fasthttp.Request, resp *fasthttp.Response) error {
-	pluginRuntimes := getPluginRuntimes(conf)
-	for _, pluginRuntime := range pluginRuntimes {
-		log().Debug("request run plugin", "plugin", pluginRuntime.conf.Name)
-		err := pluginRuntime.plugin.RequestFilter(pluginRuntime.conf.Value, req, resp)
-		if err != nil {
-			log().Error("plugin run request filter err", "plugin", pluginRuntime.conf.Name, "err", err)
-			return err
-		}
-		if resp.StatusCode() != fasthttp.StatusOK {
-			log().Error("plugin run request filter break", "plugin", pluginRuntime.conf.Name, "statusCode", resp.StatusCode())
-			break
-		}
-	}
-	return nil
-}
-
-// HTTPReqCall http请求的调用
-func HTTPReqCall(key string, req *fasthttp.Request, resp *fasthttp.Response) error {
-	conf, err := GetRuleConf(key)
-	if err != nil {
-		return err
-	}
-	// 请求阶段
-	return RequestPhase.filter(conf, req, resp)
-}
-
-type responsePhase struct {
-}
-
-func (ph *responsePhase) filter(conf RuleConf, w *fasthttp.Response) error {
-	pluginRuntimes := getPluginRuntimes(conf)
-	for _, pluginRuntime := range pluginRuntimes {
-		err := pluginRuntime.plugin.ResponseFilter(pluginRuntime.conf.Value, w)
-		if err != nil {
-			log().Error("plugin run response filter err", "plugin", pluginRuntime.conf.Name, "statusCode", w.StatusCode(), "err", err)
-			return err
-		}
-		if w.StatusCode() != fasthttp.StatusOK {
-			log().Error("plugin run response filter break", "plugin", pluginRuntime.conf.Name, "statusCode", w.StatusCode())
-			break
-		}
-	}
-
-	return nil
-}
-
-// HTTPRespCall http 响应的调用
-func HTTPRespCall(key string, resp *fasthttp.Response) error {
-	conf, err := GetRuleConf(key)
-	if err != nil {
-		return err
-	}
-
-	err = ResponsePhase.filter(conf, resp)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
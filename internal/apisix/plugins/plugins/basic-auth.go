@@ -4,7 +4,11 @@
 package plugins
 
 import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 
 	"github.com/chain5j/logger"
 	"github.com/valyala/fasthttp"
@@ -12,22 +16,31 @@ import (
 )
 
 var (
-	_ plugins.Plugin = new(BasicAuth)
+	_ plugins.IndexedAuthPlugin = new(BasicAuth)
+	_ plugins.RewritePlugin     = new(BasicAuth)
+)
+var (
+	errMissingAuthorization = fmt.Errorf("Missing authorization in request")
+	errInvalidAuthorization = fmt.Errorf("Invalid user authorization")
 )
 
 func init() {
-	err := plugins.RegisterPlugin(&BasicAuth{
+	err := plugins.RegisterPluginV2(&BasicAuth{
+		log:      logger.Log("basic-auth"),
 		name:     "basic-auth",
 		version:  "0.1",
-		priority: 10000,
+		priority: 2520,
 	})
 	if err != nil {
 		logger.Fatal("failed to register plugin BasicAuth", "err", err)
 	}
 }
 
+// BasicAuth 通过Authorization请求头中的用户名和密码识别consumer
 type BasicAuth struct {
-	plugins.DefaultPlugin
+	log logger.Logger
+
+	plugins.DefaultPluginV2
 	name     string
 	version  string
 	priority int64
@@ -35,6 +48,14 @@ type BasicAuth struct {
 
 type BasicAuthConf struct {
 	Disable bool `json:"disable"`
+
+	HideCredentials bool `json:"hide_credentials,omitempty"` // 是否在转发给上游前删除Authorization请求头
+}
+
+// BasicAuthConsumerConf consumer中basic-auth的配置
+type BasicAuthConsumerConf struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 func (p *BasicAuth) Name() string {
@@ -55,12 +76,70 @@ func (p *BasicAuth) ParseConf(in []byte) (interface{}, error) {
 	return conf, err
 }
 
-func (p *BasicAuth) RequestFilter(conf interface{}, r *fasthttp.Request, w *fasthttp.Response) error {
+func (p *BasicAuth) ParseConsumerConf(in []byte) (interface{}, error) {
+	conf := BasicAuthConsumerConf{}
+	err := json.Unmarshal(in, &conf)
+	if err != nil {
+		return nil, err
+	}
+	if len(conf.Username) == 0 || len(conf.Password) == 0 {
+		return nil, fmt.Errorf("username and password are required")
+	}
+	return conf, nil
+}
+
+// ConsumerIndex 按用户名索引consumer
+func (p *BasicAuth) ConsumerIndex(conf interface{}) string {
+	return conf.(BasicAuthConsumerConf).Username
+}
+
+// Rewrite 在rewrite阶段识别consumer
+func (p *BasicAuth) Rewrite(ctx *plugins.Context, conf interface{}) (plugins.Action, error) {
+	r := ctx.Request
 	config, ok := conf.(BasicAuthConf)
 	if !ok {
-		return ErrConfConvert
+		return plugins.ActionRespond, ErrConfConvert
 	}
-	_ = config
+	if config.Disable {
+		return plugins.ActionContinue, nil
+	}
+
+	header := r.Header.Peek(fasthttp.HeaderAuthorization)
+	if len(header) == 0 {
+		return plugins.ActionRespond, plugins.NewPluginError(fasthttp.StatusUnauthorized, errMissingAuthorization).
+			WithHeader(fasthttp.HeaderWWWAuthenticate, "Basic realm='.'")
+	}
+	username, password, ok := parseBasicAuth(header)
+	if !ok {
+		return plugins.ActionRespond, plugins.NewPluginError(fasthttp.StatusUnauthorized, errInvalidAuthorization)
+	}
+
+	consumer, consumerConf := plugins.LookupConsumer(p, username)
+	if consumer == nil || subtle.ConstantTimeCompare([]byte(consumerConf.(BasicAuthConsumerConf).Password), []byte(password)) != 1 {
+		return plugins.ActionRespond, plugins.NewPluginError(fasthttp.StatusUnauthorized, errInvalidAuthorization)
+	}
+	p.log.Debug("consumer authenticated", "username", consumer.Username)
 
-	return nil
+	if config.HideCredentials {
+		r.Header.Del(fasthttp.HeaderAuthorization)
+	}
+	ctx.AttachConsumer(consumer)
+	return plugins.ActionContinue, nil
+}
+
+// parseBasicAuth 解析Authorization请求头，格式为：Basic base64(username:password)
+func parseBasicAuth(header []byte) (username, password string, ok bool) {
+	const prefix = "Basic "
+	if len(header) < len(prefix) || !bytes.EqualFold(header[:len(prefix)], []byte(prefix)) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(header[len(prefix):])))
+	if err != nil {
+		return "", "", false
+	}
+	idx := bytes.IndexByte(decoded, ':')
+	if idx < 0 {
+		return "", "", false
+	}
+	return string(decoded[:idx]), string(decoded[idx+1:]), true
 }
@@ -0,0 +1,153 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"sync"
+	"time"
+
+	"github.com/chain5j/logger"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+// batchConf 日志类插件批量发送的配置，与APISIX的batch-processor一致
+type batchConf struct {
+	BatchMaxSize    int `json:"batch_max_size,omitempty" default:"1000"` // 每批最多的条数
+	InactiveTimeout int `json:"inactive_timeout,omitempty" default:"5"`  // 没有新数据时，最长等待的秒数
+	BufferDuration  int `json:"buffer_duration,omitempty" default:"60"`  // 一批中最早的数据最长等待的秒数
+	MaxRetryCount   int `json:"max_retry_count,omitempty"`               // 发送失败时的重试次数
+	RetryDelay      int `json:"retry_delay,omitempty" default:"1"`       // 重试的间隔秒数
+}
+
+func (c *batchConf) setDefaults() {
+	if c.BatchMaxSize <= 0 {
+		c.BatchMaxSize = 1000
+	}
+	if c.InactiveTimeout <= 0 {
+		c.InactiveTimeout = 5
+	}
+	if c.BufferDuration <= 0 {
+		c.BufferDuration = 60
+	}
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = 1
+	}
+}
+
+// batchProcessor 将数据攒批后发送
+type batchProcessor struct {
+	log  logger.Logger
+	conf batchConf
+	send func(entries []interface{}) error
+
+	mu      sync.Mutex
+	entries []interface{}
+	first   time.Time
+	timer   *time.Timer
+}
+
+// batchProcessors 按插件链的配置key(Context.ConfKey)保存batchProcessor，同一路由的请求共用一个，
+// 合并了consumer插件的请求按路由和consumer分别使用。
+// 路由或consumer变更后配置的key随之变化，旧的batchProcessor由release发送剩余数据后删除
+type batchProcessors struct {
+	mu         sync.Mutex
+	processors map[string]*batchProcessor
+}
+
+// get 获取key对应的batchProcessor，不存在时使用conf创建
+func (ps *batchProcessors) get(key string, conf batchConf, newSend func() func([]interface{}) error) *batchProcessor {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if p, ok := ps.processors[key]; ok {
+		return p
+	}
+	if ps.processors == nil {
+		ps.processors = make(map[string]*batchProcessor)
+	}
+	p := newBatchProcessor(conf, newSend())
+	ps.processors[key] = p
+	return p
+}
+
+// release 发送使用了released配置的batchProcessor中剩余的数据并将其删除
+func (ps *batchProcessors) release(released string) {
+	var removed []*batchProcessor
+	ps.mu.Lock()
+	for key, p := range ps.processors {
+		if plugins.ConfKeyUses(key, released) {
+			removed = append(removed, p)
+			delete(ps.processors, key)
+		}
+	}
+	ps.mu.Unlock()
+	for _, p := range removed {
+		p.flush()
+	}
+}
+
+func newBatchProcessor(conf batchConf, send func(entries []interface{}) error) *batchProcessor {
+	conf.setDefaults()
+	return &batchProcessor{
+		log:  logger.Log("batch-processor"),
+		conf: conf,
+		send: send,
+	}
+}
+
+// Push 添加一条数据
+func (b *batchProcessor) Push(entry interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.entries) == 0 {
+		b.first = time.Now()
+	}
+	b.entries = append(b.entries, entry)
+	if len(b.entries) >= b.conf.BatchMaxSize {
+		b.flushLocked()
+		return
+	}
+
+	wait := time.Duration(b.conf.InactiveTimeout) * time.Second
+	if remain := time.Duration(b.conf.BufferDuration)*time.Second - time.Since(b.first); remain < wait {
+		wait = remain
+	}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(wait, b.flush)
+	} else {
+		b.timer.Reset(wait)
+	}
+}
+
+func (b *batchProcessor) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushLocked()
+}
+
+func (b *batchProcessor) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	if len(b.entries) == 0 {
+		return
+	}
+	entries := b.entries
+	b.entries = nil
+	go b.sendWithRetry(entries)
+}
+
+func (b *batchProcessor) sendWithRetry(entries []interface{}) {
+	for i := 0; ; i++ {
+		err := b.send(entries)
+		if err == nil {
+			return
+		}
+		if i >= b.conf.MaxRetryCount {
+			b.log.Error("batch send failed, drop entries", "entries", len(entries), "retries", i, "err", err)
+			return
+		}
+		b.log.Warn("batch send failed, retry", "entries", len(entries), "retry", i+1, "err", err)
+		time.Sleep(time.Duration(b.conf.RetryDelay) * time.Second)
+	}
+}
@@ -0,0 +1,98 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchProcessor(t *testing.T) {
+	batches := make(chan []interface{}, 4)
+	b := newBatchProcessor(batchConf{BatchMaxSize: 2, InactiveTimeout: 1}, func(entries []interface{}) error {
+		batches <- entries
+		return nil
+	})
+
+	// 达到batch_max_size时立即发送
+	b.Push(1)
+	b.Push(2)
+	select {
+	case entries := <-batches:
+		assert.Equal(t, []interface{}{1, 2}, entries)
+	case <-time.After(time.Second):
+		t.Fatal("batch not flushed by size")
+	}
+
+	// 超过inactive_timeout时发送
+	b.Push(3)
+	select {
+	case entries := <-batches:
+		assert.Equal(t, []interface{}{3}, entries)
+	case <-time.After(3 * time.Second):
+		t.Fatal("batch not flushed by timeout")
+	}
+}
+
+func TestBatchProcessorsRelease(t *testing.T) {
+	batches := make(chan []interface{}, 4)
+	newSend := func() func([]interface{}) error {
+		return func(entries []interface{}) error {
+			batches <- entries
+			return nil
+		}
+	}
+	var ps batchProcessors
+	p := ps.get("route#1", batchConf{InactiveTimeout: 60}, newSend)
+	assert.Same(t, p, ps.get("route#1", batchConf{}, newSend))
+	p.Push(1)
+
+	// 配置释放时立即发送剩余的数据，并删除batchProcessor
+	ps.release("route#1")
+	select {
+	case entries := <-batches:
+		assert.Equal(t, []interface{}{1}, entries)
+	case <-time.After(time.Second):
+		t.Fatal("batch not flushed on release")
+	}
+	assert.Empty(t, ps.processors)
+	assert.NotSame(t, p, ps.get("route#1", batchConf{}, newSend))
+	ps.release("route#2")
+
+	// 合并了consumer插件的batchProcessor在路由或consumer的配置释放时删除
+	ps.get("route#1|consumer/jack#1", batchConf{}, newSend)
+	ps.get("route#2|consumer/jack#1", batchConf{}, newSend)
+	ps.get("route#2|consumer/rose#1", batchConf{}, newSend)
+	ps.release("consumer/jack#1")
+	assert.Len(t, ps.processors, 2)
+	ps.release("route#2")
+	assert.Len(t, ps.processors, 1)
+	assert.Contains(t, ps.processors, "route#1")
+}
+
+func TestGelfChunks(t *testing.T) {
+	chunks, err := gelfChunks([]byte("short"))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("short")}, chunks)
+
+	data := bytes.Repeat([]byte("a"), gelfChunkSize*2+1)
+	chunks, err = gelfChunks(data)
+	assert.NoError(t, err)
+	assert.Len(t, chunks, 3)
+	var joined []byte
+	for seq, chunk := range chunks {
+		assert.Equal(t, []byte{gelfMagicByte0, gelfMagicByte1}, chunk[:2])
+		assert.Equal(t, chunks[0][2:10], chunk[2:10])
+		assert.Equal(t, byte(seq), chunk[10])
+		assert.Equal(t, byte(3), chunk[11])
+		joined = append(joined, chunk[gelfHeaderSize:]...)
+	}
+	assert.Equal(t, data, joined)
+
+	_, err = gelfChunks(make([]byte, gelfChunkSize*gelfMaxChunks+1))
+	assert.Error(t, err)
+}
@@ -0,0 +1,209 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/chain5j/logger"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/store"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+var (
+	_ plugins.PluginV2 = new(ConsumerRestriction)
+)
+var (
+	errMissingConsumer       = fmt.Errorf("Missing authentication or identity verification.")
+	defaultMsgConsumerDenied = fmt.Errorf("The consumer_name is forbidden.")
+)
+
+const (
+	consumerRestrictionTypeName   = "consumer_name"   // 按consumer的用户名限制
+	consumerRestrictionTypeLabels = "consumer_labels" // 按consumer的标签限制，格式为：key:value 或 key
+)
+
+func init() {
+	p := &ConsumerRestriction{
+		log:      logger.Log("consumer-restriction"),
+		name:     "consumer-restriction",
+		version:  "0.1",
+		priority: 2400,
+	}
+	var err error
+	if p.validator, err = store.NewSchemaValidator(p.schema()); err != nil {
+		p.log.Error(p.schema()+" new schema validator err", "err", err)
+		return
+	}
+	if err = plugins.RegisterPluginV2(p); err != nil {
+		p.log.Error("failed to register plugin"+p.Name(), "err", err)
+	}
+}
+
+// ConsumerRestriction 根据认证插件识别的consumer限制访问
+type ConsumerRestriction struct {
+	log       logger.Logger
+	validator store.Validator
+
+	plugins.DefaultPluginV2
+	name     string
+	version  string
+	priority int64
+}
+
+type ConsumerRestrictionConf struct {
+	Disable bool `json:"disable"`
+	// Type 限制的类型，["consumer_name", "consumer_labels"]
+	Type string `json:"type,omitempty" default:"consumer_name"`
+	// Whitelist 允许访问的consumer
+	Whitelist []string `json:"whitelist,omitempty"`
+	// Blacklist 禁止访问的consumer
+	Blacklist []string `json:"blacklist,omitempty"`
+	// RejectedCode 拒绝访问时返回的状态码
+	RejectedCode int `json:"rejected_code,omitempty" default:"403"`
+	// RejectedMsg 拒绝访问时返回的消息
+	RejectedMsg string `json:"rejected_msg,omitempty"`
+
+	msgErr error
+}
+
+func (p *ConsumerRestriction) Name() string {
+	return p.name
+}
+
+func (p *ConsumerRestriction) Version() string {
+	return p.version
+}
+
+func (p *ConsumerRestriction) Priority() int64 {
+	return p.priority
+}
+
+func (p *ConsumerRestriction) ParseConf(in []byte) (interface{}, error) {
+	conf := ConsumerRestrictionConf{}
+	err := json.Unmarshal(in, &conf)
+	if err != nil {
+		p.log.Error("json unmarshal conf err", "err", err)
+		return nil, err
+	}
+	// Validate
+	err = p.validator.Validate(conf)
+	if err != nil {
+		p.log.Error("validate conf err", "err", err)
+		return nil, err
+	}
+	if len(conf.Type) == 0 {
+		conf.Type = consumerRestrictionTypeName
+	}
+	if conf.RejectedCode == 0 {
+		conf.RejectedCode = fasthttp.StatusForbidden
+	}
+	if len(conf.RejectedMsg) > 0 {
+		conf.msgErr = fmt.Errorf(conf.RejectedMsg)
+	} else {
+		conf.msgErr = defaultMsgConsumerDenied
+	}
+	return conf, nil
+}
+
+func (p *ConsumerRestriction) RequestFilter(ctx *plugins.Context, conf interface{}) (plugins.Action, error) {
+	config, ok := conf.(ConsumerRestrictionConf)
+	if !ok {
+		p.log.Warn(ErrConfConvert.Error())
+		return plugins.ActionRespond, ErrConfConvert
+	}
+	if config.Disable {
+		return plugins.ActionContinue, nil
+	}
+	consumer := ctx.Consumer
+	if consumer == nil {
+		return plugins.ActionRespond, plugins.NewPluginError(fasthttp.StatusUnauthorized, errMissingConsumer)
+	}
+
+	if len(config.Blacklist) > 0 && p.match(config.Type, consumer, config.Blacklist) {
+		p.log.Debug("consumer is black", "username", consumer.Username)
+		return plugins.ActionRespond, plugins.NewPluginError(config.RejectedCode, config.msgErr)
+	}
+	if len(config.Whitelist) > 0 && !p.match(config.Type, consumer, config.Whitelist) {
+		p.log.Debug("consumer is not white", "username", consumer.Username)
+		return plugins.ActionRespond, plugins.NewPluginError(config.RejectedCode, config.msgErr)
+	}
+	return plugins.ActionContinue, nil
+}
+
+// match consumer是否在列表中
+func (p *ConsumerRestriction) match(typ string, consumer *entity.Consumer, list []string) bool {
+	for _, item := range list {
+		switch typ {
+		case consumerRestrictionTypeLabels:
+			key, value, hasValue := strings.Cut(item, ":")
+			v, ok := consumer.Labels[key]
+			if ok && (!hasValue || v == value) {
+				return true
+			}
+		default:
+			if consumer.Username == item {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *ConsumerRestriction) schema() string {
+	return `
+{
+  "anyOf": [
+    {
+      "required": [
+        "whitelist"
+      ]
+    },
+    {
+      "required": [
+        "blacklist"
+      ]
+    }
+  ],
+  "properties": {
+    "blacklist": {
+      "items": {
+        "type": "string"
+      },
+      "minItems": 1,
+      "type": "array"
+    },
+    "disable": {
+      "type": "boolean"
+    },
+    "rejected_code": {
+      "minimum": 200,
+      "type": "integer"
+    },
+    "rejected_msg": {
+      "type": "string"
+    },
+    "type": {
+      "enum": [
+        "consumer_name",
+        "consumer_labels"
+      ],
+      "type": "string"
+    },
+    "whitelist": {
+      "items": {
+        "type": "string"
+      },
+      "minItems": 1,
+      "type": "array"
+    }
+  },
+  "type": "object"
+}
+`
+}
@@ -4,48 +4,65 @@
 package plugins
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/chain5j/logger"
-	"github.com/valyala/fasthttp"
 	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
 )
 
 var (
-	_ plugins.Plugin = new(GelfUdpLogger)
+	_ plugins.PluginV2  = new(GelfUdpLogger)
+	_ plugins.LogPlugin = new(GelfUdpLogger)
+)
+
+const (
+	gelfChunkSize   = 8192 - gelfHeaderSize // 每个分片的数据大小
+	gelfHeaderSize  = 12                    // 分片头：magic(2) + id(8) + seq(1) + count(1)
+	gelfMaxChunks   = 128                   // GELF最多支持128个分片
+	gelfLevelInfo   = 6
+	gelfVersion     = "1.1"
+	gelfMagicByte0  = 0x1e
+	gelfMagicByte1  = 0x0f
+	gelfMaxDatagram = gelfChunkSize + gelfHeaderSize
 )
 
 func init() {
-	err := plugins.RegisterPlugin(&GelfUdpLogger{
+	err := plugins.RegisterPluginV2(&GelfUdpLogger{
+		log:      logger.Log("gelf-udp-logger"),
 		name:     "gelf-udp-logger",
 		version:  "0.1",
-		priority: 10000,
+		priority: 400,
 	})
 	if err != nil {
 		logger.Fatal("failed to register plugin GelfUdpLogger", "err", err)
 	}
 }
 
+// GelfUdpLogger 将访问日志以GELF格式通过UDP发送给Graylog
 type GelfUdpLogger struct {
-	plugins.DefaultPlugin
+	log        logger.Logger
+	processors batchProcessors
+
+	plugins.DefaultPluginV2
 	name     string
 	version  string
 	priority int64
 }
 
 type GelfUdpLoggerConf struct {
-	Disable         bool   `json:"disable"`
-	Host            string `json:"host"`
-	Port            uint64 `json:"port"`
-	Timeout         uint64 `json:"timeout"`
-	LoggerName      string `json:"name"`
-	InactiveTimeout uint64 `json:"inactive_timeout"`
-	BufferDuration  uint64 `json:"buffer_duration"`
-	MaxRetryCount   uint64 `json:"max_retry_count"`
-	RetryDelay      uint64 `json:"retry_delay"`
-	IncludeReqBody  bool   `json:"include_req_body"`
-	BatchMaxSize    uint64 `json:"batch_max_size"`
+	Disable        bool   `json:"disable"`
+	Host           string `json:"host"`                       // Graylog的地址
+	Port           int    `json:"port"`                       // Graylog的端口
+	Timeout        int    `json:"timeout" default:"3"`        // 发送的超时秒数
+	LoggerName     string `json:"name" default:"gelf logger"` // batchProcessor的名称
+	IncludeReqBody bool   `json:"include_req_body"`           // 日志中是否包含请求体
+	batchConf
 }
 
 func (p *GelfUdpLogger) Name() string {
@@ -59,18 +76,127 @@ func (p *GelfUdpLogger) Version() string {
 func (p *GelfUdpLogger) Priority() int64 {
 	return p.priority
 }
+
 func (p *GelfUdpLogger) ParseConf(in []byte) (interface{}, error) {
 	conf := GelfUdpLoggerConf{}
 	err := json.Unmarshal(in, &conf)
-	return conf, err
+	if err != nil {
+		return nil, err
+	}
+	if len(conf.Host) == 0 || conf.Port <= 0 {
+		return nil, fmt.Errorf("host and port are required")
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 3
+	}
+	if len(conf.LoggerName) == 0 {
+		conf.LoggerName = "gelf logger"
+	}
+	conf.batchConf.setDefaults()
+	return conf, nil
+}
+
+// ReleaseConf 路由或consumer的配置被删除或替换时，发送剩余的日志并释放对应的batchProcessor
+func (p *GelfUdpLogger) ReleaseConf(released string) {
+	p.processors.release(released)
 }
 
-func (p *GelfUdpLogger) RequestFilter(conf interface{}, r *fasthttp.Request, w *fasthttp.Response) error {
+// Log 将日志加入批量发送的队列
+func (p *GelfUdpLogger) Log(ctx *plugins.Context, conf interface{}) {
 	config, ok := conf.(GelfUdpLoggerConf)
 	if !ok {
-		return fmt.Errorf("convert to GelfUdpLogger conf err")
+		p.log.Warn(ErrConfConvert.Error())
+		return
+	}
+	if config.Disable {
+		return
 	}
-	_ = config
+	entry := plugins.NewLogEntry(ctx, config.IncludeReqBody)
+	p.processors.get(ctx.ConfKey(), config.batchConf, func() func([]interface{}) error {
+		return func(entries []interface{}) error {
+			return p.send(config, entries)
+		}
+	}).Push(entry)
+}
 
+func (p *GelfUdpLogger) send(config GelfUdpLoggerConf, entries []interface{}) error {
+	conn, err := net.Dial("udp", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	hostname, _ := os.Hostname()
+	for _, entry := range entries {
+		data, err := gelfMessage(hostname, entry.(*plugins.LogEntry))
+		if err != nil {
+			return err
+		}
+		chunks, err := gelfChunks(data)
+		if err != nil {
+			return err
+		}
+		for _, chunk := range chunks {
+			_ = conn.SetWriteDeadline(time.Now().Add(time.Duration(config.Timeout) * time.Second))
+			if _, err = conn.Write(chunk); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
+
+// gelfMessage 生成GELF 1.1格式的消息，附加字段以"_"开头
+func gelfMessage(hostname string, entry *plugins.LogEntry) ([]byte, error) {
+	full, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	msg := map[string]interface{}{
+		"version":       gelfVersion,
+		"host":          hostname,
+		"short_message": fmt.Sprintf("%s %s %d", entry.Request.Method, entry.Request.URI, entry.Response.Status),
+		"full_message":  string(full),
+		"timestamp":     float64(entry.StartTime) / 1000,
+		"level":         gelfLevelInfo,
+		"_client_ip":    entry.ClientIP,
+		"_status":       entry.Response.Status,
+		"_latency":      entry.Latency,
+		"_upstream":     entry.Upstream,
+		"_route_id":     entry.RouteID,
+		"_service_id":   entry.ServiceID,
+	}
+	if entry.Consumer != nil {
+		msg["_consumer"] = entry.Consumer.Username
+	}
+	return json.Marshal(msg)
+}
+
+// gelfChunks 超过UDP包大小的消息按GELF的分片格式拆分
+func gelfChunks(data []byte) ([][]byte, error) {
+	if len(data) <= gelfMaxDatagram {
+		return [][]byte{data}, nil
+	}
+	count := (len(data) + gelfChunkSize - 1) / gelfChunkSize
+	if count > gelfMaxChunks {
+		return nil, fmt.Errorf("gelf message too large: %d bytes", len(data))
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	chunks := make([][]byte, 0, count)
+	for seq := 0; seq < count; seq++ {
+		end := (seq + 1) * gelfChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := make([]byte, 0, gelfHeaderSize+end-seq*gelfChunkSize)
+		chunk = append(chunk, gelfMagicByte0, gelfMagicByte1)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(seq), byte(count))
+		chunk = append(chunk, data[seq*gelfChunkSize:end]...)
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,157 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/chain5j/chain5j-pkg/util/convutil"
+	"github.com/chain5j/logger"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins/plugins/transcoder"
+)
+
+var (
+	_ plugins.PluginV2 = new(GrpcTranscode)
+)
+
+const (
+	grpcTranscodeCtxKey = "grpc_transcode" // 请求阶段查找到的transcoder.Call
+)
+
+func init() {
+	err := plugins.RegisterPluginV2(&GrpcTranscode{
+		log:      logger.Log("grpc-transcode"),
+		name:     "grpc-transcode",
+		version:  "0.1",
+		priority: 506,
+	})
+	if err != nil {
+		logger.Fatal("failed to register plugin GrpcTranscode", "err", err)
+	}
+}
+
+// GrpcTranscode 将HTTP的JSON请求转换为gRPC调用，并将gRPC的响应转换为JSON。
+// 路由上游的scheme需要为grpc或grpcs
+type GrpcTranscode struct {
+	log logger.Logger
+
+	plugins.DefaultPluginV2
+	name     string
+	version  string
+	priority int64
+}
+
+type GrpcTranscodeConf struct {
+	Disable  bool        `json:"disable"`
+	ProtoID  interface{} `json:"proto_id"`  // proto store中proto的id
+	Service  string      `json:"service"`   // 服务的全名，如：helloworld.Greeter
+	Method   string      `json:"method"`    // 方法名，如：SayHello
+	Deadline int         `json:"deadline"`  // 调用的超时毫秒数，0为不限制
+	PbOption []string    `json:"pb_option"` // JSON编码的选项，如：enum_as_value、use_default_values
+}
+
+func (p *GrpcTranscode) Name() string {
+	return p.name
+}
+
+func (p *GrpcTranscode) Version() string {
+	return p.version
+}
+
+func (p *GrpcTranscode) Priority() int64 {
+	return p.priority
+}
+
+func (p *GrpcTranscode) ParseConf(in []byte) (interface{}, error) {
+	conf := GrpcTranscodeConf{}
+	if err := json.Unmarshal(in, &conf); err != nil {
+		return nil, err
+	}
+	if conf.ProtoID == nil || len(conf.Service) == 0 || len(conf.Method) == 0 {
+		return nil, errors.New("proto_id, service and method are required")
+	}
+	return conf, nil
+}
+
+// RequestFilter 将请求体和查询参数编码为gRPC消息，改写为gRPC的请求
+func (p *GrpcTranscode) RequestFilter(ctx *plugins.Context, conf interface{}) (plugins.Action, error) {
+	config, ok := conf.(GrpcTranscodeConf)
+	if !ok {
+		return plugins.ActionContinue, ErrConfConvert
+	}
+	if config.Disable {
+		return plugins.ActionContinue, nil
+	}
+	call, err := transcoder.Method(convutil.ToString(config.ProtoID), config.Service, config.Method)
+	if err != nil {
+		p.log.Error("grpc transcode find method err", "proto", config.ProtoID, "service", config.Service, "method", config.Method, "err", err)
+		return plugins.ActionRespond, plugins.NewPluginError(fasthttp.StatusServiceUnavailable, err)
+	}
+	req := ctx.Request
+	args := make(url.Values)
+	req.URI().QueryArgs().VisitAll(func(key, value []byte) {
+		args.Add(string(key), string(value))
+	})
+	body, err := call.EncodeRequest(req.Body(), args)
+	if err != nil {
+		return plugins.ActionRespond, plugins.NewPluginError(fasthttp.StatusBadRequest, err)
+	}
+
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.URI().SetPath(call.Path())
+	req.URI().SetQueryString("")
+	req.Header.SetContentType("application/grpc")
+	req.Header.Del(fasthttp.HeaderAcceptEncoding)
+	req.Header.Set("Te", "trailers")
+	if config.Deadline > 0 {
+		req.Header.Set("Grpc-Timeout", strconv.Itoa(config.Deadline)+"m")
+	}
+	req.SetBody(body)
+	ctx.Set(grpcTranscodeCtxKey, call)
+	return plugins.ActionContinue, nil
+}
+
+// ResponseFilter 将gRPC的响应或grpc-status不为0时的错误转换为JSON
+func (p *GrpcTranscode) ResponseFilter(ctx *plugins.Context, conf interface{}) (plugins.Action, error) {
+	config, ok := conf.(GrpcTranscodeConf)
+	if !ok {
+		return plugins.ActionContinue, ErrConfConvert
+	}
+	v, ok := ctx.Get(grpcTranscodeCtxKey)
+	if !ok {
+		return plugins.ActionContinue, nil
+	}
+	call := v.(*transcoder.Call)
+	resp := ctx.Response
+	grpcStatus := resp.Header.Peek("Grpc-Status")
+	if len(grpcStatus) == 0 {
+		// 不是gRPC的响应，如上游返回的HTTP错误
+		return plugins.ActionContinue, nil
+	}
+	code, err := strconv.Atoi(string(grpcStatus))
+	if err != nil {
+		return plugins.ActionRespond, plugins.NewPluginError(fasthttp.StatusBadGateway, err)
+	}
+
+	var body []byte
+	if code == 0 {
+		body, err = call.DecodeResponse(resp.Body(), config.PbOption)
+	} else {
+		body, err = call.DecodeStatus(code, string(resp.Header.Peek("Grpc-Message")),
+			string(resp.Header.Peek("Grpc-Status-Details-Bin")), config.PbOption)
+	}
+	if err != nil {
+		p.log.Error("grpc transcode decode response err", "service", config.Service, "method", config.Method, "err", err)
+		return plugins.ActionRespond, plugins.NewPluginError(fasthttp.StatusBadGateway, err)
+	}
+	resp.SetStatusCode(transcoder.HTTPStatus(code))
+	resp.Header.SetContentType("application/json")
+	resp.SetBody(body)
+	return plugins.ActionContinue, nil
+}
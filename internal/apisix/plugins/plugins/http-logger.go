@@ -4,8 +4,10 @@
 package plugins
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/chain5j/logger"
 	"github.com/valyala/fasthttp"
@@ -13,40 +15,49 @@ import (
 )
 
 var (
-	_ plugins.Plugin = new(HttpLogger)
+	_ plugins.PluginV2  = new(HttpLogger)
+	_ plugins.LogPlugin = new(HttpLogger)
+)
+
+const (
+	concatMethodJson    = "json"     // 一批日志以JSON数组发送
+	concatMethodNewLine = "new_line" // 一批日志以换行分隔的JSON发送
 )
 
 func init() {
-	err := plugins.RegisterPlugin(&HttpLogger{
+	err := plugins.RegisterPluginV2(&HttpLogger{
+		log:      logger.Log("http-logger"),
+		client:   &fasthttp.Client{Name: "http-logger"},
 		name:     "http-logger",
 		version:  "0.1",
-		priority: 10000,
+		priority: 410,
 	})
 	if err != nil {
 		logger.Fatal("failed to register plugin HttpLogger", "err", err)
 	}
 }
 
+// HttpLogger 将访问日志批量POST到HTTP服务
 type HttpLogger struct {
-	plugins.DefaultPlugin
+	log        logger.Logger
+	client     *fasthttp.Client
+	processors batchProcessors
+
+	plugins.DefaultPluginV2
 	name     string
 	version  string
 	priority int64
 }
 
 type HttpLoggerConf struct {
-	Disable         bool   `json:"disable"`
-	URI             string `json:"uri"`
-	AuthHeader      string `json:"auth_header"`
-	Timeout         uint64 `json:"timeout"`
-	LoggerName      string `json:"name"`
-	BatchMaxSize    uint64 `json:"batch_max_size"`
-	InactiveTimeout uint64 `json:"inactive_timeout"`
-	BufferDuration  uint64 `json:"buffer_duration"`
-	MaxRetryCount   uint64 `json:"max_retry_count"`
-	RetryDelay      uint64 `json:"retry_delay"`
-	IncludeReqBody  bool   `json:"include_req_body"`
-	ConcatMethod    string `json:"concat_method"`
+	Disable        bool   `json:"disable"`
+	URI            string `json:"uri"`                          // 接收日志的地址
+	AuthHeader     string `json:"auth_header"`                  // Authorization请求头
+	Timeout        int    `json:"timeout" default:"3"`          // 发送的超时秒数
+	LoggerName     string `json:"name" default:"http logger"`   // batchProcessor的名称
+	IncludeReqBody bool   `json:"include_req_body"`             // 日志中是否包含请求体
+	ConcatMethod   string `json:"concat_method" default:"json"` // ["json", "new_line"]
+	batchConf
 }
 
 func (p *HttpLogger) Name() string {
@@ -64,15 +75,98 @@ func (p *HttpLogger) Priority() int64 {
 func (p *HttpLogger) ParseConf(in []byte) (interface{}, error) {
 	conf := HttpLoggerConf{}
 	err := json.Unmarshal(in, &conf)
-	return conf, err
+	if err != nil {
+		return nil, err
+	}
+	if len(conf.URI) == 0 {
+		return nil, fmt.Errorf("uri is required")
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 3
+	}
+	if len(conf.LoggerName) == 0 {
+		conf.LoggerName = "http logger"
+	}
+	switch conf.ConcatMethod {
+	case "":
+		conf.ConcatMethod = concatMethodJson
+	case concatMethodJson, concatMethodNewLine:
+	default:
+		return nil, fmt.Errorf("unsupported concat_method: %s", conf.ConcatMethod)
+	}
+	conf.batchConf.setDefaults()
+	return conf, nil
+}
+
+// ReleaseConf 路由或consumer的配置被删除或替换时，发送剩余的日志并释放对应的batchProcessor
+func (p *HttpLogger) ReleaseConf(released string) {
+	p.processors.release(released)
 }
 
-func (p *HttpLogger) RequestFilter(conf interface{}, r *fasthttp.Request, w *fasthttp.Response) error {
+// Log 将日志加入批量发送的队列
+func (p *HttpLogger) Log(ctx *plugins.Context, conf interface{}) {
 	config, ok := conf.(HttpLoggerConf)
 	if !ok {
-		return fmt.Errorf("convert to HttpLogger conf err")
+		p.log.Warn(ErrConfConvert.Error())
+		return
+	}
+	if config.Disable {
+		return
+	}
+	entry := plugins.NewLogEntry(ctx, config.IncludeReqBody)
+	p.processors.get(ctx.ConfKey(), config.batchConf, func() func([]interface{}) error {
+		return func(entries []interface{}) error {
+			return p.send(config, entries)
+		}
+	}).Push(entry)
+}
+
+func (p *HttpLogger) send(config HttpLoggerConf, entries []interface{}) error {
+	body, err := p.encode(config.ConcatMethod, entries)
+	if err != nil {
+		return err
 	}
-	_ = config
 
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI(config.URI)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	if config.ConcatMethod == concatMethodJson {
+		req.Header.SetContentType("application/json")
+	} else {
+		req.Header.SetContentType("text/plain")
+	}
+	if len(config.AuthHeader) > 0 {
+		req.Header.Set(fasthttp.HeaderAuthorization, config.AuthHeader)
+	}
+	req.SetBody(body)
+
+	err = p.client.DoTimeout(req, resp, time.Duration(config.Timeout)*time.Second)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() >= fasthttp.StatusBadRequest {
+		return fmt.Errorf("%s: server returned status %d", config.LoggerName, resp.StatusCode())
+	}
 	return nil
 }
+
+// encode 按concat_method拼接一批日志，json方式下只有一条日志时发送JSON对象
+func (p *HttpLogger) encode(method string, entries []interface{}) ([]byte, error) {
+	if method == concatMethodNewLine {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, entry := range entries {
+			if err := enc.Encode(entry); err != nil {
+				return nil, err
+			}
+		}
+		return buf.Bytes(), nil
+	}
+	if len(entries) == 1 {
+		return json.Marshal(entries[0])
+	}
+	return json.Marshal(entries)
+}
@@ -0,0 +1,80 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+// TestHttpLoggerConsumerRoutes 同一个consumer访问不同的路由，日志发送到各自路由配置的地址
+func TestHttpLoggerConsumerRoutes(t *testing.T) {
+	plugins.InitConfCache(time.Minute)
+	var received [2]atomic.Int32
+	var sinks [2]*httptest.Server
+	for i := range sinks {
+		i := i
+		sinks[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received[i].Add(1)
+		}))
+		defer sinks[i].Close()
+	}
+	plugins.StoreConsumers([]*entity.Consumer{{
+		Username: "jack",
+		Plugins: map[string]interface{}{
+			"key-auth": map[string]interface{}{"key": "jack-key"},
+			// consumer配置了非认证插件时，插件链会被合并
+			"proxy-rewrite": map[string]interface{}{
+				"headers": map[string]string{"X-Rewrite": "consumer"},
+			},
+		},
+	}})
+	defer plugins.StoreConsumers(nil)
+
+	for i, sink := range sinks {
+		key, err := plugins.PrepareConf("http-logger-route-"+string(rune('a'+i)), map[string]interface{}{
+			"key-auth":    map[string]interface{}{},
+			"http-logger": map[string]interface{}{"uri": sink.URL, "batch_max_size": 1},
+		})
+		assert.NoError(t, err)
+
+		req := fasthttp.AcquireRequest()
+		resp := fasthttp.AcquireResponse()
+		req.Header.Set("apikey", "jack-key")
+		ctx := plugins.NewContext(new(fasthttp.RequestCtx), nil, req, resp)
+		chain, err := plugins.NewChain(key)
+		assert.NoError(t, err)
+		_, err = chain.Run(plugins.PhaseRewrite, ctx)
+		assert.NoError(t, err)
+		chain = chain.MergeConsumer(ctx)
+		_, err = chain.Run(plugins.PhaseAccess, ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "consumer", string(req.Header.Peek("X-Rewrite")))
+		_, _ = chain.Run(plugins.PhaseLog, ctx)
+		// 合并后的插件链同时使用路由和consumer的配置
+		assert.NotEqual(t, key, ctx.ConfKey())
+		assert.True(t, plugins.ConfKeyUses(ctx.ConfKey(), key), ctx.ConfKey())
+		assert.True(t, plugins.ConfKeyUses(ctx.ConfKey(), plugins.ConsumerConfKey(plugins.Consumers()[0])), ctx.ConfKey())
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+	}
+
+	for i := range sinks {
+		i := i
+		assert.Eventually(t, func() bool {
+			return received[i].Load() == 1
+		}, 3*time.Second, 10*time.Millisecond, "sink %d", i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), received[0].Load())
+	assert.Equal(t, int32(1), received[1].Load())
+}
@@ -12,22 +12,29 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/savsgio/gotils/strconv"
 	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
 	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
 )
 
 var (
-	_ plugins.Plugin = new(JwtAuth)
+	_ plugins.IndexedAuthPlugin = new(JwtAuth)
+	_ plugins.RewritePlugin     = new(JwtAuth)
 )
 var (
 	bearerLength           = len(`Bearer `)
 	badAuthorizationHeader = fmt.Errorf(`bad authorization header`)
+	errMissingJwtToken     = fmt.Errorf("Missing JWT token in request")
+	errInvalidJwtToken     = fmt.Errorf("JWT token invalid")
+	errMissingJwtKey       = fmt.Errorf("missing user key in JWT token")
+	errInvalidJwtKey       = fmt.Errorf("Invalid user key in JWT token")
 )
 
 func init() {
-	err := plugins.RegisterPlugin(&JwtAuth{
+	err := plugins.RegisterPluginV2(&JwtAuth{
+		log:      logger.Log("jwt-auth"),
 		name:     "jwt-auth",
 		version:  "0.1",
-		priority: 10000,
+		priority: 2510,
 	})
 	if err != nil {
 		logger.Fatal("failed to register plugin JwtAuth", "err", err)
@@ -35,7 +42,9 @@ func init() {
 }
 
 type JwtAuth struct {
-	plugins.DefaultPlugin
+	log logger.Logger
+
+	plugins.DefaultPluginV2
 	name     string
 	version  string
 	priority int64
@@ -44,12 +53,23 @@ type JwtAuth struct {
 type JwtAuthConf struct {
 	Disable bool `json:"disable"`
 
+	// Secret 路由级别的密钥，配置后不再通过consumer校验token
 	Secret            string `json:"secret"`
 	TokenFormat       string `json:"token_format"`
 	TokenHeader       string `json:"token_header"`
 	ParsedTokenHeader string `json:"parsed_token_header"`
 }
 
+// JwtAuthConsumerConf consumer中jwt-auth的配置
+type JwtAuthConsumerConf struct {
+	Key       string `json:"key"`                                 // token中key字段的值
+	Secret    string `json:"secret,omitempty"`                    // HS256、HS512使用的密钥
+	PublicKey string `json:"public_key,omitempty"`                // RS256使用的公钥
+	Algorithm string `json:"algorithm,omitempty" default:"HS256"` // ["HS256", "HS512", "RS256"]
+
+	verifyKey interface{}
+}
+
 func (p *JwtAuth) Name() string {
 	return p.name
 }
@@ -68,6 +88,9 @@ func (p *JwtAuth) ParseConf(in []byte) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(conf.TokenFormat) == 0 {
+		conf.TokenFormat = `Bearer`
+	}
 	if conf.TokenFormat != `Bearer` && conf.TokenFormat != `Custom` {
 		return nil, fmt.Errorf(`must specify header format "Bearer" or "Custom"`)
 	}
@@ -75,26 +98,120 @@ func (p *JwtAuth) ParseConf(in []byte) (interface{}, error) {
 	return conf, err
 }
 
-func (p *JwtAuth) RequestFilter(conf interface{}, r *fasthttp.Request, w *fasthttp.Response) error {
+func (p *JwtAuth) ParseConsumerConf(in []byte) (interface{}, error) {
+	conf := JwtAuthConsumerConf{}
+	err := json.Unmarshal(in, &conf)
+	if err != nil {
+		return nil, err
+	}
+	if len(conf.Key) == 0 {
+		return nil, fmt.Errorf("key is required")
+	}
+	if len(conf.Algorithm) == 0 {
+		conf.Algorithm = "HS256"
+	}
+	switch conf.Algorithm {
+	case "HS256", "HS512":
+		if len(conf.Secret) == 0 {
+			return nil, fmt.Errorf("secret is required")
+		}
+		conf.verifyKey = []byte(conf.Secret)
+	case "RS256":
+		conf.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM([]byte(conf.PublicKey))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", conf.Algorithm)
+	}
+	return conf, nil
+}
+
+// ConsumerIndex 按key索引consumer
+func (p *JwtAuth) ConsumerIndex(conf interface{}) string {
+	return conf.(JwtAuthConsumerConf).Key
+}
+
+// Rewrite 在rewrite阶段识别consumer
+func (p *JwtAuth) Rewrite(ctx *plugins.Context, conf interface{}) (plugins.Action, error) {
+	r := ctx.Request
 	config, ok := conf.(JwtAuthConf)
 	if !ok {
-		return fmt.Errorf("convert to JwtAuth conf err")
+		return plugins.ActionRespond, fmt.Errorf("convert to JwtAuth conf err")
+	}
+	if config.Disable {
+		return plugins.ActionContinue, nil
+	}
+	if len(config.Secret) > 0 {
+		if err := p.verifyWithSecret(config, r); err != nil {
+			return plugins.ActionRespond, err
+		}
+		return plugins.ActionContinue, nil
 	}
+
 	tokenString := getToken(r, config)
 	if tokenString == `` {
-		w.SetStatusCode(fasthttp.StatusForbidden)
-		return badAuthorizationHeader
+		return plugins.ActionRespond, plugins.NewPluginError(fasthttp.StatusUnauthorized, errMissingJwtToken)
 	}
+	var consumer *entity.Consumer
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return config.Secret, nil
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			return nil, errMissingJwtKey
+		}
+		key, _ := claims["key"].(string)
+		if len(key) == 0 {
+			return nil, errMissingJwtKey
+		}
+		var conf interface{}
+		consumer, conf = plugins.LookupConsumer(p, key)
+		if consumer == nil {
+			return nil, errInvalidJwtKey
+		}
+		consumerConf := conf.(JwtAuthConsumerConf)
+		if token.Method.Alg() != consumerConf.Algorithm {
+			return nil, errInvalidJwtToken
+		}
+		return consumerConf.verifyKey, nil
 	})
 	if err != nil || !token.Valid {
-		w.SetStatusCode(fasthttp.StatusForbidden)
-		return err
+		if vErr, ok := err.(*jwt.ValidationError); ok && vErr.Inner != nil {
+			return plugins.ActionRespond, plugins.NewPluginError(fasthttp.StatusUnauthorized, vErr.Inner)
+		}
+		return plugins.ActionRespond, plugins.NewPluginError(fasthttp.StatusUnauthorized, errInvalidJwtToken)
+	}
+	p.log.Debug("consumer authenticated", "username", consumer.Username)
+	p.setParsedToken(config, r, tokenString)
+	ctx.AttachConsumer(consumer)
+	return plugins.ActionContinue, nil
+}
+
+// verifyWithSecret 使用路由中配置的密钥校验token
+func (p *JwtAuth) verifyWithSecret(config JwtAuthConf, r *fasthttp.Request) error {
+	tokenString := getToken(r, config)
+	if tokenString == `` {
+		return plugins.NewPluginError(fasthttp.StatusForbidden, badAuthorizationHeader)
+	}
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(config.Secret), nil
+	})
+	if err != nil || !token.Valid {
+		if err == nil {
+			err = errInvalidJwtToken
+		}
+		return plugins.NewPluginError(fasthttp.StatusForbidden, err)
+	}
+	p.setParsedToken(config, r, tokenString)
+	return nil
+}
+
+// setParsedToken 将token的payload设置到请求头中
+func (p *JwtAuth) setParsedToken(config JwtAuthConf, r *fasthttp.Request, tokenString string) {
+	if len(config.ParsedTokenHeader) == 0 {
+		return
 	}
 	segment, _ := jwt.DecodeSegment(strings.Split(tokenString, ".")[1]) // todo: remove aloc
 	r.Header.SetBytesV(config.ParsedTokenHeader, segment)
-	return nil
 }
 
 func getToken(req *fasthttp.Request, config JwtAuthConf) string {
@@ -0,0 +1,140 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+
+	"github.com/chain5j/logger"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+var (
+	_ plugins.IndexedAuthPlugin = new(KeyAuth)
+	_ plugins.RewritePlugin     = new(KeyAuth)
+)
+var (
+	errMissingApiKey = fmt.Errorf("Missing API key found in request")
+	errInvalidApiKey = fmt.Errorf("Invalid API key in request")
+)
+
+func init() {
+	err := plugins.RegisterPluginV2(&KeyAuth{
+		log:      logger.Log("key-auth"),
+		name:     "key-auth",
+		version:  "0.1",
+		priority: 2500,
+	})
+	if err != nil {
+		logger.Fatal("failed to register plugin KeyAuth", "err", err)
+	}
+}
+
+// KeyAuth 通过请求头或请求参数中的key识别consumer
+type KeyAuth struct {
+	log logger.Logger
+
+	plugins.DefaultPluginV2
+	name     string
+	version  string
+	priority int64
+}
+
+type KeyAuthConf struct {
+	Disable bool `json:"disable"`
+
+	Header          string `json:"header,omitempty" default:"apikey"` // 读取key的请求头
+	Query           string `json:"query,omitempty" default:"apikey"`  // 读取key的请求参数
+	HideCredentials bool   `json:"hide_credentials,omitempty"`        // 是否在转发给上游前删除key
+}
+
+// KeyAuthConsumerConf consumer中key-auth的配置
+type KeyAuthConsumerConf struct {
+	Key string `json:"key"`
+}
+
+func (p *KeyAuth) Name() string {
+	return p.name
+}
+
+func (p *KeyAuth) Version() string {
+	return p.version
+}
+
+func (p *KeyAuth) Priority() int64 {
+	return p.priority
+}
+
+func (p *KeyAuth) ParseConf(in []byte) (interface{}, error) {
+	conf := KeyAuthConf{}
+	err := json.Unmarshal(in, &conf)
+	if err != nil {
+		return nil, err
+	}
+	if len(conf.Header) == 0 {
+		conf.Header = "apikey"
+	}
+	if len(conf.Query) == 0 {
+		conf.Query = "apikey"
+	}
+	return conf, nil
+}
+
+func (p *KeyAuth) ParseConsumerConf(in []byte) (interface{}, error) {
+	conf := KeyAuthConsumerConf{}
+	err := json.Unmarshal(in, &conf)
+	if err != nil {
+		return nil, err
+	}
+	if len(conf.Key) == 0 {
+		return nil, fmt.Errorf("key is required")
+	}
+	return conf, nil
+}
+
+// ConsumerIndex 按key索引consumer
+func (p *KeyAuth) ConsumerIndex(conf interface{}) string {
+	return conf.(KeyAuthConsumerConf).Key
+}
+
+// Rewrite 在rewrite阶段识别consumer
+func (p *KeyAuth) Rewrite(ctx *plugins.Context, conf interface{}) (plugins.Action, error) {
+	r := ctx.Request
+	config, ok := conf.(KeyAuthConf)
+	if !ok {
+		return plugins.ActionRespond, ErrConfConvert
+	}
+	if config.Disable {
+		return plugins.ActionContinue, nil
+	}
+
+	fromHeader := true
+	key := string(r.Header.Peek(config.Header))
+	if len(key) == 0 {
+		fromHeader = false
+		key = string(r.URI().QueryArgs().Peek(config.Query))
+	}
+	if len(key) == 0 {
+		return plugins.ActionRespond, plugins.NewPluginError(fasthttp.StatusUnauthorized, errMissingApiKey)
+	}
+
+	consumer, consumerConf := plugins.LookupConsumer(p, key)
+	if consumer == nil || subtle.ConstantTimeCompare([]byte(consumerConf.(KeyAuthConsumerConf).Key), []byte(key)) != 1 {
+		return plugins.ActionRespond, plugins.NewPluginError(fasthttp.StatusUnauthorized, errInvalidApiKey)
+	}
+	p.log.Debug("consumer authenticated", "username", consumer.Username)
+
+	if config.HideCredentials {
+		if fromHeader {
+			r.Header.Del(config.Header)
+		} else {
+			r.URI().QueryArgs().Del(config.Query)
+		}
+	}
+	ctx.AttachConsumer(consumer)
+	return plugins.ActionContinue, nil
+}
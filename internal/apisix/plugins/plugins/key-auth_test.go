@@ -0,0 +1,126 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+func TestKeyAuthConsumer(t *testing.T) {
+	plugins.InitConfCache(time.Minute)
+	plugins.StoreConsumers([]*entity.Consumer{
+		{
+			Username: "jack",
+			Plugins: map[string]interface{}{
+				"key-auth": map[string]interface{}{"key": "jack-key"},
+				"proxy-rewrite": map[string]interface{}{
+					"headers": map[string]string{"X-Rewrite": "consumer"},
+				},
+			},
+		},
+		{
+			Username: "rose",
+			Plugins: map[string]interface{}{
+				"key-auth": map[string]interface{}{"key": "rose-key"},
+			},
+		},
+	})
+	key, err := plugins.PrepareConf("key-auth-test", map[string]interface{}{
+		"key-auth":             map[string]interface{}{"hide_credentials": true},
+		"consumer-restriction": map[string]interface{}{"whitelist": []string{"jack"}},
+		"proxy-rewrite": map[string]interface{}{
+			"headers": map[string]string{"X-Rewrite": "route"},
+		},
+	})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		apikey     string
+		wantStatus int
+		wantUser   string
+	}{
+		{"", fasthttp.StatusUnauthorized, ""},
+		{"unknown", fasthttp.StatusUnauthorized, ""},
+		{"rose-key", fasthttp.StatusForbidden, "rose"},
+		{"jack-key", fasthttp.StatusOK, "jack"},
+	}
+	for _, tt := range tests {
+		req := fasthttp.AcquireRequest()
+		resp := fasthttp.AcquireResponse()
+		if len(tt.apikey) > 0 {
+			req.Header.Set("apikey", tt.apikey)
+		}
+
+		ctx := plugins.NewContext(new(fasthttp.RequestCtx), nil, req, resp)
+		assert.Equal(t, tt.wantStatus, runRequestPhases(t, key, ctx), tt.apikey)
+		if consumer := ctx.Consumer; tt.wantUser != "" && assert.NotNil(t, consumer, tt.apikey) {
+			assert.Equal(t, tt.wantUser, consumer.Username)
+			assert.Equal(t, tt.wantUser, string(req.Header.Peek(plugins.HeaderConsumerUsername)))
+			assert.Empty(t, req.Header.Peek("apikey"))
+		}
+		if tt.wantStatus == fasthttp.StatusOK {
+			// 同名插件使用consumer的配置
+			assert.Equal(t, "consumer", string(req.Header.Peek("X-Rewrite")))
+		}
+
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+	}
+}
+
+func TestLookupConsumer(t *testing.T) {
+	keyAuth := &KeyAuth{name: "key-auth"}
+	basicAuth := &BasicAuth{name: "basic-auth"}
+	plugins.StoreConsumers([]*entity.Consumer{
+		{Username: "rose", Plugins: map[string]interface{}{"key-auth": map[string]interface{}{"key": "shared-key"}}},
+		{Username: "jack", Plugins: map[string]interface{}{
+			"key-auth":   map[string]interface{}{"key": "shared-key"},
+			"basic-auth": map[string]interface{}{"username": "jack-user", "password": "secret"},
+		}},
+	})
+	defer plugins.StoreConsumers(nil)
+
+	// key重复时使用用户名排序在前的consumer
+	consumer, conf := plugins.LookupConsumer(keyAuth, "shared-key")
+	if assert.NotNil(t, consumer) {
+		assert.Equal(t, "jack", consumer.Username)
+		assert.Equal(t, "shared-key", conf.(KeyAuthConsumerConf).Key)
+	}
+	consumer, conf = plugins.LookupConsumer(basicAuth, "jack-user")
+	if assert.NotNil(t, consumer) {
+		assert.Equal(t, "secret", conf.(BasicAuthConsumerConf).Password)
+	}
+	consumer, _ = plugins.LookupConsumer(basicAuth, "jack")
+	assert.Nil(t, consumer)
+
+	// consumer变更后使用新的索引
+	plugins.StoreConsumers([]*entity.Consumer{
+		{Username: "jack", Plugins: map[string]interface{}{"key-auth": map[string]interface{}{"key": "new-key"}}},
+	})
+	consumer, _ = plugins.LookupConsumer(keyAuth, "shared-key")
+	assert.Nil(t, consumer)
+	consumer, _ = plugins.LookupConsumer(keyAuth, "new-key")
+	assert.NotNil(t, consumer)
+}
+
+// runRequestPhases 与proxy一致，依次执行rewrite、合并consumer的插件、access，返回响应的状态码
+func runRequestPhases(t *testing.T, key string, ctx *plugins.Context) int {
+	chain, err := plugins.NewChain(key)
+	assert.NoError(t, err)
+	action, err := chain.Run(plugins.PhaseRewrite, ctx)
+	if err == nil && action == plugins.ActionContinue {
+		chain = chain.MergeConsumer(ctx)
+		action, err = chain.Run(plugins.PhaseAccess, ctx)
+	}
+	if err != nil {
+		return plugins.AsPluginError(err).StatusCode
+	}
+	return ctx.Response.StatusCode()
+}
@@ -4,40 +4,60 @@
 package plugins
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/chain5j/logger"
-	"github.com/valyala/fasthttp"
 	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
 )
 
 var (
-	_ plugins.Plugin = new(Syslog)
+	_ plugins.PluginV2  = new(Syslog)
+	_ plugins.LogPlugin = new(Syslog)
+)
+
+const (
+	// syslogPriority facility为local0，severity为info
+	syslogPriority = 16*8 + 6
 )
 
 func init() {
-	err := plugins.RegisterPlugin(&Syslog{
+	err := plugins.RegisterPluginV2(&Syslog{
+		log:      logger.Log("syslog"),
 		name:     "syslog",
 		version:  "0.1",
-		priority: 10000,
+		priority: 401,
 	})
 	if err != nil {
 		logger.Fatal("failed to register plugin Syslog", "err", err)
 	}
 }
 
+// Syslog 将访问日志批量发送到syslog服务
 type Syslog struct {
-	plugins.DefaultPlugin
+	log        logger.Logger
+	processors batchProcessors
+
+	plugins.DefaultPluginV2
 	name     string
 	version  string
 	priority int64
 }
 
 type SyslogConf struct {
-	Disable      bool   `json:"disable"`
-	Host         string `tfsdk:"host"`
-	Port         int    `tfsdk:"port"`
-	BatchMaxSize uint64 `tfsdk:"batch_max_size"`
+	Disable        bool   `json:"disable"`
+	Host           string `json:"host"`                    // syslog服务的地址
+	Port           int    `json:"port"`                    // syslog服务的端口
+	LoggerName     string `json:"name" default:"apisix"`   // syslog中的APP-NAME
+	Timeout        int    `json:"timeout" default:"3"`     // 连接和发送的超时秒数
+	SockType       string `json:"sock_type" default:"tcp"` // ["tcp", "udp"]
+	IncludeReqBody bool   `json:"include_req_body"`        // 日志中是否包含请求体
+	batchConf
 }
 
 func (p *Syslog) Name() string {
@@ -55,15 +75,86 @@ func (p *Syslog) Priority() int64 {
 func (p *Syslog) ParseConf(in []byte) (interface{}, error) {
 	conf := SyslogConf{}
 	err := json.Unmarshal(in, &conf)
-	return conf, err
+	if err != nil {
+		return nil, err
+	}
+	if len(conf.Host) == 0 || conf.Port <= 0 {
+		return nil, fmt.Errorf("host and port are required")
+	}
+	if len(conf.LoggerName) == 0 {
+		conf.LoggerName = "apisix"
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 3
+	}
+	switch conf.SockType {
+	case "":
+		conf.SockType = "tcp"
+	case "tcp", "udp":
+	default:
+		return nil, fmt.Errorf("unsupported sock_type: %s", conf.SockType)
+	}
+	conf.batchConf.setDefaults()
+	return conf, nil
+}
+
+// ReleaseConf 路由或consumer的配置被删除或替换时，发送剩余的日志并释放对应的batchProcessor
+func (p *Syslog) ReleaseConf(released string) {
+	p.processors.release(released)
 }
 
-func (p *Syslog) RequestFilter(conf interface{}, r *fasthttp.Request, w *fasthttp.Response) error {
+// Log 将日志加入批量发送的队列
+func (p *Syslog) Log(ctx *plugins.Context, conf interface{}) {
 	config, ok := conf.(SyslogConf)
 	if !ok {
-		return ErrConfConvert
+		p.log.Warn(ErrConfConvert.Error())
+		return
 	}
-	_ = config
+	if config.Disable {
+		return
+	}
+	entry := plugins.NewLogEntry(ctx, config.IncludeReqBody)
+	p.processors.get(ctx.ConfKey(), config.batchConf, func() func([]interface{}) error {
+		return func(entries []interface{}) error {
+			return p.send(config, entries)
+		}
+	}).Push(entry)
+}
+
+func (p *Syslog) send(config SyslogConf, entries []interface{}) error {
+	timeout := time.Duration(config.Timeout) * time.Second
+	conn, err := net.DialTimeout(config.SockType, net.JoinHostPort(config.Host, strconv.Itoa(config.Port)), timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
 
+	hostname, _ := os.Hostname()
+	for _, entry := range entries {
+		msg, err := syslogMessage(hostname, config.LoggerName, entry)
+		if err != nil {
+			return err
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err = conn.Write(msg); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// syslogMessage 生成RFC5424格式的一行日志，MSG为JSON格式的访问日志
+func syslogMessage(hostname, appName string, entry interface{}) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	if len(hostname) == 0 {
+		hostname = "-"
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %d - - ", syslogPriority, time.Now().Format(time.RFC3339), hostname, appName, os.Getpid())
+	buf.Write(data)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
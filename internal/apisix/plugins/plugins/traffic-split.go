@@ -0,0 +1,171 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/chain5j/chain5j-pkg/util/convutil"
+	"github.com/chain5j/logger"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/lb"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+	"github.com/xwc1125/apisix-go/internal/apisix/utils/expr"
+)
+
+var (
+	_ plugins.PluginV2 = new(TrafficSplit)
+)
+
+func init() {
+	err := plugins.RegisterPluginV2(&TrafficSplit{
+		log:      logger.Log("traffic-split"),
+		name:     "traffic-split",
+		version:  "0.1",
+		priority: 966,
+	})
+	if err != nil {
+		logger.Fatal("failed to register plugin TrafficSplit", "err", err)
+	}
+}
+
+// TrafficSplit 按规则将请求转发给其他的上游，用于灰度发布和蓝绿发布。
+// 请求匹配第一个满足match的规则后，按权重在weighted_upstreams中选择上游，
+// 未配置upstream和upstream_id的weighted_upstream表示使用路由的上游
+type TrafficSplit struct {
+	log logger.Logger
+
+	plugins.DefaultPluginV2
+	name     string
+	version  string
+	priority int64
+}
+
+type TrafficSplitConf struct {
+	Disable bool                `json:"disable"`
+	Rules   []*TrafficSplitRule `json:"rules"`
+}
+
+type TrafficSplitRule struct {
+	// Match 匹配条件，满足其中任意一个即可，为空时匹配所有请求
+	Match []TrafficSplitMatch `json:"match,omitempty"`
+	// WeightedUpstreams 按权重选择的上游
+	WeightedUpstreams []*WeightedUpstream `json:"weighted_upstreams"`
+
+	balancer lb.LoadBalance // 按权重轮询weighted_upstreams，各请求复用
+}
+
+type TrafficSplitMatch struct {
+	// Vars 与路由的vars相同的表达式，如：[["arg_name", "==", "jack"], ["http_user-id", ">", 23]]
+	Vars []interface{} `json:"vars,omitempty"`
+
+	expr *expr.Expr
+}
+
+type WeightedUpstream struct {
+	UpstreamID interface{}         `json:"upstream_id,omitempty"`
+	Upstream   *entity.UpstreamDef `json:"upstream,omitempty"`
+	Weight     *int                `json:"weight,omitempty"` // 默认为1
+
+	upstream *plugins.Upstream // 为nil时使用路由的上游
+}
+
+func (p *TrafficSplit) Name() string {
+	return p.name
+}
+
+func (p *TrafficSplit) Version() string {
+	return p.version
+}
+
+func (p *TrafficSplit) Priority() int64 {
+	return p.priority
+}
+
+// ParseConf 解析配置，同时编译匹配条件并创建选择上游的负载均衡
+func (p *TrafficSplit) ParseConf(in []byte) (interface{}, error) {
+	conf := TrafficSplitConf{}
+	if err := json.Unmarshal(in, &conf); err != nil {
+		return nil, err
+	}
+	for i, rule := range conf.Rules {
+		if rule == nil || len(rule.WeightedUpstreams) == 0 {
+			return nil, fmt.Errorf("rules[%d]: weighted_upstreams is required", i)
+		}
+		for j := range rule.Match {
+			e, err := expr.Compile(rule.Match[j].Vars)
+			if err != nil {
+				return nil, fmt.Errorf("rules[%d].match[%d]: %w", i, j, err)
+			}
+			rule.Match[j].expr = e
+		}
+		ws := make([]lb.W, len(rule.WeightedUpstreams))
+		for j, wu := range rule.WeightedUpstreams {
+			if wu == nil {
+				return nil, fmt.Errorf("rules[%d].weighted_upstreams[%d] is empty", i, j)
+			}
+			weight := 1
+			if wu.Weight != nil {
+				weight = *wu.Weight
+			}
+			if weight < 0 {
+				return nil, fmt.Errorf("rules[%d].weighted_upstreams[%d]: weight must be >= 0", i, j)
+			}
+			ws[j] = lb.Weight(weight)
+			key := fmt.Sprintf("traffic-split/%d/%d", i, j)
+			switch {
+			case wu.Upstream != nil:
+				if len(wu.Upstream.GetNodes()) == 0 {
+					return nil, fmt.Errorf("rules[%d].weighted_upstreams[%d]: upstream nodes is empty", i, j)
+				}
+				wu.upstream = &plugins.Upstream{Key: key, Upstream: wu.Upstream}
+			case wu.UpstreamID != nil:
+				wu.upstream = &plugins.Upstream{ID: convutil.ToString(wu.UpstreamID)}
+			}
+		}
+		rule.balancer = lb.NewBalancer(&entity.UpstreamDef{Type: lb.RoundRobin}, ws)
+	}
+	return conf, nil
+}
+
+// RequestFilter 选择匹配的规则，并按权重指定转发的上游
+func (p *TrafficSplit) RequestFilter(ctx *plugins.Context, conf interface{}) (plugins.Action, error) {
+	config, ok := conf.(TrafficSplitConf)
+	if !ok {
+		return plugins.ActionContinue, ErrConfConvert
+	}
+	if config.Disable {
+		return plugins.ActionContinue, nil
+	}
+	for _, rule := range config.Rules {
+		if !rule.match(ctx) {
+			continue
+		}
+		idx := rule.balancer.Distribute(ctx.Request, ctx)
+		rule.balancer.Done(idx, 0, nil)
+		wu := rule.WeightedUpstreams[idx]
+		p.log.Debug("traffic split", "route", ctx.Var("route_id"), "upstream", wu.upstream)
+		ctx.SetUpstream(wu.upstream)
+		return plugins.ActionContinue, nil
+	}
+	return plugins.ActionContinue, nil
+}
+
+func (p *TrafficSplit) ResponseFilter(ctx *plugins.Context, conf interface{}) (plugins.Action, error) {
+	return plugins.ActionContinue, nil
+}
+
+// match 满足任意一个match，或者未配置match
+func (r *TrafficSplitRule) match(ctx *plugins.Context) bool {
+	if len(r.Match) == 0 {
+		return true
+	}
+	for _, m := range r.Match {
+		if m.expr.Eval(ctx) {
+			return true
+		}
+	}
+	return false
+}
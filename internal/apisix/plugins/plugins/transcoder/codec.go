@@ -0,0 +1,270 @@
+// Package transcoder
+//
+// @author: xwc1125
+package transcoder
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/bufbuild/protocompile/linker"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Call 一个gRPC方法的转码
+type Call struct {
+	method   protoreflect.MethodDescriptor
+	resolver *typeResolver
+}
+
+// Path gRPC请求的路径，如：/helloworld.Greeter/SayHello
+func (c *Call) Path() string {
+	return "/" + string(c.method.Parent().FullName()) + "/" + string(c.method.Name())
+}
+
+// EncodeRequest 将JSON请求体和查询参数转换为gRPC的请求消息，查询参数覆盖请求体中的同名字段。
+// 返回的消息已经加上了gRPC的5字节消息头
+func (c *Call) EncodeRequest(body []byte, args url.Values) ([]byte, error) {
+	msg := dynamicpb.NewMessage(c.method.Input())
+	if len(body) > 0 {
+		opt := protojson.UnmarshalOptions{DiscardUnknown: true, Resolver: c.resolver}
+		if err := opt.Unmarshal(body, msg); err != nil {
+			return nil, fmt.Errorf("decode request body: %w", err)
+		}
+	}
+	for key, values := range args {
+		if err := setField(msg, key, values); err != nil {
+			return nil, err
+		}
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	copy(frame[5:], data)
+	return frame, nil
+}
+
+// DecodeResponse 将gRPC的响应消息转换为JSON
+func (c *Call) DecodeResponse(body []byte, pbOptions []string) ([]byte, error) {
+	msg := dynamicpb.NewMessage(c.method.Output())
+	if len(body) > 0 {
+		if len(body) < 5 {
+			return nil, errors.New("grpc message is truncated")
+		}
+		if body[0] != 0 {
+			return nil, errors.New("compressed grpc message is not supported")
+		}
+		size := binary.BigEndian.Uint32(body[1:5])
+		if uint32(len(body)-5) < size {
+			return nil, errors.New("grpc message is truncated")
+		}
+		if err := proto.Unmarshal(body[5:5+size], msg); err != nil {
+			return nil, fmt.Errorf("decode response: %w", err)
+		}
+	}
+	return c.marshalOptions(pbOptions).Marshal(msg)
+}
+
+// DecodeStatus 将grpc-status、grpc-message和grpc-status-details-bin转换为google.rpc.Status的JSON，
+// details中的类型在proto和已注册的类型中查找，找不到时返回base64编码的内容
+func (c *Call) DecodeStatus(code int, message, details string, pbOptions []string) ([]byte, error) {
+	st := &spb.Status{Code: int32(code), Message: message}
+	if len(details) > 0 {
+		data, err := decodeBinHeader(details)
+		if err != nil {
+			return nil, fmt.Errorf("decode grpc-status-details-bin: %w", err)
+		}
+		if err = proto.Unmarshal(data, st); err != nil {
+			return nil, fmt.Errorf("decode grpc-status-details-bin: %w", err)
+		}
+	}
+	if data, err := c.marshalOptions(pbOptions).Marshal(st); err == nil {
+		return data, nil
+	}
+	type detail struct {
+		Type  string `json:"@type"`
+		Value string `json:"value"`
+	}
+	list := make([]detail, len(st.Details))
+	for i, d := range st.Details {
+		list[i] = detail{Type: d.TypeUrl, Value: base64.StdEncoding.EncodeToString(d.Value)}
+	}
+	return json.Marshal(map[string]interface{}{
+		"code":    st.Code,
+		"message": st.Message,
+		"details": list,
+	})
+}
+
+// marshalOptions 根据APISIX的pb_option生成JSON的编码选项，与APISIX一致使用proto中的字段名。
+// 支持enum_as_name、enum_as_value、auto_default_values、use_default_values和no_default_values，
+// int64按照protojson的规范编码为字符串
+func (c *Call) marshalOptions(pbOptions []string) protojson.MarshalOptions {
+	opt := protojson.MarshalOptions{
+		UseProtoNames:   true,
+		EmitUnpopulated: c.method.ParentFile().Syntax() == protoreflect.Proto3,
+		Resolver:        c.resolver,
+	}
+	for _, o := range pbOptions {
+		switch o {
+		case "enum_as_name":
+			opt.UseEnumNumbers = false
+		case "enum_as_value":
+			opt.UseEnumNumbers = true
+		case "auto_default_values":
+			opt.EmitUnpopulated = c.method.ParentFile().Syntax() == protoreflect.Proto3
+		case "use_default_values":
+			opt.EmitUnpopulated = true
+		case "no_default_values":
+			opt.EmitUnpopulated = false
+		}
+	}
+	return opt
+}
+
+// setField 使用查询参数设置消息中的字段，只支持标量、枚举及其repeated字段
+func setField(msg *dynamicpb.Message, key string, values []string) error {
+	fields := msg.Descriptor().Fields()
+	fd := fields.ByJSONName(key)
+	if fd == nil {
+		fd = fields.ByName(protoreflect.Name(key))
+	}
+	if fd == nil {
+		// 与请求体一致，忽略未定义的字段
+		return nil
+	}
+	if fd.IsMap() || fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+		return fmt.Errorf("query arg %s: unsupported field type %s", key, fd.Kind())
+	}
+	if fd.IsList() {
+		list := msg.NewField(fd).List()
+		for _, value := range values {
+			v, err := parseValue(fd, value)
+			if err != nil {
+				return fmt.Errorf("query arg %s: %w", key, err)
+			}
+			list.Append(v)
+		}
+		msg.Set(fd, protoreflect.ValueOfList(list))
+		return nil
+	}
+	v, err := parseValue(fd, values[len(values)-1])
+	if err != nil {
+		return fmt.Errorf("query arg %s: %w", key, err)
+	}
+	msg.Set(fd, v)
+	return nil
+}
+
+func parseValue(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(s)), nil
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), err
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field type %s", fd.Kind())
+}
+
+// HTTPStatus gRPC状态码对应的HTTP状态码，与grpc-gateway一致
+func HTTPStatus(code int) int {
+	switch code {
+	case 0:
+		return http.StatusOK
+	case 1:
+		return 499
+	case 3, 9, 11:
+		return http.StatusBadRequest
+	case 4:
+		return http.StatusGatewayTimeout
+	case 5:
+		return http.StatusNotFound
+	case 6, 10:
+		return http.StatusConflict
+	case 7:
+		return http.StatusForbidden
+	case 8:
+		return http.StatusTooManyRequests
+	case 12:
+		return http.StatusNotImplemented
+	case 14:
+		return http.StatusServiceUnavailable
+	case 16:
+		return http.StatusUnauthorized
+	}
+	return http.StatusInternalServerError
+}
+
+// typeResolver 先在proto中查找类型，找不到时使用已注册的类型，用于解析Any
+type typeResolver struct {
+	files linker.Resolver
+}
+
+func (r *typeResolver) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	if mt, err := r.files.FindMessageByName(name); err == nil {
+		return mt, nil
+	}
+	return protoregistry.GlobalTypes.FindMessageByName(name)
+}
+
+func (r *typeResolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	if mt, err := r.files.FindMessageByURL(url); err == nil {
+		return mt, nil
+	}
+	return protoregistry.GlobalTypes.FindMessageByURL(url)
+}
+
+func (r *typeResolver) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	if et, err := r.files.FindExtensionByName(field); err == nil {
+		return et, nil
+	}
+	return protoregistry.GlobalTypes.FindExtensionByName(field)
+}
+
+func (r *typeResolver) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	if et, err := r.files.FindExtensionByNumber(message, field); err == nil {
+		return et, nil
+	}
+	return protoregistry.GlobalTypes.FindExtensionByNumber(message, field)
+}
@@ -1,14 +1,99 @@
+// Package transcoder HTTP与gRPC之间的转码
+//
+// @author: xwc1125
 package transcoder
 
 import (
-	"bytes"
+	"context"
 	"encoding/base64"
-	"io/ioutil"
-	"net/http"
+	"fmt"
+	"sync"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/bufbuild/protocompile/linker"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var (
+	cache sync.Map // proto id -> *descriptors
 )
 
-// 转码
+func init() {
+	plugins.OnProtoChange(Invalidate)
+}
+
+// descriptors 解析后的proto
+type descriptors struct {
+	proto *entity.Proto // 解析时使用的proto，store中的proto被替换后需要重新解析
+	files linker.Files
+}
+
+// Invalidate 清除proto解析后的缓存
+func Invalidate(id string) {
+	cache.Delete(id)
+}
+
+// Method 在proto store中查找id对应的proto，返回其中service的method。
+// proto解析后缓存，proto变化时重新解析
+func Method(protoID, service, method string) (*Call, error) {
+	files, err := load(protoID)
+	if err != nil {
+		return nil, err
+	}
+	resolver := files.AsResolver()
+	d, err := resolver.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service %s not found in proto %s", service, protoID)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("method %s not found in service %s", method, service)
+	}
+	return &Call{
+		method:   md,
+		resolver: &typeResolver{files: resolver},
+	}, nil
+}
+
+// load 获取解析后的proto
+func load(id string) (linker.Files, error) {
+	proto, ok := plugins.FindProto(id)
+	if !ok {
+		return nil, fmt.Errorf("proto %s not found", id)
+	}
+	if v, ok := cache.Load(id); ok && v.(*descriptors).proto == proto {
+		return v.(*descriptors).files, nil
+	}
+	files, err := compile(id, proto.Content)
+	if err != nil {
+		return nil, err
+	}
+	cache.Store(id, &descriptors{proto: proto, files: files})
+	return files, nil
+}
 
+// compile 解析proto的内容，可以import google/protobuf下的标准proto
+func compile(id, content string) (linker.Files, error) {
+	name := id + ".proto"
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{name: content}),
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), name)
+	if err != nil {
+		return nil, fmt.Errorf("compile proto %s: %w", id, err)
+	}
+	return files, nil
+}
+
+// decodeBinHeader 解码-bin结尾的header，如：grpc-status-details-bin
 func decodeBinHeader(v string) ([]byte, error) {
 	if len(v)%4 == 0 {
 		// Input was padded, or padding was not necessary.
@@ -16,88 +101,3 @@ func decodeBinHeader(v string) ([]byte, error) {
 	}
 	return base64.RawStdEncoding.DecodeString(v)
 }
-
-func newResponse(statusCode int, header http.Header, data []byte) (*http.Response, error) {
-	return &http.Response{
-		Header:        header,
-		StatusCode:    statusCode,
-		ContentLength: int64(len(data)),
-		Body:          ioutil.NopCloser(bytes.NewReader(data)),
-	}, nil
-}
-
-// func init() {
-// 	middleware.Register("transcoder", Middleware)
-// }
-//
-// // Middleware is a gRPC transcoder.
-// func Middleware(c *config.Middleware) (middleware.Middleware, error) {
-// 	return func(next http.RoundTripper) http.RoundTripper {
-// 		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
-// 			ctx := req.Context()
-// 			contentType := req.Header.Get("Content-Type")
-// 			endpoint, _ := middleware.EndpointFromContext(ctx)
-// 			if endpoint.Protocol != config.Protocol_GRPC || strings.HasPrefix(contentType, "application/grpc") {
-// 				return next.RoundTrip(req)
-// 			}
-// 			b, err := io.ReadAll(req.Body)
-// 			if err != nil {
-// 				return nil, err
-// 			}
-// 			bb := make([]byte, len(b)+5)
-// 			binary.BigEndian.PutUint32(bb[1:], uint32(len(b)))
-// 			copy(bb[5:], b)
-// 			// content-type:
-// 			// - application/grpc+json
-// 			// - application/grpc+proto
-// 			req.Header.Set("Content-Type", "application/grpc+"+strings.TrimLeft(contentType, "application/"))
-// 			req.Header.Del("Content-Length")
-// 			req.ContentLength = int64(len(bb))
-// 			req.Body = ioutil.NopCloser(bytes.NewReader(bb))
-// 			resp, err := next.RoundTrip(req)
-// 			if err != nil {
-// 				return nil, err
-// 			}
-// 			data, err := io.ReadAll(resp.Body)
-// 			if err != nil {
-// 				return nil, err
-// 			}
-// 			// Convert HTTP/2 response to HTTP/1.1
-// 			// Trailers are sent in a data frame, so don't announce trailers as otherwise downstream proxies might get confused.
-// 			for trailerName, values := range resp.Trailer {
-// 				resp.Header[trailerName] = values
-// 			}
-// 			resp.Trailer = nil
-// 			resp.Header.Set("Content-Type", contentType)
-// 			if grpcStatus := resp.Header.Get("grpc-status"); grpcStatus != "0" {
-// 				code, err := strconv.ParseInt(grpcStatus, 10, 64)
-// 				if err != nil {
-// 					return nil, err
-// 				}
-// 				st := &spb.Status{
-// 					Code:    int32(code),
-// 					Message: resp.Header.Get("grpc-message"),
-// 				}
-// 				if grpcDetails := resp.Header.Get("grpc-status-details-bin"); grpcDetails != "" {
-// 					details, err := decodeBinHeader(grpcDetails)
-// 					if err != nil {
-// 						return nil, err
-// 					}
-// 					if err = proto.Unmarshal(details, st); err != nil {
-// 						return nil, err
-// 					}
-// 				}
-// 				data, err := protojson.Marshal(st)
-// 				if err != nil {
-// 					return nil, err
-// 				}
-// 				return newResponse(200, resp.Header, data)
-// 			}
-// 			resp.Body = ioutil.NopCloser(bytes.NewReader(data[5:]))
-// 			resp.ContentLength = int64(len(data) - 5)
-// 			// Any content length that might be set is no longer accurate because of trailers.
-// 			resp.Header.Del("Content-Length")
-// 			return resp, nil
-// 		})
-// 	}, nil
-// }
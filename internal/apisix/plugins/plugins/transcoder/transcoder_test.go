@@ -0,0 +1,136 @@
+// Package transcoder
+//
+// @author: xwc1125
+package transcoder
+
+import (
+	"encoding/base64"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const testProto = `syntax = "proto3";
+package helloworld;
+
+service Greeter {
+  rpc SayHello (HelloRequest) returns (HelloReply) {}
+}
+
+enum Kind {
+  KIND_UNKNOWN = 0;
+  KIND_A = 1;
+}
+
+message HelloRequest {
+  string name = 1;
+  int32 times = 2;
+  repeated string items = 3;
+  Kind kind = 4;
+}
+
+message HelloReply {
+  string message = 1;
+  int32 count = 2;
+  Kind kind = 3;
+}
+
+message ErrorDetail {
+  string reason = 1;
+}
+`
+
+func TestMethodCache(t *testing.T) {
+	_, err := Method("cache", "helloworld.Greeter", "SayHello")
+	assert.Error(t, err)
+
+	plugins.StoreProto("cache", &entity.Proto{Content: testProto})
+	call, err := Method("cache", "helloworld.Greeter", "SayHello")
+	assert.NoError(t, err)
+	assert.Equal(t, "/helloworld.Greeter/SayHello", call.Path())
+	_, err = Method("cache", "helloworld.Greeter", "SayBye")
+	assert.Error(t, err)
+	_, ok := cache.Load("cache")
+	assert.True(t, ok)
+
+	// proto变化时清除缓存，重新解析
+	plugins.StoreProto("cache", &entity.Proto{Content: `syntax = "proto3";
+package helloworld;
+service Greeter {
+  rpc SayBye (Empty) returns (Empty) {}
+}
+message Empty {}
+`})
+	_, ok = cache.Load("cache")
+	assert.False(t, ok)
+	_, err = Method("cache", "helloworld.Greeter", "SayBye")
+	assert.NoError(t, err)
+
+	plugins.DeleteProto("cache")
+	_, err = Method("cache", "helloworld.Greeter", "SayBye")
+	assert.Error(t, err)
+}
+
+func TestCallCodec(t *testing.T) {
+	plugins.StoreProto("codec", &entity.Proto{Content: testProto})
+	call, err := Method("codec", "helloworld.Greeter", "SayHello")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// 查询参数覆盖请求体中的字段
+	frame, err := call.EncodeRequest([]byte(`{"name":"body","times":1,"unknown":true}`),
+		url.Values{"times": {"3"}, "items": {"a", "b"}, "kind": {"KIND_A"}})
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0), frame[0])
+	req := dynamicpb.NewMessage(call.method.Input())
+	assert.NoError(t, proto.Unmarshal(frame[5:], req))
+	fields := req.Descriptor().Fields()
+	assert.Equal(t, "body", req.Get(fields.ByName("name")).String())
+	assert.Equal(t, int64(3), req.Get(fields.ByName("times")).Int())
+	assert.Equal(t, 2, req.Get(fields.ByName("items")).List().Len())
+	assert.Equal(t, protoreflect.EnumNumber(1), req.Get(fields.ByName("kind")).Enum())
+
+	_, err = call.EncodeRequest(nil, url.Values{"times": {"x"}})
+	assert.Error(t, err)
+
+	reply := dynamicpb.NewMessage(call.method.Output())
+	reply.Set(reply.Descriptor().Fields().ByName("message"), protoreflect.ValueOfString("hello"))
+	data, _ := proto.Marshal(reply)
+	body := append([]byte{0, 0, 0, 0, byte(len(data))}, data...)
+	out, err := call.DecodeResponse(body, nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"message":"hello","count":0,"kind":"KIND_UNKNOWN"}`, string(out))
+	out, err = call.DecodeResponse(body, []string{"enum_as_value", "no_default_values"})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"message":"hello"}`, string(out))
+	_, err = call.DecodeResponse(body[:4], nil)
+	assert.Error(t, err)
+
+	// details中的类型在proto中查找
+	files, _ := load("codec")
+	d, _ := files.AsResolver().FindDescriptorByName("helloworld.ErrorDetail")
+	detail := dynamicpb.NewMessage(d.(protoreflect.MessageDescriptor))
+	detail.Set(detail.Descriptor().Fields().ByName("reason"), protoreflect.ValueOfString("empty name"))
+	anyDetail, err := anypb.New(detail)
+	assert.NoError(t, err)
+	st, _ := proto.Marshal(&spb.Status{Code: 3, Message: "invalid", Details: []*anypb.Any{anyDetail}})
+	out, err = call.DecodeStatus(3, "invalid", base64.RawStdEncoding.EncodeToString(st), nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"code":3,"message":"invalid","details":[{"@type":"type.googleapis.com/helloworld.ErrorDetail","reason":"empty name"}]}`, string(out))
+	out, err = call.DecodeStatus(5, "not found", "", nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"code":5,"message":"not found","details":[]}`, string(out))
+
+	assert.Equal(t, 400, HTTPStatus(3))
+	assert.Equal(t, 401, HTTPStatus(16))
+	assert.Equal(t, 500, HTTPStatus(2))
+}
@@ -0,0 +1,52 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"sync"
+
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+var (
+	protos        sync.Map // proto id -> *entity.Proto
+	protoWatchMu  sync.RWMutex
+	protoWatchers []func(id string)
+)
+
+// StoreProto 保存proto，并通知插件清除该proto的缓存
+func StoreProto(id string, proto *entity.Proto) {
+	protos.Store(id, proto)
+	notifyProto(id)
+}
+
+// DeleteProto 删除proto，并通知插件清除该proto的缓存
+func DeleteProto(id string) {
+	protos.Delete(id)
+	notifyProto(id)
+}
+
+// FindProto 查找proto
+func FindProto(id string) (*entity.Proto, bool) {
+	v, ok := protos.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return v.(*entity.Proto), true
+}
+
+// OnProtoChange 注册proto变化时的回调，插件用于清除解析proto的缓存
+func OnProtoChange(fn func(id string)) {
+	protoWatchMu.Lock()
+	defer protoWatchMu.Unlock()
+	protoWatchers = append(protoWatchers, fn)
+}
+
+func notifyProto(id string) {
+	protoWatchMu.RLock()
+	defer protoWatchMu.RUnlock()
+	for _, fn := range protoWatchers {
+		fn(id)
+	}
+}
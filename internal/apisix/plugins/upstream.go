@@ -0,0 +1,25 @@
+// Package plugins
+//
+// @author: xwc1125
+package plugins
+
+import (
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+// Upstream 插件为请求指定的上游，如traffic-split按规则选择的上游
+type Upstream struct {
+	Key      string              // 上游的标识，相同Key且上游未变化时复用转发使用的Proxy
+	ID       string              // upstream_id，Upstream为nil时使用
+	Upstream *entity.UpstreamDef // 插件配置中的上游
+}
+
+// SetUpstream 使用指定的上游转发请求，代替路由的上游。nil表示使用路由的上游
+func (c *Context) SetUpstream(upstream *Upstream) {
+	c.upstream = upstream
+}
+
+// Upstream 插件指定的上游，未指定时为nil
+func (c *Context) Upstream() *Upstream {
+	return c.upstream
+}
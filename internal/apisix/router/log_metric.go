@@ -0,0 +1,10 @@
+// Package router
+//
+// @author: xwc1125
+package router
+
+import "github.com/chain5j/logger"
+
+func log() logger.Logger {
+	return logger.Log("router")
+}
@@ -0,0 +1,392 @@
+// Package router
+//
+// @author: xwc1125
+package router
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chain5j/chain5j-pkg/util/convutil"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+	"github.com/xwc1125/apisix-go/internal/apisix/utils/expr"
+	"github.com/xwc1125/apisix-go/internal/apisix/utils/iputils"
+	"github.com/xwc1125/apisix-go/internal/apisix/utils/reg_uri"
+)
+
+type ctxKey string
+
+const (
+	paramsKey ctxKey = "router-path-params"
+)
+
+// Match 路由匹配的结果
+type Match struct {
+	Route  *entity.Route
+	Params map[string]string // uri中提取的路径参数
+}
+
+// compiledRoute 编译后的路由
+type compiledRoute struct {
+	id    string
+	route *entity.Route
+	vars  *expr.Expr // 编译后的vars
+
+	hostRank hostRank // 域名的精确程度，用于排序
+	hostLen  int      // 泛域名的长度，越长越精确
+}
+
+// hostRank 路由域名的精确程度
+type hostRank uint8
+
+const (
+	hostAny   hostRank = iota // 未限制域名
+	hostWild                  // 泛域名，如：*.example.com
+	hostExact                 // 精确域名
+)
+
+// table 由全部路由编译而成的只读索引，重建后整体替换
+type table struct {
+	hosts     map[string]*node // 精确域名
+	wildHosts []*hostTree      // 泛域名，如：*.example.com
+	any       *node            // 未限制域名的路由
+	fallback  []*entry         // 无法放入前缀树的uri模式
+}
+
+type hostTree struct {
+	host string
+	root *node
+}
+
+// Router 路由器，将所有的entity.Route编译为按host和uri索引的前缀树
+type Router struct {
+	mu     sync.Mutex
+	routes map[string]*entity.Route
+
+	table atomic.Value // *table
+}
+
+// NewRouter 创建路由器
+func NewRouter() *Router {
+	r := &Router{
+		routes: make(map[string]*entity.Route),
+	}
+	r.table.Store(compile(nil))
+	return r
+}
+
+// Load 使用routes全量替换当前的路由
+func (r *Router) Load(routes map[string]*entity.Route) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = make(map[string]*entity.Route, len(routes))
+	for key, route := range routes {
+		r.routes[key] = route
+	}
+	r.rebuild()
+}
+
+// Put 新增或更新路由
+func (r *Router) Put(key string, route *entity.Route) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes[key] = route
+	r.rebuild()
+}
+
+// Delete 删除路由
+func (r *Router) Delete(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.routes[key]; !ok {
+		return
+	}
+	delete(r.routes, key)
+	r.rebuild()
+}
+
+func (r *Router) rebuild() {
+	routes := make([]*entity.Route, 0, len(r.routes))
+	for _, route := range r.routes {
+		routes = append(routes, route)
+	}
+	r.table.Store(compile(routes))
+	log().Debug("router rebuild", "routes", len(routes))
+}
+
+// Match 匹配请求对应的路由，并将路径参数保存到ctx中
+func (r *Router) Match(ctx *fasthttp.RequestCtx) (*Match, bool) {
+	t := r.table.Load().(*table)
+	path := string(ctx.Path())
+	host := hostname(string(ctx.Host()))
+
+	var candidates []candidate
+	if root, ok := t.hosts[host]; ok {
+		root.lookup(path, &candidates)
+	}
+	for _, wh := range t.wildHosts {
+		if reg_uri.DomainMatch(host, wh.host) {
+			wh.root.lookup(path, &candidates)
+		}
+	}
+	t.any.lookup(path, &candidates)
+	for _, e := range t.fallback {
+		e.match(path, &candidates)
+	}
+	if len(candidates) == 0 {
+		return nil, false
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return less(candidates[i].entry, candidates[j].entry)
+	})
+	for _, c := range candidates {
+		if !c.entry.route.verify(ctx, host) {
+			continue
+		}
+		if len(c.params) > 0 {
+			ctx.SetUserValue(paramsKey, c.params)
+		}
+		return &Match{
+			Route:  c.entry.route.route,
+			Params: c.params,
+		}, true
+	}
+	return nil, false
+}
+
+// PathParams 获取路由匹配时提取的路径参数
+func PathParams(ctx *fasthttp.RequestCtx) map[string]string {
+	params, _ := ctx.UserValue(paramsKey).(map[string]string)
+	return params
+}
+
+// less 候选路由的排序，满足严格弱序，依次比较：
+//  1. uri的类型：精确匹配 > 参数匹配 > 通配匹配
+//  2. 路由的priority，越大越优先
+//  3. 域名的精确程度：精确域名 > 泛域名(越长越优先) > 未限制域名
+//  4. 路由的ID，保证结果稳定
+func less(a, b *entry) bool {
+	if a.kind != b.kind {
+		return a.kind < b.kind
+	}
+	ra, rb := a.route, b.route
+	if ra.route.Priority != rb.route.Priority {
+		return ra.route.Priority > rb.route.Priority
+	}
+	if ra.hostRank != rb.hostRank {
+		return ra.hostRank > rb.hostRank
+	}
+	if ra.hostLen != rb.hostLen {
+		return ra.hostLen > rb.hostLen
+	}
+	return ra.id < rb.id
+}
+
+func compile(routes []*entity.Route) *table {
+	t := &table{
+		hosts: make(map[string]*node),
+		any:   newNode(),
+	}
+	wildHosts := make(map[string]*node)
+	for _, route := range routes {
+		if route == nil || route.Status != 1 {
+			continue
+		}
+		cr := &compiledRoute{
+			id:    convutil.ToString(route.ID),
+			route: route,
+		}
+		vars, err := expr.Compile(route.Vars)
+		if err != nil {
+			log().Warn("invalid route vars, skip", "id", cr.id, "vars", route.Vars, "err", err)
+			continue
+		}
+		cr.vars = vars
+		cr.hostRank, cr.hostLen = hostSpecificity(route)
+
+		roots := make([]*node, 0, 1)
+		for _, host := range routeHosts(route) {
+			host = strings.ToLower(host)
+			if strings.Contains(host, "*") {
+				root, ok := wildHosts[host]
+				if !ok {
+					root = newNode()
+					wildHosts[host] = root
+				}
+				roots = append(roots, root)
+				continue
+			}
+			root, ok := t.hosts[host]
+			if !ok {
+				root = newNode()
+				t.hosts[host] = root
+			}
+			roots = append(roots, root)
+		}
+		if len(roots) == 0 {
+			roots = append(roots, t.any)
+		}
+
+		for _, pattern := range routeUris(route) {
+			for _, root := range roots {
+				e := &entry{
+					route:   cr,
+					pattern: pattern,
+				}
+				if root.insert(e) {
+					continue
+				}
+				e.names = nil
+				if err := compileKeyMatch4(e); err != nil {
+					log().Warn("invalid route uri, skip", "id", cr.id, "uri", pattern, "err", err)
+					break
+				}
+				// 正则模式不区分域名，域名在verify中校验
+				t.fallback = append(t.fallback, e)
+				break
+			}
+		}
+	}
+
+	for host, root := range wildHosts {
+		t.wildHosts = append(t.wildHosts, &hostTree{
+			host: host,
+			root: root,
+		})
+	}
+	// 后缀越长的泛域名越精确
+	sort.Slice(t.wildHosts, func(i, j int) bool {
+		if len(t.wildHosts[i].host) != len(t.wildHosts[j].host) {
+			return len(t.wildHosts[i].host) > len(t.wildHosts[j].host)
+		}
+		return t.wildHosts[i].host < t.wildHosts[j].host
+	})
+	return t
+}
+
+// routeHosts 用于建立索引的域名。
+// 同时配置host和hosts时两者都需要满足，按host建立索引即可
+func routeHosts(route *entity.Route) []string {
+	if len(route.Host) > 0 {
+		return []string{route.Host}
+	}
+	return route.Hosts
+}
+
+// hostSpecificity 路由域名的精确程度，配置了多个域名时取最精确的
+func hostSpecificity(route *entity.Route) (hostRank, int) {
+	rank, length := hostAny, 0
+	for _, host := range append([]string{route.Host}, route.Hosts...) {
+		switch {
+		case len(host) == 0:
+		case !strings.Contains(host, "*"):
+			return hostExact, 0
+		case rank == hostAny || len(host) > length:
+			rank, length = hostWild, len(host)
+		}
+	}
+	return rank, length
+}
+
+// routeUris 用于建立索引的uri。
+// 同时配置uri和uris时两者都需要满足，按uri建立索引即可；都未配置时匹配所有uri
+func routeUris(route *entity.Route) []string {
+	if len(route.URI) > 0 {
+		return []string{route.URI}
+	}
+	if len(route.Uris) > 0 {
+		return route.Uris
+	}
+	return []string{"/*"}
+}
+
+// verify 校验uri以外的匹配条件
+func (cr *compiledRoute) verify(ctx *fasthttp.RequestCtx, host string) bool {
+	route := cr.route
+	// uri
+	if len(route.URI) > 0 && len(route.Uris) > 0 {
+		path := string(ctx.Path())
+		match := false
+		for _, uri := range route.Uris {
+			if reg_uri.KeyMatch4(path, uri) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	// host
+	{
+		if len(route.Hosts) > 0 {
+			match := false
+			for _, h := range route.Hosts {
+				if reg_uri.DomainMatch(host, strings.ToLower(h)) {
+					match = true
+					break
+				}
+			}
+			if !match {
+				return false
+			}
+		}
+		if len(route.Host) > 0 && !reg_uri.DomainMatch(host, strings.ToLower(route.Host)) {
+			return false
+		}
+	}
+	// remoteAddr
+	{
+		remoteIp := ctx.RemoteIP().String()
+		if len(route.RemoteAddrs) > 0 {
+			match := false
+			for _, addr := range route.RemoteAddrs {
+				if iputils.Match(remoteIp, addr) {
+					match = true
+					break
+				}
+			}
+			if !match {
+				return false
+			}
+		}
+		if len(route.RemoteAddr) > 0 && !iputils.Match(remoteIp, route.RemoteAddr) {
+			return false
+		}
+	}
+	// method
+	{
+		reqMethod := string(ctx.Method())
+		if len(route.Methods) > 0 {
+			match := false
+			for _, method := range route.Methods {
+				if strings.EqualFold(reqMethod, method) || strings.EqualFold(method, "ALL") {
+					match = true
+					break
+				}
+			}
+			if !match {
+				return false
+			}
+		}
+	}
+	// vars
+	if len(route.Vars) > 0 && !cr.vars.Eval(plugins.NewContext(ctx, route, &ctx.Request, nil)) {
+		return false
+	}
+	return true
+}
+
+// hostname 去掉host中的端口
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
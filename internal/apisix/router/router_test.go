@@ -0,0 +1,259 @@
+// Package router
+//
+// @author: xwc1125
+package router
+
+import (
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+func newCtx(method, host, uri string) *fasthttp.RequestCtx {
+	req := fasthttp.AcquireRequest()
+	req.Header.SetMethod(method)
+	req.SetRequestURI(uri)
+	req.Header.SetHost(host)
+	ctx := new(fasthttp.RequestCtx)
+	ctx.Init(req, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}, nil)
+	return ctx
+}
+
+func newRoute(id string, fn func(r *entity.Route)) *entity.Route {
+	r := &entity.Route{
+		BaseInfo: entity.BaseInfo{ID: id},
+		Status:   1,
+	}
+	fn(r)
+	return r
+}
+
+func TestRouterMatch(t *testing.T) {
+	r := NewRouter()
+	r.Load(map[string]*entity.Route{
+		"exact": newRoute("exact", func(r *entity.Route) { r.URI = "/foo/bar" }),
+		"param": newRoute("param", func(r *entity.Route) { r.URI = "/users/{id}/books/{book}" }),
+		"repeat": newRoute("repeat", func(r *entity.Route) {
+			r.URI = "/parent/{id}/child/{id}"
+		}),
+		"mixed": newRoute("mixed", func(r *entity.Route) { r.URI = "/proj/bar_{project}_foo" }),
+		"wild":  newRoute("wild", func(r *entity.Route) { r.Uris = []string{"/anything/*", "/other/*"} }),
+		"host": newRoute("host", func(r *entity.Route) {
+			r.URI = "/host"
+			r.Host = "*.example.com"
+		}),
+		"method": newRoute("method", func(r *entity.Route) {
+			r.URI = "/method"
+			r.Methods = []string{"POST"}
+		}),
+		"disabled": newRoute("disabled", func(r *entity.Route) {
+			r.URI = "/disabled"
+			r.Status = 0
+		}),
+	})
+
+	tests := []struct {
+		method, host, uri string
+		wantID            string
+		wantParams        map[string]string
+	}{
+		{"GET", "localhost", "/foo/bar", "exact", nil},
+		{"GET", "localhost", "/foo/bar?a=1", "exact", nil},
+		{"GET", "localhost", "/foo/baz", "", nil},
+		{"GET", "localhost", "/users/1/books/2", "param", map[string]string{"id": "1", "book": "2"}},
+		{"GET", "localhost", "/parent/1/child/1", "repeat", map[string]string{"id": "1"}},
+		{"GET", "localhost", "/parent/1/child/2", "", nil},
+		{"GET", "localhost", "/proj/bar_p1_foo", "mixed", map[string]string{"project": "p1"}},
+		{"GET", "localhost", "/anything/a/b", "wild", map[string]string{WildcardParam: "a/b"}},
+		{"GET", "localhost", "/other/", "wild", map[string]string{WildcardParam: ""}},
+		{"GET", "localhost", "/anything", "", nil},
+		{"GET", "api.example.com:8080", "/host", "host", nil},
+		{"GET", "example.org", "/host", "", nil},
+		{"POST", "localhost", "/method", "method", nil},
+		{"GET", "localhost", "/method", "", nil},
+		{"GET", "localhost", "/disabled", "", nil},
+	}
+	for _, tt := range tests {
+		ctx := newCtx(tt.method, tt.host, tt.uri)
+		m, ok := r.Match(ctx)
+		if tt.wantID == "" {
+			assert.False(t, ok, tt.uri)
+			continue
+		}
+		if assert.True(t, ok, tt.uri) {
+			assert.Equal(t, tt.wantID, m.Route.ID, tt.uri)
+			assert.Equal(t, tt.wantParams, m.Params, tt.uri)
+			assert.Equal(t, tt.wantParams, PathParams(ctx), tt.uri)
+		}
+	}
+}
+
+func TestRouterFallback(t *testing.T) {
+	r := NewRouter()
+	r.Put("1", newRoute("1", func(r *entity.Route) { r.URI = "/foo/*/bar/{id}" }))
+
+	m, ok := r.Match(newCtx("GET", "localhost", "/foo/a/b/bar/1"))
+	if assert.True(t, ok) {
+		assert.Equal(t, map[string]string{"id": "1"}, m.Params)
+	}
+	_, ok = r.Match(newCtx("GET", "localhost", "/foo/bar/1"))
+	assert.False(t, ok)
+}
+
+func TestRouterPutDelete(t *testing.T) {
+	r := NewRouter()
+	r.Put("1", newRoute("1", func(r *entity.Route) { r.URI = "/a" }))
+	r.Put("2", newRoute("2", func(r *entity.Route) {
+		r.URI = "/a"
+		r.Priority = 10
+	}))
+
+	m, ok := r.Match(newCtx("GET", "localhost", "/a"))
+	if assert.True(t, ok) {
+		assert.Equal(t, "2", m.Route.ID)
+	}
+
+	r.Delete("2")
+	m, ok = r.Match(newCtx("GET", "localhost", "/a"))
+	if assert.True(t, ok) {
+		assert.Equal(t, "1", m.Route.ID)
+	}
+
+	r.Delete("1")
+	_, ok = r.Match(newCtx("GET", "localhost", "/a"))
+	assert.False(t, ok)
+}
+
+func TestRouterVars(t *testing.T) {
+	r := NewRouter()
+	r.Put("1", newRoute("1", func(r *entity.Route) {
+		r.URI = "/a"
+		r.Vars = []interface{}{[]interface{}{"arg_name", "==", "jack"}}
+		r.Priority = 10
+	}))
+	r.Put("2", newRoute("2", func(r *entity.Route) { r.URI = "/a" }))
+	r.Put("3", newRoute("3", func(r *entity.Route) {
+		r.URI = "/b"
+		r.Vars = []interface{}{"arg_name", "==", "jack", "invalid"}
+	}))
+
+	m, ok := r.Match(newCtx("GET", "localhost", "/a?name=jack"))
+	if assert.True(t, ok) {
+		assert.Equal(t, "1", m.Route.ID)
+	}
+	m, ok = r.Match(newCtx("GET", "localhost", "/a?name=rose"))
+	if assert.True(t, ok) {
+		assert.Equal(t, "2", m.Route.ID)
+	}
+	// 无效的vars不会被加载
+	_, ok = r.Match(newCtx("GET", "localhost", "/b?name=jack"))
+	assert.False(t, ok)
+}
+
+func TestRouterPrecedence(t *testing.T) {
+	r := NewRouter()
+	r.Load(map[string]*entity.Route{
+		"exact": newRoute("exact", func(r *entity.Route) { r.URI = "/users/me" }),
+		"param": newRoute("param", func(r *entity.Route) {
+			r.URI = "/users/{id}"
+			r.Priority = 100
+		}),
+		"wild": newRoute("wild", func(r *entity.Route) {
+			r.URI = "/users/*"
+			r.Priority = 200
+		}),
+		"any-host": newRoute("any-host", func(r *entity.Route) { r.URI = "/host" }),
+		"wild-host": newRoute("wild-host", func(r *entity.Route) {
+			r.URI = "/host"
+			r.Host = "*.example.com"
+		}),
+		"long-wild-host": newRoute("long-wild-host", func(r *entity.Route) {
+			r.URI = "/host"
+			r.Hosts = []string{"*.foo.com", "*.api.example.com"}
+		}),
+		"exact-host": newRoute("exact-host", func(r *entity.Route) {
+			r.URI = "/host"
+			r.Host = "v1.api.example.com"
+		}),
+		"b": newRoute("b", func(r *entity.Route) { r.URI = "/same" }),
+		"a": newRoute("a", func(r *entity.Route) { r.URI = "/same" }),
+		"low": newRoute("low", func(r *entity.Route) {
+			r.URI = "/prio"
+			r.Host = "localhost"
+		}),
+		"high": newRoute("high", func(r *entity.Route) {
+			r.URI = "/prio"
+			r.Priority = 1
+		}),
+	})
+
+	tests := []struct {
+		host, uri string
+		wantID    string
+	}{
+		{"localhost", "/users/me", "exact"},
+		{"localhost", "/users/1", "param"},
+		{"localhost", "/users/1/books", "wild"},
+		{"v1.api.example.com", "/host", "exact-host"},
+		{"v2.api.example.com", "/host", "long-wild-host"},
+		{"www.example.com", "/host", "wild-host"},
+		{"localhost", "/host", "any-host"},
+		{"localhost", "/same", "a"},
+		{"localhost", "/prio", "high"},
+	}
+	for _, tt := range tests {
+		// 多次匹配的结果相同
+		for i := 0; i < 10; i++ {
+			m, ok := r.Match(newCtx("GET", tt.host, tt.uri))
+			if assert.True(t, ok, tt.uri) {
+				assert.Equal(t, tt.wantID, m.Route.ID, tt.host+tt.uri)
+			}
+		}
+	}
+}
+
+func TestLessStrictWeakOrdering(t *testing.T) {
+	newEntry := func(id string, kind uriKind, fn func(r *entity.Route)) *entry {
+		route := newRoute(id, fn)
+		cr := &compiledRoute{id: id, route: route}
+		cr.hostRank, cr.hostLen = hostSpecificity(route)
+		return &entry{route: cr, kind: kind}
+	}
+	entries := []*entry{
+		newEntry("1", uriExact, func(r *entity.Route) {}),
+		newEntry("2", uriExact, func(r *entity.Route) { r.Priority = 1 }),
+		newEntry("3", uriParam, func(r *entity.Route) { r.Priority = 10 }),
+		newEntry("4", uriWildcard, func(r *entity.Route) { r.Priority = 10 }),
+		newEntry("5", uriExact, func(r *entity.Route) { r.Host = "*.example.com" }),
+		newEntry("6", uriExact, func(r *entity.Route) { r.Host = "api.example.com" }),
+		newEntry("7", uriExact, func(r *entity.Route) { r.Hosts = []string{"*.a.example.com"} }),
+		newEntry("8", uriParam, func(r *entity.Route) { r.Priority = 10 }),
+	}
+	for _, a := range entries {
+		assert.False(t, less(a, a), "irreflexive %s", a.route.id)
+		for _, b := range entries {
+			if less(a, b) {
+				assert.False(t, less(b, a), "asymmetric %s %s", a.route.id, b.route.id)
+			}
+			for _, c := range entries {
+				if less(a, b) && less(b, c) {
+					assert.True(t, less(a, c), "transitive %s %s %s", a.route.id, b.route.id, c.route.id)
+				}
+			}
+		}
+	}
+
+	want := []string{"2", "6", "7", "5", "1", "3", "8", "4"}
+	sort.Slice(entries, func(i, j int) bool {
+		return less(entries[i], entries[j])
+	})
+	got := make([]string, len(entries))
+	for i, e := range entries {
+		got[i] = e.route.id
+	}
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,229 @@
+// Package router
+//
+// @author: xwc1125
+package router
+
+import (
+	"regexp"
+	"strings"
+)
+
+// WildcardParam 通配符"*"所匹配的剩余路径在路径参数中的名称
+const WildcardParam = "*"
+
+var (
+	paramSegmentRe = regexp.MustCompile(`^\{([^/{}]+)\}$`)
+	keyMatch4Re    = regexp.MustCompile(`{([^/]+)}`)
+)
+
+// uriKind uri模式的类型
+type uriKind uint8
+
+const (
+	uriExact    uriKind = iota // 精确匹配，如：/foo/bar
+	uriParam                   // 参数匹配，如：/foo/{id}
+	uriWildcard                // 通配匹配，如：/foo/*
+)
+
+// entry 路由的一个uri模式，一个路由的每个uri都对应一个entry
+type entry struct {
+	route   *compiledRoute
+	pattern string
+	kind    uriKind
+	names   []string // 参数名，和匹配时捕获的值按顺序一一对应
+
+	re *regexp.Regexp // 仅用于无法放入前缀树的模式
+}
+
+// bind 将捕获的值绑定到参数名上。
+// 与reg_uri.KeyMatch4一致，重复出现的参数名必须取相同的值
+func (e *entry) bind(values []string) (map[string]string, bool) {
+	if len(e.names) == 0 {
+		return nil, true
+	}
+	params := make(map[string]string, len(e.names))
+	for i, name := range e.names {
+		if v, ok := params[name]; ok && v != values[i] {
+			return nil, false
+		}
+		params[name] = values[i]
+	}
+	return params, true
+}
+
+// candidate uri匹配成功的路由，还需要进一步校验method、host等条件
+type candidate struct {
+	entry  *entry
+	params map[string]string
+}
+
+// regexChild 参数与常量混合的段，如：bar_{project}_foo
+type regexChild struct {
+	segment string
+	re      *regexp.Regexp
+	node    *node
+}
+
+// node 按"/"切分路径的前缀树节点
+type node struct {
+	static    map[string]*node
+	param     *node
+	regexes   []*regexChild
+	wildcards []*entry // 以"/*"结尾的模式，匹配剩余的全部路径
+	entries   []*entry
+}
+
+func newNode() *node {
+	return &node{
+		static: make(map[string]*node),
+	}
+}
+
+// insert 将uri模式插入前缀树，无法使用前缀树表示的模式返回false
+func (n *node) insert(e *entry) bool {
+	segments := strings.Split(e.pattern, "/")
+	cur := n
+	for i, seg := range segments {
+		if seg == "*" && i > 0 && i == len(segments)-1 {
+			e.kind = uriWildcard
+			e.names = append(e.names, WildcardParam)
+			cur.wildcards = append(cur.wildcards, e)
+			return true
+		}
+		if strings.Contains(seg, "*") {
+			return false
+		}
+		if !strings.Contains(seg, "{") {
+			child, ok := cur.static[seg]
+			if !ok {
+				child = newNode()
+				cur.static[seg] = child
+			}
+			cur = child
+			continue
+		}
+
+		e.kind = uriParam
+		if m := paramSegmentRe.FindStringSubmatch(seg); m != nil {
+			e.names = append(e.names, m[1])
+			if cur.param == nil {
+				cur.param = newNode()
+			}
+			cur = cur.param
+			continue
+		}
+
+		var child *regexChild
+		for _, rc := range cur.regexes {
+			if rc.segment == seg {
+				child = rc
+				break
+			}
+		}
+		re, names := compileSegment(seg)
+		if child == nil {
+			child = &regexChild{
+				segment: seg,
+				re:      re,
+				node:    newNode(),
+			}
+			cur.regexes = append(cur.regexes, child)
+		}
+		e.names = append(e.names, names...)
+		cur = child.node
+	}
+	cur.entries = append(cur.entries, e)
+	return true
+}
+
+// lookup 查找所有与path匹配的entry
+func (n *node) lookup(path string, out *[]candidate) {
+	segments := strings.Split(path, "/")
+	starts := make([]int, len(segments))
+	offset := 0
+	for i, seg := range segments {
+		starts[i] = offset
+		offset += len(seg) + 1
+	}
+	n.search(path, segments, starts, 0, make([]string, 0, 4), out)
+}
+
+func (n *node) search(path string, segments []string, starts []int, i int, values []string, out *[]candidate) {
+	if i == len(segments) {
+		for _, e := range n.entries {
+			if params, ok := e.bind(values); ok {
+				*out = append(*out, candidate{entry: e, params: params})
+			}
+		}
+		return
+	}
+	if len(n.wildcards) > 0 {
+		rest := path[starts[i]:]
+		for _, e := range n.wildcards {
+			if params, ok := e.bind(append(values, rest)); ok {
+				*out = append(*out, candidate{entry: e, params: params})
+			}
+		}
+	}
+
+	seg := segments[i]
+	if child, ok := n.static[seg]; ok {
+		child.search(path, segments, starts, i+1, values, out)
+	}
+	if n.param != nil && seg != "" {
+		n.param.search(path, segments, starts, i+1, append(values, seg), out)
+	}
+	for _, rc := range n.regexes {
+		m := rc.re.FindStringSubmatch(seg)
+		if m == nil {
+			continue
+		}
+		rc.node.search(path, segments, starts, i+1, append(values, m[1:]...), out)
+	}
+}
+
+// compileSegment 编译参数与常量混合的段
+func compileSegment(seg string) (*regexp.Regexp, []string) {
+	var (
+		names []string
+		expr  strings.Builder
+		last  int
+	)
+	expr.WriteString("^")
+	for _, loc := range keyMatch4Re.FindAllStringSubmatchIndex(seg, -1) {
+		expr.WriteString(regexp.QuoteMeta(seg[last:loc[0]]))
+		expr.WriteString("([^/]+)")
+		names = append(names, seg[loc[2]:loc[3]])
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(seg[last:]))
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String()), names
+}
+
+// compileKeyMatch4 将前缀树无法表示的模式按reg_uri.KeyMatch4的规则编译为正则
+func compileKeyMatch4(e *entry) error {
+	pattern := strings.Replace(e.pattern, "/*", "/.*", -1)
+	pattern = keyMatch4Re.ReplaceAllStringFunc(pattern, func(s string) string {
+		e.names = append(e.names, s[1:len(s)-1])
+		return "([^/]+)"
+	})
+	re, err := regexp.Compile("^" + pattern + "$")
+	if err != nil {
+		return err
+	}
+	e.re = re
+	e.kind = uriWildcard
+	return nil
+}
+
+// match 使用正则匹配path
+func (e *entry) match(path string, out *[]candidate) {
+	m := e.re.FindStringSubmatch(path)
+	if m == nil {
+		return
+	}
+	if params, ok := e.bind(m[1:]); ok {
+		*out = append(*out, candidate{entry: e, params: params})
+	}
+}
@@ -0,0 +1,305 @@
+// Package expr 与APISIX的lua-resty-expr兼容的表达式，用于路由的vars、traffic-split的match等
+//
+// @author: xwc1125
+package expr
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/chain5j/chain5j-pkg/util/convutil"
+)
+
+// Vars 表达式中使用的变量，如：http_x_user、arg_name、cookie_sid、remote_addr、uri。
+// plugins.Context实现了该接口
+type Vars interface {
+	Var(name string) string
+}
+
+// Expr 编译后的表达式，可以在多个请求之间并发使用
+type Expr struct {
+	root node
+}
+
+type node interface {
+	eval(vars Vars) bool
+}
+
+// Compile 编译表达式，rule为空时匹配所有请求。支持的格式：
+//
+//	[["arg_name", "==", "jack"], ["http_user-id", ">", 23]]                    所有条件都满足
+//	["OR", ["arg_name", "==", "jack"], ["AND", [...], [...]]]                   AND、OR、!AND、!OR可以嵌套
+//	["arg_name", "!", "~~", "^ja"]                                             "!"对运算的结果取反
+//
+// 运算符：==、~=、>、>=、<、<=、~~(正则)、~*(不区分大小写的正则)、in、has、ipmatch
+func Compile(rule []interface{}) (*Expr, error) {
+	if len(rule) == 0 {
+		return &Expr{}, nil
+	}
+	root, err := compile(rule)
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{root: root}, nil
+}
+
+// Eval 计算表达式，表达式为空时返回true
+func (e *Expr) Eval(vars Vars) bool {
+	if e == nil || e.root == nil {
+		return true
+	}
+	return e.root.eval(vars)
+}
+
+// logical AND、OR及其取反
+type logical struct {
+	or       bool
+	not      bool
+	children []node
+}
+
+func (l *logical) eval(vars Vars) bool {
+	result := !l.or
+	for _, child := range l.children {
+		if child.eval(vars) == l.or {
+			result = l.or
+			break
+		}
+	}
+	return result != l.not
+}
+
+// compile 编译逻辑表达式、条件列表或者单个条件
+func compile(rule []interface{}) (node, error) {
+	if len(rule) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	if op, ok := rule[0].(string); ok {
+		switch strings.ToUpper(op) {
+		case "AND", "OR", "!AND", "!OR":
+			op = strings.ToUpper(op)
+			return compileLogical(op, rule[1:])
+		}
+		return compileCompare(rule)
+	}
+	// 条件列表，所有条件都需要满足
+	return compileLogical("AND", rule)
+}
+
+func compileLogical(op string, rules []interface{}) (node, error) {
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("%s requires at least one expression", op)
+	}
+	l := &logical{
+		or:       strings.HasSuffix(op, "OR"),
+		not:      strings.HasPrefix(op, "!"),
+		children: make([]node, 0, len(rules)),
+	}
+	for _, r := range rules {
+		sub, ok := r.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid expression %v in %s", r, op)
+		}
+		child, err := compile(sub)
+		if err != nil {
+			return nil, err
+		}
+		l.children = append(l.children, child)
+	}
+	return l, nil
+}
+
+// compare 变量与值的比较
+type compare struct {
+	name string
+	op   string
+	not  bool
+
+	str  string         // ==、~=的字符串
+	num  *float64       // 值为数字时，变量转换为数字后比较
+	list []string       // in的值
+	re   *regexp.Regexp // ~~、~*
+	nets []*net.IPNet   // ipmatch
+}
+
+func compileCompare(rule []interface{}) (node, error) {
+	c := &compare{}
+	switch len(rule) {
+	case 3:
+		c.op = convutil.ToString(rule[1])
+	case 4:
+		if convutil.ToString(rule[1]) != "!" {
+			return nil, fmt.Errorf("invalid expression %v", rule)
+		}
+		c.not = true
+		c.op = convutil.ToString(rule[2])
+	default:
+		return nil, fmt.Errorf("invalid expression %v", rule)
+	}
+	name, ok := rule[0].(string)
+	if !ok || len(name) == 0 {
+		return nil, fmt.Errorf("invalid variable in expression %v", rule)
+	}
+	c.name = name
+	value := rule[len(rule)-1]
+
+	switch c.op {
+	case "==", "~=":
+		if num, ok := toNumber(value); ok {
+			c.num = &num
+		} else {
+			c.str = convutil.ToString(value)
+		}
+	case ">", ">=", "<", "<=":
+		num, ok := toNumber(value)
+		if !ok {
+			return nil, fmt.Errorf("invalid number in expression %v", rule)
+		}
+		c.num = &num
+	case "~~", "~*":
+		pattern := convutil.ToString(value)
+		if c.op == "~*" {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex in expression %v: %w", rule, err)
+		}
+		c.re = re
+	case "in":
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid list in expression %v", rule)
+		}
+		for _, item := range list {
+			c.list = append(c.list, formatValue(item))
+		}
+	case "has":
+		c.str = convutil.ToString(value)
+	case "ipmatch":
+		var ranges []string
+		switch v := value.(type) {
+		case string:
+			ranges = []string{v}
+		case []interface{}:
+			for _, item := range v {
+				ranges = append(ranges, convutil.ToString(item))
+			}
+		default:
+			return nil, fmt.Errorf("invalid ip in expression %v", rule)
+		}
+		for _, r := range ranges {
+			n, err := parseIPNet(r)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ip in expression %v: %w", rule, err)
+			}
+			c.nets = append(c.nets, n)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported operator %s in expression %v", c.op, rule)
+	}
+	return c, nil
+}
+
+func (c *compare) eval(vars Vars) bool {
+	return c.match(vars.Var(c.name)) != c.not
+}
+
+func (c *compare) match(value string) bool {
+	switch c.op {
+	case "==", "~=":
+		equal := value == c.str
+		if c.num != nil {
+			num, err := strconv.ParseFloat(value, 64)
+			equal = err == nil && num == *c.num
+		}
+		return equal == (c.op == "==")
+	case ">", ">=", "<", "<=":
+		num, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		switch c.op {
+		case ">":
+			return num > *c.num
+		case ">=":
+			return num >= *c.num
+		case "<":
+			return num < *c.num
+		}
+		return num <= *c.num
+	case "~~", "~*":
+		return len(value) > 0 && c.re.MatchString(value)
+	case "in":
+		for _, item := range c.list {
+			if item == value {
+				return true
+			}
+		}
+	case "has":
+		// 变量为逗号分隔的列表，如多个值的请求头
+		for _, item := range strings.Split(value, ",") {
+			if strings.TrimSpace(item) == c.str {
+				return true
+			}
+		}
+	case "ipmatch":
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return false
+		}
+		for _, n := range c.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// toNumber JSON中的数字
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// formatValue 将值转换为与变量比较的字符串，整数不带小数部分
+func formatValue(v interface{}) string {
+	if num, ok := toNumber(v); ok {
+		return strconv.FormatFloat(num, 'f', -1, 64)
+	}
+	return convutil.ToString(v)
+}
+
+// parseIPNet 解析IP或者CIDR
+func parseIPNet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		return n, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip %s", s)
+	}
+	bits := 8 * net.IPv4len
+	if ip.To4() == nil {
+		bits = 8 * net.IPv6len
+	} else {
+		ip = ip.To4()
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
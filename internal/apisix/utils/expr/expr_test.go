@@ -0,0 +1,91 @@
+// Package expr
+//
+// @author: xwc1125
+package expr
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mapVars map[string]string
+
+func (m mapVars) Var(name string) string {
+	return m[name]
+}
+
+func compileJSON(t *testing.T, s string) (*Expr, error) {
+	var rule []interface{}
+	if err := json.Unmarshal([]byte(s), &rule); err != nil {
+		t.Fatal(err)
+	}
+	return Compile(rule)
+}
+
+func TestExprEval(t *testing.T) {
+	vars := mapVars{
+		"arg_name":        "jack",
+		"http_user-id":    "24",
+		"http_accept":     "text/html, application/json",
+		"remote_addr":     "192.168.1.10",
+		"uri":             "/api/Users",
+		"cookie_version":  "v2",
+		"http_x-real-ip6": "2001:db8::1",
+	}
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		{`[]`, true},
+		{`[["arg_name", "==", "jack"]]`, true},
+		{`[["arg_name", "~=", "jack"]]`, false},
+		{`[["arg_age", "==", ""]]`, true},
+		{`[["http_user-id", "==", 24]]`, true},
+		{`[["http_user-id", ">", 23], ["http_user-id", "<=", 24]]`, true},
+		{`[["http_user-id", ">=", 25]]`, false},
+		{`[["arg_name", "<", 1]]`, false},
+		{`[["uri", "~~", "^/api/[a-z]+$"]]`, false},
+		{`[["uri", "~*", "^/api/[a-z]+$"]]`, true},
+		{`[["cookie_version", "in", ["v1", "v2"]]]`, true},
+		{`[["http_user-id", "in", [23, 24]]]`, true},
+		{`[["http_accept", "has", "application/json"]]`, true},
+		{`[["http_accept", "has", "application/xml"]]`, false},
+		{`[["remote_addr", "ipmatch", "192.168.1.0/24"]]`, true},
+		{`[["remote_addr", "ipmatch", ["10.0.0.1", "192.168.1.10"]]]`, true},
+		{`[["http_x-real-ip6", "ipmatch", "2001:db8::/32"]]`, true},
+		{`[["arg_name", "ipmatch", "192.168.1.0/24"]]`, false},
+		{`[["arg_name", "!", "==", "jack"]]`, false},
+		{`["arg_name", "!", "~~", "^ro"]`, true},
+		{`["OR", ["arg_name", "==", "rose"], ["cookie_version", "==", "v2"]]`, true},
+		{`["AND", ["arg_name", "==", "jack"], ["OR", ["uri", "==", "/"], ["http_user-id", "==", 1]]]`, false},
+		{`["!AND", ["arg_name", "==", "jack"], ["cookie_version", "==", "v1"]]`, true},
+		{`["!OR", ["arg_name", "==", "rose"], ["cookie_version", "==", "v1"]]`, true},
+	}
+	for _, tt := range tests {
+		e, err := compileJSON(t, tt.rule)
+		if assert.NoError(t, err, tt.rule) {
+			assert.Equal(t, tt.want, e.Eval(vars), tt.rule)
+		}
+	}
+}
+
+func TestExprCompileError(t *testing.T) {
+	rules := []string{
+		`[["arg_name", "==="]]`,
+		`[["arg_name", "=", "jack"]]`,
+		`[["arg_name", "not", "==", "jack"]]`,
+		`[["http_user-id", ">", "abc"]]`,
+		`[["uri", "~~", "("]]`,
+		`[["arg_name", "in", "jack"]]`,
+		`[["remote_addr", "ipmatch", "256.0.0.1"]]`,
+		`["OR"]`,
+		`["AND", "arg_name"]`,
+		`[[1, "==", "jack"]]`,
+	}
+	for _, rule := range rules {
+		_, err := compileJSON(t, rule)
+		assert.Error(t, err, rule)
+	}
+}
@@ -21,3 +21,16 @@ type ServerConfig struct {
 	Ssl  network.TlsConfig `json:"ssl" mapstructure:"ssl" yaml:"ssl"`
 	// Cors cors.Options      `json:"cors" mapstructure:"cors" yaml:"cors"`
 }
+
+// AdminConfig Admin API的配置
+type AdminConfig struct {
+	ServerConfig `mapstructure:",squash"`
+	AdminKey     []AdminKey `json:"admin_key" mapstructure:"admin_key" yaml:"admin_key"`
+}
+
+// AdminKey Admin API的访问密钥，role为admin时可读写，为viewer时只读
+type AdminKey struct {
+	Name string `json:"name" mapstructure:"name" yaml:"name"`
+	Key  string `json:"key" mapstructure:"key" yaml:"key"`
+	Role string `json:"role" mapstructure:"role" yaml:"role"`
+}
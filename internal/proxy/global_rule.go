@@ -0,0 +1,46 @@
+// Package proxy
+//
+// @author: xwc1125
+package proxy
+
+import (
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+// GlobalRule 全局规则，对所有请求生效，在路由的插件之前执行
+type GlobalRule struct {
+	ConfKey string                 // 插件配置缓存的key
+	Plugins map[string]interface{} // 插件集
+}
+
+// WithGlobalRules 设置全局规则，每个请求都会调用rules获取当前生效的全局规则
+func WithGlobalRules(rules func() []GlobalRule) Option {
+	return newFuncBuildOption(func(o *buildOption) {
+		o.globalRules = rules
+	})
+}
+
+func (p *Proxy) getGlobalRules() []GlobalRule {
+	if p.opt.globalRules == nil {
+		return nil
+	}
+	return p.opt.globalRules()
+}
+
+// globalChains 创建全局规则的插件链，按规则的顺序执行
+func (p *Proxy) globalChains() ([]*plugins.Chain, error) {
+	rules := p.getGlobalRules()
+	chains := make([]*plugins.Chain, 0, len(rules))
+	for _, rule := range rules {
+		key, err := plugins.PrepareConf(rule.ConfKey, rule.Plugins)
+		if err != nil {
+			return nil, err
+		}
+		chain, err := plugins.NewChain(key)
+		if err != nil {
+			return nil, err
+		}
+		chains = append(chains, chain)
+	}
+	return chains, nil
+}
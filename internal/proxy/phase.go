@@ -0,0 +1,42 @@
+// Package proxy
+//
+// @author: xwc1125
+package proxy
+
+import (
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+// runPhase 依次执行插件链的phase阶段，插件返回错误或ActionRespond时不再执行后续的插件链
+func runPhase(phase plugins.Phase, ctx *plugins.Context, chains ...*plugins.Chain) (plugins.Action, error) {
+	for _, chain := range chains {
+		if chain == nil {
+			continue
+		}
+		action, err := chain.Run(phase, ctx)
+		if err != nil || action != plugins.ActionContinue {
+			return action, err
+		}
+	}
+	return plugins.ActionContinue, nil
+}
+
+// runLogPhase 响应发送之后异步执行log阶段，插件使用的是请求上下文的副本。
+// 在请求结束前计入p.logs，Close会等待log阶段执行完毕
+func (p *Proxy) runLogPhase(ctx *plugins.Context, chains ...*plugins.Chain) {
+	if len(chains) == 0 {
+		return
+	}
+	d := ctx.Detach()
+	p.logs.Add(1)
+	go func() {
+		defer p.logs.Done()
+		defer d.Release()
+		defer func() {
+			if r := recover(); r != nil {
+				p.log.Error("plugin log phase panic", "err", r)
+			}
+		}()
+		_, _ = runPhase(plugins.PhaseLog, d, chains...)
+	}()
+}
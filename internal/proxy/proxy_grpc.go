@@ -0,0 +1,388 @@
+// Package proxy
+//
+// @author: xwc1125
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+	"github.com/xwc1125/apisix-go/internal/apisix/router"
+	"golang.org/x/net/http2"
+)
+
+// gRPC的状态码，见 https://github.com/grpc/grpc/blob/master/doc/statuscodes.md
+const (
+	grpcCanceled         = 1
+	grpcDeadlineExceeded = 4
+	grpcUnavailable      = 14
+)
+
+// isGRPCScheme 上游的协议是否为gRPC
+func isGRPCScheme(scheme string) bool {
+	return scheme == "grpc" || scheme == "grpcs"
+}
+
+// newGRPCTransport 创建HTTP/2的Transport，grpc使用h2c，grpcs使用TLS并通过ALPN协商h2
+func newGRPCTransport(upstream *entity.UpstreamDef, base *tls.Config, cert *tls.Certificate) *http2.Transport {
+	dialer := &net.Dialer{}
+	if upstream.Timeout != nil {
+		dialer.Timeout = seconds(upstream.Timeout.Connect)
+	}
+	transport := &http2.Transport{AllowHTTP: true}
+	if isTLSScheme(upstream.Scheme) {
+		transport.DialTLS = func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			config := upstreamTLSConfig(base, upstream, cert, addr)
+			config.NextProtos = []string{http2.NextProtoTLS}
+			return tls.DialWithDialer(dialer, network, addr, config)
+		}
+	} else {
+		transport.DialTLS = func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.Dial(network, addr)
+		}
+	}
+	return transport
+}
+
+// GRPCRequestCtx 将net/http的请求转换为fasthttp的RequestCtx，用于路由匹配和执行插件。
+// 只包含请求行和请求头，请求体仍然从r.Body流式读取
+func GRPCRequestCtx(r *http.Request) *fasthttp.RequestCtx {
+	req := new(fasthttp.Request)
+	req.Header.SetMethod(r.Method)
+	req.SetRequestURI(r.URL.RequestURI())
+	req.SetHost(r.Host)
+	for key, values := range r.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	remoteAddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		remoteAddr = &net.TCPAddr{}
+	}
+	ctx := new(fasthttp.RequestCtx)
+	ctx.Init(req, remoteAddr, nil)
+	return ctx
+}
+
+// WriteResponse 将fasthttp的响应写入net/http的ResponseWriter
+func WriteResponse(w http.ResponseWriter, resp *fasthttp.Response) {
+	resp.Header.VisitAll(func(key, value []byte) {
+		w.Header().Add(string(key), string(value))
+	})
+	w.WriteHeader(resp.StatusCode())
+	_, _ = w.Write(resp.Body())
+}
+
+// ServeGRPC 代理h2/h2c的gRPC请求。
+// 插件的rewrite、access和before_proxy阶段只能处理请求头，请求体和响应体在客户端与上游之间双向流式转发，
+// 上游的trailer(grpc-status、grpc-message)原样返回给客户端。gRPC的流无法重放，因此不重试
+func (p *Proxy) ServeGRPC(ctx *fasthttp.RequestCtx, w http.ResponseWriter, r *http.Request) {
+	if p.grpc == nil {
+		p.log.Error("route upstream is not grpc", "id", getId(ctx), "scheme", p.route.Upstream.Scheme)
+		writeGRPCError(w, grpcUnavailable, "route upstream is not grpc")
+		return
+	}
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	ctx.Request.CopyTo(req)
+	// consumer只能由认证插件设置
+	req.Header.Del(plugins.HeaderConsumerUsername)
+	pctx := plugins.NewContext(ctx, &p.route, req, resp)
+	pctx.Params = router.PathParams(ctx)
+	xForwardFor(ctx, req)
+
+	key, err := plugins.PrepareConf(p.confKey, p.route.Plugins)
+	if err != nil {
+		p.log.Error("plugin prepare conf err", "err", err)
+		p.respToGRPC(w, resp, err)
+		return
+	}
+	chain, err := plugins.NewChain(key)
+	if err != nil {
+		p.log.Error("plugin new chain err", "err", err)
+		p.respToGRPC(w, resp, err)
+		return
+	}
+	globals, err := p.globalChains()
+	if err != nil {
+		p.log.Error("global rule new chain err", "err", err)
+		p.respToGRPC(w, resp, err)
+		return
+	}
+	defer func() {
+		p.runLogPhase(pctx, append(globals, chain)...)
+	}()
+
+	for _, phase := range []plugins.Phase{plugins.PhaseRewrite, plugins.PhaseAccess, plugins.PhaseBeforeProxy} {
+		action, err := runPhase(phase, pctx, append(globals, chain)...)
+		if err != nil || action == plugins.ActionRespond {
+			p.log.Debug("plugin req call stop", "phase", phase, "err", err)
+			p.respToGRPC(w, resp, err)
+			return
+		}
+		if phase == plugins.PhaseRewrite {
+			chain = chain.MergeConsumer(pctx)
+		}
+	}
+	idx, _, err := p.pickClient(req, pctx)
+	if err != nil {
+		p.log.Error("get client err", "err", err)
+		resp.SetStatusCode(http.StatusOK)
+		resp.Header.Set("Grpc-Status", strconv.Itoa(grpcUnavailable))
+		writeGRPCError(w, grpcUnavailable, err.Error())
+		return
+	}
+	if host := p.upstreamHost(idx); len(host) > 0 && string(req.Host()) == pctx.Var("host") {
+		req.SetHost(host)
+	}
+	pctx.Set("upstream_addr", p.clientsUrl[idx])
+
+	// gRPC的deadline通过grpc-timeout传递，网关同样在deadline到达时取消转发
+	reqCtx := r.Context()
+	if timeout, ok := parseGRPCTimeout(string(req.Header.Peek("Grpc-Timeout"))); ok {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(reqCtx, timeout)
+		defer cancel()
+	}
+	out := p.grpcRequest(reqCtx, req, r.Body, idx)
+	begin := time.Now()
+	res, err := p.grpc.RoundTrip(out)
+	if err != nil {
+		p.log.Error("grpc forward failed", "addr", p.clientsUrl[idx], "err", err)
+		p.checker.ReportError(idx, err)
+		p.done(idx, time.Since(begin), err)
+		code := grpcErrorCode(reqCtx, err)
+		resp.SetStatusCode(http.StatusOK)
+		resp.Header.Set("Grpc-Status", strconv.Itoa(code))
+		writeGRPCError(w, code, err.Error())
+		return
+	}
+	defer res.Body.Close()
+	resp.SetStatusCode(res.StatusCode)
+	// 流式调用的时长不确定，使用收到响应头的时间作为响应时间，流结束时才反馈给负载均衡
+	latency := time.Since(begin)
+
+	for key, values := range res.Header {
+		if isHopHeader(key) {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+			resp.Header.Add(key, value)
+		}
+	}
+	w.WriteHeader(res.StatusCode)
+	if err := copyStream(w, res.Body); err != nil {
+		// 响应头已经发出，通过trailer返回错误
+		p.log.Error("grpc copy response failed", "addr", p.clientsUrl[idx], "err", err)
+		code := grpcErrorCode(reqCtx, err)
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", err.Error())
+		resp.Header.Set("Grpc-Status", strconv.Itoa(code))
+		p.checker.ReportGRPCStatus(idx, res.StatusCode, grpcStatusCode(res))
+		p.done(idx, latency, err)
+		return
+	}
+	// grpc-status在trailer中，流结束后才能上报给被动健康检查
+	p.checker.ReportGRPCStatus(idx, res.StatusCode, grpcStatusCode(res))
+	p.done(idx, latency, nil)
+	for key, values := range res.Trailer {
+		for _, value := range values {
+			w.Header().Add(http.TrailerPrefix+key, value)
+			resp.Header.Add(key, value)
+		}
+	}
+}
+
+// forwardGRPC 将HTTP/1.1的请求以一元调用转发给gRPC上游，上游的trailer合并到响应头中
+func (p *Proxy) forwardGRPC(idx int, req *fasthttp.Request, resp *fasthttp.Response) error {
+	reqCtx := context.Background()
+	if timeout, ok := parseGRPCTimeout(string(req.Header.Peek("Grpc-Timeout"))); ok {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(reqCtx, timeout)
+		defer cancel()
+	}
+	out := p.grpcRequest(reqCtx, req, io.NopCloser(bytes.NewReader(req.Body())), idx)
+	out.ContentLength = int64(len(req.Body()))
+	begin := time.Now()
+	res, err := p.grpc.RoundTrip(out)
+	if err != nil {
+		p.checker.ReportError(idx, err)
+		p.done(idx, time.Since(begin), err)
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	p.done(idx, time.Since(begin), err)
+	if err != nil {
+		p.checker.ReportError(idx, err)
+		return err
+	}
+	p.checker.ReportGRPCStatus(idx, res.StatusCode, grpcStatusCode(res))
+
+	resp.SetStatusCode(res.StatusCode)
+	for _, header := range []http.Header{res.Header, res.Trailer} {
+		for key, values := range header {
+			if isHopHeader(key) {
+				continue
+			}
+			for _, value := range values {
+				resp.Header.Add(key, value)
+			}
+		}
+	}
+	resp.SetBody(body)
+	return nil
+}
+
+// grpcRequest 创建转发给上游节点idx的请求，请求头使用插件处理之后的req
+func (p *Proxy) grpcRequest(ctx context.Context, req *fasthttp.Request, body io.ReadCloser, idx int) *http.Request {
+	scheme := "http"
+	if isTLSScheme(p.route.Upstream.Scheme) {
+		scheme = "https"
+	}
+	out := &http.Request{
+		Method: string(req.Header.Method()),
+		URL: &url.URL{
+			Scheme:   scheme,
+			Host:     p.clientsUrl[idx],
+			Path:     string(req.URI().Path()),
+			RawQuery: string(req.URI().QueryString()),
+		},
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		Header:        make(http.Header),
+		Body:          body,
+		ContentLength: -1,
+		Host:          string(req.Host()),
+	}
+	req.Header.VisitAll(func(key, value []byte) {
+		k := string(key)
+		if k == fasthttp.HeaderHost || k == fasthttp.HeaderContentLength || isHopHeader(k) {
+			return
+		}
+		out.Header.Add(k, string(value))
+	})
+	// gRPC要求te: trailers
+	out.Header.Set("Te", "trailers")
+	return out.WithContext(ctx)
+}
+
+// respToGRPC 插件终止请求时返回给客户端，gRPC客户端会将HTTP状态码转换为对应的gRPC状态码
+func (p *Proxy) respToGRPC(w http.ResponseWriter, resp *fasthttp.Response, err error) {
+	if err != nil {
+		plugins.AsPluginError(err).WriteTo(resp)
+	}
+	WriteResponse(w, resp)
+}
+
+// writeGRPCError 返回只有trailer的gRPC错误响应
+func writeGRPCError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", msg)
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcErrorCode 转发失败时对应的gRPC状态码
+func grpcErrorCode(ctx context.Context, err error) int {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded) || isTimeout(err):
+		return grpcDeadlineExceeded
+	case errors.Is(ctx.Err(), context.Canceled):
+		return grpcCanceled
+	}
+	return grpcUnavailable
+}
+
+// grpcStatusCode 上游响应的grpc-status，优先读取trailer，Trailers-Only的响应在响应头中，没有时返回-1
+func grpcStatusCode(res *http.Response) int {
+	value := res.Trailer.Get("Grpc-Status")
+	if value == "" {
+		value = res.Header.Get("Grpc-Status")
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return -1
+	}
+	return code
+}
+
+// copyStream 将上游的响应体流式写给客户端，每次读取后立即flush
+func copyStream(w http.ResponseWriter, body io.Reader) error {
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseGRPCTimeout 解析grpc-timeout，格式为数字加单位，如：100m、5S
+func parseGRPCTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	var unit time.Duration
+	switch s[len(s)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// isHopHeader 是否为逐跳的header，不转发
+func isHopHeader(key string) bool {
+	for _, h := range hopHeaders {
+		if http.CanonicalHeaderKey(key) == h {
+			return true
+		}
+	}
+	return false
+}
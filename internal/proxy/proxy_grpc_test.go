@@ -0,0 +1,306 @@
+// Package proxy
+//
+// @author: xwc1125
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/lb"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func newH2CServer(handler http.Handler) *httptest.Server {
+	srv := httptest.NewUnstartedServer(h2c.NewHandler(handler, &http2.Server{}))
+	srv.Start()
+	return srv
+}
+
+func h2cTransport() *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+}
+
+func TestProxyGRPC(t *testing.T) {
+	plugins.InitConfCache(time.Minute)
+	backend := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "trailers", r.Header.Get("Te"))
+		if r.URL.Path == "/test.Echo/Slow" {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// 收到一条消息立即返回一条，验证双向流
+		buf := make([]byte, 32)
+		for {
+			n, err := r.Body.Read(buf)
+			if n > 0 {
+				_, _ = w.Write(bytes.ToUpper(buf[:n]))
+				w.(http.Flusher).Flush()
+			}
+			if err != nil {
+				break
+			}
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "done")
+	}))
+	defer backend.Close()
+
+	route := entity.Route{
+		BaseInfo: entity.BaseInfo{ID: "grpc"},
+		Upstream: &entity.UpstreamDef{
+			Nodes:  []*entity.Node{testUpstreamNode(t, backend)},
+			Scheme: "grpc",
+		},
+	}
+	p, err := NewProxy(route)
+	assert.NoError(t, err)
+	defer p.Close()
+	assert.NotNil(t, p.grpc)
+	assert.Empty(t, p.clients)
+	gateway := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.ServeGRPC(GRPCRequestCtx(r), w, r)
+	}))
+	defer gateway.Close()
+	client := h2cTransport()
+
+	pr, pw := io.Pipe()
+	req, _ := http.NewRequest(http.MethodPost, gateway.URL+"/test.Echo/Stream", pr)
+	req.Header.Set("Content-Type", "application/grpc")
+	res, err := client.RoundTrip(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	buf := make([]byte, 32)
+	for _, msg := range []string{"ping1", "ping2"} {
+		_, _ = pw.Write([]byte(msg))
+		n, err := res.Body.Read(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, bytes.ToUpper([]byte(msg)), buf[:n])
+	}
+	_ = pw.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	assert.Equal(t, "0", res.Trailer.Get("Grpc-Status"))
+	assert.Equal(t, "done", res.Trailer.Get("Grpc-Message"))
+
+	// 到达grpc-timeout时取消转发，返回DEADLINE_EXCEEDED
+	start := time.Now()
+	req, _ = http.NewRequest(http.MethodPost, gateway.URL+"/test.Echo/Slow", bytes.NewReader(nil))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Grpc-Timeout", "100m")
+	res, err = client.RoundTrip(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	assert.Equal(t, "4", res.Header.Get("Grpc-Status"))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestProxyGRPCPassiveHealth(t *testing.T) {
+	plugins.InitConfCache(time.Minute)
+	backend := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "14")
+	}))
+	defer backend.Close()
+
+	route := entity.Route{
+		BaseInfo: entity.BaseInfo{ID: "grpc-passive"},
+		Upstream: &entity.UpstreamDef{
+			Nodes:  []*entity.Node{testUpstreamNode(t, backend)},
+			Scheme: "grpc",
+			Checks: &entity.HealthChecker{Passive: entity.Passive{
+				UnHealthy: entity.UnHealthy{HTTPStatuses: []int{502}, HTTPFailures: 2},
+			}},
+		},
+	}
+	p, err := NewProxy(route)
+	assert.NoError(t, err)
+	defer p.Close()
+	if !assert.NotNil(t, p.checker) {
+		return
+	}
+	gateway := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.ServeGRPC(GRPCRequestCtx(r), w, r)
+	}))
+	defer gateway.Close()
+
+	// HTTP状态码为200，但trailer中的grpc-status为UNAVAILABLE，计为失败
+	for i := 0; i < 2; i++ {
+		assert.True(t, p.checker.Healthy(0))
+		req, _ := http.NewRequest(http.MethodPost, gateway.URL+"/test.Echo/Unary", bytes.NewReader(nil))
+		req.Header.Set("Content-Type", "application/grpc")
+		res, err := h2cTransport().RoundTrip(req)
+		if !assert.NoError(t, err) {
+			return
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		assert.Equal(t, "14", res.Trailer.Get("Grpc-Status"))
+	}
+	assert.False(t, p.checker.Healthy(0))
+}
+
+// noneBalance 没有可选择的节点
+type noneBalance struct {
+	lb.LoadBalance
+}
+
+func (noneBalance) Distribute(*fasthttp.Request, lb.Vars) int64 {
+	return -1
+}
+
+func TestProxyGRPCNoAvailableNode(t *testing.T) {
+	plugins.InitConfCache(time.Minute)
+	backend := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should not be forwarded")
+	}))
+	defer backend.Close()
+
+	route := entity.Route{
+		BaseInfo: entity.BaseInfo{ID: "grpc-no-node"},
+		Upstream: &entity.UpstreamDef{
+			Nodes:  []*entity.Node{testUpstreamNode(t, backend), testUpstreamNode(t, backend)},
+			Scheme: "grpc",
+		},
+	}
+	p, err := NewProxy(route)
+	assert.NoError(t, err)
+	defer p.Close()
+	p.lb = noneBalance{p.lb}
+	gateway := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.ServeGRPC(GRPCRequestCtx(r), w, r)
+	}))
+	defer gateway.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, gateway.URL+"/test.Echo/Unary", bytes.NewReader(nil))
+	req.Header.Set("Content-Type", "application/grpc")
+	res, err := h2cTransport().RoundTrip(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "14", res.Header.Get("Grpc-Status"))
+	assert.Equal(t, errNoAvailableNode.Error(), res.Header.Get("Grpc-Message"))
+}
+
+func TestParseGRPCTimeout(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"100m": 100 * time.Millisecond,
+		"5S":   5 * time.Second,
+		"1H":   time.Hour,
+		"20u":  20 * time.Microsecond,
+	} {
+		d, ok := parseGRPCTimeout(in)
+		assert.True(t, ok, in)
+		assert.Equal(t, want, d, in)
+	}
+	for _, in := range []string{"", "m", "10", "10x", "-1S"} {
+		_, ok := parseGRPCTimeout(in)
+		assert.False(t, ok, in)
+	}
+}
+
+func TestProxyGRPCTranscode(t *testing.T) {
+	plugins.InitConfCache(time.Minute)
+	plugins.StoreProto("transcode", &entity.Proto{Content: `syntax = "proto3";
+package helloworld;
+service Greeter {
+  rpc SayHello (HelloRequest) returns (HelloReply) {}
+}
+message HelloRequest {
+  string name = 1;
+}
+message HelloReply {
+  string message = 1;
+}
+`})
+	defer plugins.DeleteProto("transcode")
+	backend := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/helloworld.Greeter/SayHello", r.URL.Path)
+		assert.Equal(t, "application/grpc", r.Header.Get("Content-Type"))
+		assert.Equal(t, "500m", r.Header.Get("Grpc-Timeout"))
+		body, _ := io.ReadAll(r.Body)
+		var name string
+		if len(body) > 5 {
+			_, _, n := protowire.ConsumeTag(body[5:])
+			v, _ := protowire.ConsumeString(body[5+n:])
+			name = v
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		if len(name) == 0 {
+			w.Header().Set("Grpc-Status", "3")
+			w.Header().Set("Grpc-Message", "name is required")
+			return
+		}
+		msg := protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "hello "+name)
+		_, _ = w.Write(append([]byte{0, 0, 0, 0, byte(len(msg))}, msg...))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}))
+	defer backend.Close()
+
+	route := entity.Route{
+		BaseInfo: entity.BaseInfo{ID: "transcode"},
+		Plugins: map[string]interface{}{
+			"grpc-transcode": map[string]interface{}{
+				"proto_id": "transcode",
+				"service":  "helloworld.Greeter",
+				"method":   "SayHello",
+				"deadline": 500,
+			},
+		},
+		Upstream: &entity.UpstreamDef{
+			Nodes:  []*entity.Node{testUpstreamNode(t, backend)},
+			Scheme: "grpc",
+		},
+	}
+	p, err := NewProxy(route)
+	assert.NoError(t, err)
+	defer p.Close()
+
+	serve := func(uri string) *fasthttp.Response {
+		req := new(fasthttp.Request)
+		req.SetRequestURI(uri)
+		ctx := new(fasthttp.RequestCtx)
+		ctx.Init(req, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, nil)
+		p.ServeHTTP(ctx)
+		return &ctx.Response
+	}
+	resp := serve("http://example.com/hello?name=world")
+	assert.Equal(t, fasthttp.StatusOK, resp.StatusCode())
+	assert.Equal(t, "application/json", string(resp.Header.ContentType()))
+	assert.JSONEq(t, `{"message":"hello world"}`, string(resp.Body()))
+
+	resp = serve("http://example.com/hello")
+	assert.Equal(t, fasthttp.StatusBadRequest, resp.StatusCode())
+	assert.JSONEq(t, `{"code":3,"message":"name is required","details":[]}`, string(resp.Body()))
+}
@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/chain5j/chain5j-pkg/util/convutil"
 	"github.com/chain5j/logger"
@@ -12,24 +15,44 @@ import (
 	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
 	"github.com/xwc1125/apisix-go/internal/apisix/discover"
 	_ "github.com/xwc1125/apisix-go/internal/apisix/discover/polaris"
+	"github.com/xwc1125/apisix-go/internal/apisix/healthcheck"
 	"github.com/xwc1125/apisix-go/internal/apisix/lb"
 	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
 	_ "github.com/xwc1125/apisix-go/internal/apisix/plugins/plugins"
 	_ "github.com/xwc1125/apisix-go/internal/apisix/plugins/plugins/cgw"
+	"github.com/xwc1125/apisix-go/internal/apisix/router"
+	"golang.org/x/net/http2"
 )
 
 const (
 	_fasthttpHostClientName = "reverse-proxy"
 )
 
+var (
+	proxySeq uint64 // 用于生成每个proxy独立的插件配置key
+
+	errNoAvailableNode = errors.New("no available upstream node")
+)
+
 // Proxy 反向代理的handler
 type Proxy struct {
 	log        logger.Logger
 	route      entity.Route           // 路由配置
 	clients    []*fasthttp.HostClient // clients 客户端集合[做负载均衡时使用]
 	clientsUrl []string               // client的URL集合
+	hosts      []string               // 与clients一一对应，pass_host为node时发送给节点的Host
+	confKey    string                 // 插件配置缓存的key，每个proxy独立，避免路由变更时读到旧的配置
+
+	lb      lb.LoadBalance       // lb 负载均衡
+	checker *healthcheck.Checker // checker 上游节点的健康检查，未配置时为nil
+	retry   retryPolicy          // retry 转发失败时的重试策略
+	pools   []*upstreamPool      // pools 与clients一一对应的连接池
+	grpc    *http2.Transport     // grpc 上游为grpc/grpcs时使用HTTP/2转发，不创建clients
 
-	lb lb.LoadBalance // lb 负载均衡
+	splits      *Registry // splits 插件指定的其他上游的Proxy，如traffic-split
+	splitRoutes sync.Map  // 上游的key -> 创建splits中的Proxy使用的路由
+
+	logs sync.WaitGroup // 正在执行的log阶段，Close时等待其结束后再释放插件配置
 
 	// opt contains finally option to open reverseProxy
 	opt              *buildOption
@@ -49,6 +72,8 @@ func NewProxy(route entity.Route, opts ...Option) (*Proxy, error) {
 		opt:        dst,
 		route:      route,
 		clientsUrl: make([]string, 0, 2),
+		confKey:    fmt.Sprintf("%s#%d", convutil.ToString(route.ID), atomic.AddUint64(&proxySeq, 1)),
+		splits:     NewRegistry(opts...),
 	}
 	if !route.EnableWebsocket {
 		proxy.clients = make([]*fasthttp.HostClient, 0, 2)
@@ -65,10 +90,14 @@ func NewProxy(route entity.Route, opts ...Option) (*Proxy, error) {
 // initialize 初始化proxy
 func (p *Proxy) init() error {
 	upstream := p.route.Upstream
+	if upstream == nil {
+		return fmt.Errorf("missing upstream configuration in route")
+	}
 	var (
-		cert *tls.Certificate
+		cert  *tls.Certificate
+		isTLS = isTLSScheme(upstream.Scheme)
 	)
-	if upstream.TLS != nil {
+	if upstream.TLS != nil && len(upstream.TLS.ClientCert) > 0 {
 		cert1, err := tls.X509KeyPair([]byte(upstream.TLS.ClientCert), []byte(upstream.TLS.ClientKey))
 		if err != nil {
 			return err
@@ -84,12 +113,13 @@ func (p *Proxy) init() error {
 			if err != nil {
 				return err
 			}
-			if cert != nil {
-				client.TLSConfig = &tls.Config{
-					Certificates: []tls.Certificate{*cert},
-				}
+			if isTLS {
+				client.IsTLS = true
+				client.TLSConfig = upstreamTLSConfig(p.opt.tlsConfig, upstream, cert, client.Addr)
 			}
+			p.pools = append(p.pools, configureClient(client, upstream))
 			p.clients = append(p.clients, client)
+			p.hosts = append(p.hosts, client.Addr)
 		}
 	}
 	nodes := upstream.GetNodes()
@@ -97,30 +127,44 @@ func (p *Proxy) init() error {
 		// 负载均衡处理
 		ws := make([]lb.W, len(nodes))
 		p.clientsUrl = make([]string, len(nodes))
-		if !p.route.EnableWebsocket {
+		p.hosts = make([]string, len(nodes))
+		useClients := !p.route.EnableWebsocket && !isGRPCScheme(upstream.Scheme)
+		if isGRPCScheme(upstream.Scheme) {
+			p.grpc = newGRPCTransport(upstream, p.opt.tlsConfig, cert)
+			p.clients = nil
+		}
+		if useClients {
 			p.clients = make([]*fasthttp.HostClient, len(nodes))
+			p.pools = make([]*upstreamPool, len(nodes))
 		}
 		for idx, node := range nodes {
-			ws[idx] = lb.Weight(node.Weight)
+			ws[idx] = lb.Node(fmt.Sprintf("%s:%d", node.Host, node.Port), node.Weight, node.Priority)
 			p.clientsUrl[idx] = fmt.Sprintf("%s:%d", node.Host, node.Port)
-			if !p.route.EnableWebsocket {
+			p.hosts[idx] = nodeHost(node, isTLS)
+			if useClients {
 				client := &fasthttp.HostClient{
 					Addr:                   fmt.Sprintf("%s:%d", node.Host, node.Port),
 					Name:                   _fasthttpHostClientName,
-					IsTLS:                  upstream.TLS != nil,
+					IsTLS:                  isTLS,
 					DisablePathNormalizing: p.opt.disablePathNormalizing,
 				}
-				if cert != nil {
-					client.TLSConfig = &tls.Config{
-						Certificates: []tls.Certificate{*cert},
-					}
+				if isTLS {
+					client.TLSConfig = upstreamTLSConfig(p.opt.tlsConfig, upstream, cert, node.Host)
 				}
-
+				p.pools[idx] = configureClient(client, upstream)
 				p.clients[idx] = client
 			}
 		}
 
 		p.lb = lb.NewBalancer(upstream, ws)
+		p.retry = newRetryPolicy(upstream, len(nodes))
+		p.checker = healthcheck.Acquire("/apisix/routes/"+convutil.ToString(p.route.ID), upstream, nodes)
+		if p.checker != nil {
+			p.lb = lb.WithHealth(p.lb, len(nodes), p.checker.Healthy)
+		}
+		if len(p.pools) > 0 {
+			poolProxies.Store(p, struct{}{})
+		}
 
 		return nil
 	}
@@ -130,32 +174,65 @@ func (p *Proxy) init() error {
 
 // GetClient 获取client
 func (p *Proxy) GetClient(req *fasthttp.Request) (*fasthttp.HostClient, error) {
+	_, c, err := p.pickClient(req, nil)
+	return c, err
+}
+
+// pickClient 通过负载均衡选择client，同时返回其下标，用于上报节点的健康状态
+// 上游为gRPC时没有client，只返回节点的下标
+func (p *Proxy) pickClient(req *fasthttp.Request, vars lb.Vars) (int, *fasthttp.HostClient, error) {
+	if p.grpc != nil {
+		if p.lb != nil {
+			idx := int(p.lb.Distribute(req, vars))
+			if idx < 0 || idx >= len(p.clientsUrl) {
+				return 0, nil, plugins.NewPluginError(fasthttp.StatusServiceUnavailable, errNoAvailableNode)
+			}
+			return idx, nil, nil
+		}
+		return 0, nil, nil
+	}
 	if p.clients == nil || len(p.clients) == 0 {
 		p.log.Error("proxy has been closed", "clientLen", len(p.clients))
-		return nil, fmt.Errorf("client is empty")
+		return 0, nil, fmt.Errorf("client is empty")
 	}
 
 	if p.lb != nil {
-		idx := p.lb.Distribute(req)
-		return p.clients[idx], nil
+		idx := int(p.lb.Distribute(req, vars))
+		if idx < 0 || idx >= len(p.clients) {
+			return 0, nil, plugins.NewPluginError(fasthttp.StatusServiceUnavailable, errNoAvailableNode)
+		}
+		return idx, p.clients[idx], nil
 	}
 
-	return p.clients[0], nil
+	return 0, p.clients[0], nil
+}
+
+// done 将转发的结果反馈给负载均衡，ewma、least_conn等依赖转发的结果选择节点
+func (p *Proxy) done(idx int, latency time.Duration, err error) {
+	if p.lb != nil {
+		p.lb.Done(int64(idx), latency, err)
+	}
 }
 
 // GetWs 获取client
-func (p *Proxy) GetWs(req *fasthttp.Request) (string, error) {
+func (p *Proxy) GetWs(req *fasthttp.Request, vars lb.Vars) (string, error) {
+	_, wsUrl, err := p.pickWs(req, vars)
+	return wsUrl, err
+}
+
+// pickWs 通过负载均衡选择websocket的节点，同时返回其下标
+func (p *Proxy) pickWs(req *fasthttp.Request, vars lb.Vars) (int, string, error) {
 	if p.clientsUrl == nil || len(p.clientsUrl) == 0 {
 		p.log.Error("Proxy has been closed", "clientsUrlLen", len(p.clientsUrl))
-		return "", fmt.Errorf("client is empty")
+		return 0, "", fmt.Errorf("client is empty")
 	}
 
 	if p.lb != nil {
-		idx := p.lb.Distribute(req)
-		return p.clientsUrl[idx], nil
+		idx := int(p.lb.Distribute(req, vars))
+		return idx, p.clientsUrl[idx], nil
 	}
 
-	return p.clientsUrl[0], nil
+	return 0, p.clientsUrl[0], nil
 }
 
 // ServeHTTP 代理服务
@@ -179,6 +256,10 @@ func (p *Proxy) serverHttp(ctx *fasthttp.RequestCtx) {
 	defer fasthttp.ReleaseRequest(req)
 	defer fasthttp.ReleaseResponse(resp)
 	ctx.Request.CopyTo(req)
+	// consumer只能由认证插件设置
+	req.Header.Del(plugins.HeaderConsumerUsername)
+	pctx := plugins.NewContext(ctx, &p.route, req, resp)
+	pctx.Params = router.PathParams(ctx)
 
 	// 设置x-forward-for
 	xForwardFor(ctx, req)
@@ -188,35 +269,42 @@ func (p *Proxy) serverHttp(ctx *fasthttp.RequestCtx) {
 	// 2）读取预处理配置信息
 	uniqueKey := convutil.ToString(p.route.ID)
 	p.log.Info("proxy prepare conf [start]", "id", getId(ctx), "uniqueKey", uniqueKey, "method", string(req.Header.Method()), "uri", string(req.URI().FullURI()))
-	key, err := plugins.PrepareConf(uniqueKey, p.route.Plugins)
+	key, err := plugins.PrepareConf(p.confKey, p.route.Plugins)
 	if err != nil {
 		p.log.Error("plugin prepare conf err", "err", err)
 		p.respToClient(ctx, resp, err)
 		return
 	}
-
-	// 根据route的lb需求进行初始化和调用
-	c, err := p.GetClient(req)
+	chain, err := plugins.NewChain(key)
 	if err != nil {
-		p.log.Error("get client err", "err", err)
+		p.log.Error("plugin new chain err", "err", err)
 		p.respToClient(ctx, resp, err)
 		return
 	}
-	// 先设置目标addr，如果中间插件改写，那么此数据将会变化
-	req.SetHost(c.Addr)
+	// 全局规则先于路由的插件执行
+	globals, err := p.globalChains()
+	if err != nil {
+		p.log.Error("global rule new chain err", "err", err)
+		p.respToClient(ctx, resp, err)
+		return
+	}
+	// log阶段在响应发送之后执行，请求被插件终止时同样执行
+	defer func() {
+		p.runLogPhase(pctx, append(globals, chain)...)
+	}()
 
 	p.log.Info("proxy req call [start]", "id", getId(ctx), "uniqueKey", uniqueKey, "method", string(req.Header.Method()), "uri", string(req.URI().FullURI()))
 	// 【2】请求阶段
-	// 1）读取配置信息
-	// 2）执行请求阶段的插件
-	err = plugins.HTTPReqCall(key, req, resp)
-	if err != nil {
-		p.log.Error("plugin req call err", "err", err)
-		p.respToClient(ctx, resp, err)
+	// rewrite -> access -> before_proxy，每个阶段先执行全局规则再执行路由的插件，
+	// 认证插件在rewrite阶段识别consumer，之后合并consumer上绑定的插件
+	if !p.requestPhase(ctx, pctx, plugins.PhaseRewrite, append(globals, chain)...) {
 		return
 	}
-	if resp.StatusCode() != fasthttp.StatusOK {
-		p.respToClient(ctx, resp, nil)
+	chain = chain.MergeConsumer(pctx)
+	if !p.requestPhase(ctx, pctx, plugins.PhaseAccess, append(globals, chain)...) {
+		return
+	}
+	if !p.requestPhase(ctx, pctx, plugins.PhaseBeforeProxy, append(globals, chain)...) {
 		return
 	}
 
@@ -225,15 +313,21 @@ func (p *Proxy) serverHttp(ctx *fasthttp.RequestCtx) {
 		req.Header.Del(h)
 	}
 
-	p.log.Info("proxy req call [end]", "id", getId(ctx), "uniqueKey", uniqueKey, "method", string(req.Header.Method()), "uri", string(req.URI().FullURI()), "tlsConfig", c.TLSConfig, "clientTlsEmpty", c.TLSConfig == nil, "clientIsTLS", c.IsTLS)
-
-	// execute the request and rev response with timeout
-	if err := p.doWithTimeout(c, req, resp); err != nil {
-		p.log.Error("p.doWithTimeout failed", "err", err, "status", resp.StatusCode())
+	// 插件(如traffic-split)指定了其他上游时，由该上游的Proxy选择节点并转发
+	target, release, err := p.upstreamProxy(pctx.Upstream())
+	if err != nil {
+		p.log.Error("get plugin upstream err", "err", err)
+		p.respToClient(ctx, resp, plugins.NewPluginError(fasthttp.StatusServiceUnavailable, err))
+		return
+	}
+	defer release()
+	err = target.upstreamCall(pctx, req, resp)
+	if err != nil {
+		p.log.Error("p.forward failed", "err", err, "status", resp.StatusCode())
 		resp.SetStatusCode(http.StatusInternalServerError)
 
-		if errors.Is(err, fasthttp.ErrTimeout) {
-			resp.SetStatusCode(http.StatusRequestTimeout)
+		if isTimeout(err) {
+			resp.SetStatusCode(http.StatusGatewayTimeout)
 		}
 		p.respToClient(ctx, resp, err)
 		return
@@ -241,13 +335,18 @@ func (p *Proxy) serverHttp(ctx *fasthttp.RequestCtx) {
 	p.log.Info("proxy resp call [start]", "id", getId(ctx), "uniqueKey", uniqueKey, "method", string(req.Header.Method()), "uri", string(req.URI().FullURI()))
 
 	// 【3】响应阶段
-	// 1）读取配置信息
-	// 2）执行响应阶段的插件
-	err = plugins.HTTPRespCall(key, &ctx.Response)
-	if err != nil {
-		p.log.Error("proxy resp call err", "err", err)
-		p.respToClient(ctx, resp, err)
-		return
+	// header_filter -> body_filter，插件处理的是将要返回给客户端的resp，
+	// 插件返回ActionRespond时跳过后续的插件，直接返回resp
+	for _, phase := range []plugins.Phase{plugins.PhaseHeaderFilter, plugins.PhaseBodyFilter} {
+		action, err := runPhase(phase, pctx, append(globals, chain)...)
+		if err != nil {
+			p.log.Debug("plugin resp call err", "phase", phase, "err", err)
+			p.respToClient(ctx, resp, err)
+			return
+		}
+		if action == plugins.ActionRespond {
+			break
+		}
 	}
 	// deal with response headers
 	p.log.Info("proxy resp call [end]", "id", getId(ctx), "headers", resp.Header.String())
@@ -258,12 +357,58 @@ func (p *Proxy) serverHttp(ctx *fasthttp.RequestCtx) {
 	return
 }
 
+// upstreamCall 选择节点并转发请求，重试其他节点
+func (p *Proxy) upstreamCall(pctx *plugins.Context, req *fasthttp.Request, resp *fasthttp.Response) error {
+	// 与APISIX的balancer阶段一致，在请求阶段之后选择节点，chash可以使用consumer等插件设置的变量
+	idx, c, err := p.pickClient(req, pctx)
+	if err != nil {
+		p.log.Error("get client err", "err", err)
+		return err
+	}
+	// 根据pass_host设置发送给上游的Host，插件改写过的Host不变
+	if host := p.upstreamHost(idx); len(host) > 0 && string(req.Host()) == pctx.Var("host") {
+		req.SetHost(host)
+	}
+	pctx.Set("upstream_addr", p.clientsUrl[idx])
+
+	p.log.Info("proxy req call [end]", "id", getId(pctx.RequestCtx), "uniqueKey", convutil.ToString(p.route.ID), "method", string(req.Header.Method()), "uri", string(req.URI().FullURI()), "upstream", p.clientsUrl[idx], "scheme", p.route.Upstream.Scheme)
+
+	// execute the request and rev response with timeout, retry other nodes on failure
+	if p.grpc != nil {
+		// 上游为gRPC时以一元调用转发，请求通常已被grpc-transcode等插件转换为gRPC
+		return p.forwardGRPC(idx, req, resp)
+	}
+	return p.forward(pctx, idx, c, req, resp)
+}
+
+// requestPhase 执行请求阶段，返回false时已将响应写回客户端
+func (p *Proxy) requestPhase(ctx *fasthttp.RequestCtx, pctx *plugins.Context, phase plugins.Phase, chains ...*plugins.Chain) bool {
+	action, err := runPhase(phase, pctx, chains...)
+	if err != nil {
+		p.log.Debug("plugin req call err", "phase", phase, "err", err)
+		p.respToClient(ctx, pctx.Response, err)
+		return false
+	}
+	if action == plugins.ActionRespond {
+		p.respToClient(ctx, pctx.Response, nil)
+		return false
+	}
+	return true
+}
+
+// respToClient 将resp返回给客户端。
+// err为PluginError时使用其中的状态码、响应头和响应体，其他错误使用resp中已设置的状态码，默认为500
 func (p *Proxy) respToClient(ctx *fasthttp.RequestCtx, resp *fasthttp.Response, err error) {
 	if err != nil {
-		resp.SetBody([]byte(err.Error()))
-		if resp.StatusCode() == 0 || resp.StatusCode() == fasthttp.StatusOK {
-			resp.SetStatusCode(fasthttp.StatusInternalServerError)
+		var pe *plugins.PluginError
+		if !errors.As(err, &pe) {
+			statusCode := resp.StatusCode()
+			if statusCode == 0 || statusCode == fasthttp.StatusOK {
+				statusCode = fasthttp.StatusInternalServerError
+			}
+			pe = plugins.NewPluginError(statusCode, err)
 		}
+		pe.WriteTo(resp)
 	}
 
 	compress(p.compressionLevel)(func(ctx *fasthttp.RequestCtx) {})(ctx)
@@ -295,8 +440,23 @@ func (p *Proxy) Reset() {
 }
 
 // Close ... clear and release
+// 关闭前需要保证没有正在处理的请求
 func (p *Proxy) Close() {
+	poolProxies.Delete(p)
+	for _, c := range p.clients {
+		if c != nil {
+			c.CloseIdleConnections()
+		}
+	}
 	p.clients = nil
+	if p.grpc != nil {
+		p.grpc.CloseIdleConnections()
+	}
+	p.checker.Release()
+	p.splits.Close()
+	// 释放插件配置后，log阶段的插件不能再使用该配置创建状态(如batchProcessor)
+	p.logs.Wait()
+	plugins.DeleteConf(p.confKey)
 	p.opt = nil
 	// p.bla = nil
 	p = nil
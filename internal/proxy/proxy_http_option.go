@@ -4,6 +4,8 @@ import (
 	"crypto/tls"
 	"plugin"
 	"time"
+
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
 )
 
 // Option to define all options to reverse http proxy.
@@ -25,6 +27,10 @@ type buildOption struct {
 	// plugins 插件集合
 	plugins          []plugin.Plugin
 	compressionLevel int
+	// globalRules 全局规则
+	globalRules func() []GlobalRule
+	// upstreams 查找upstream_id对应的上游
+	upstreams func(id string) (*entity.UpstreamDef, error)
 }
 
 type funcBuildOption struct {
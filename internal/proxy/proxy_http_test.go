@@ -0,0 +1,207 @@
+// Package proxy
+//
+// @author: xwc1125
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+func TestProxyTimeout(t *testing.T) {
+	plugins.InitConfCache(time.Minute)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		_, _ = w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+
+	route := entity.Route{
+		BaseInfo: entity.BaseInfo{ID: "timeout"},
+		Upstream: &entity.UpstreamDef{
+			Nodes:   []*entity.Node{testUpstreamNode(t, slow)},
+			Timeout: &entity.Timeout{Connect: 1, Send: 1, Read: 0.1},
+		},
+	}
+	p, err := NewProxy(route)
+	assert.NoError(t, err)
+	defer p.Close()
+	assert.Equal(t, 100*time.Millisecond, p.clients[0].ReadTimeout)
+	assert.Equal(t, time.Second, p.clients[0].WriteTimeout)
+	assert.NotNil(t, p.clients[0].Dial)
+
+	resp := serveTest(p, fasthttp.MethodGet)
+	assert.Equal(t, fasthttp.StatusGatewayTimeout, resp.StatusCode())
+}
+
+func TestProxyKeepalivePool(t *testing.T) {
+	plugins.InitConfCache(time.Minute)
+	var (
+		mu     sync.Mutex
+		conns  = make(map[string]int)
+		closes []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conns[r.RemoteAddr]++
+		if r.Close {
+			closes = append(closes, r.Method)
+		}
+		mu.Unlock()
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	idle := entity.TimeoutValue(30)
+	route := entity.Route{
+		BaseInfo: entity.BaseInfo{ID: "keepalive"},
+		Upstream: &entity.UpstreamDef{
+			Nodes:         []*entity.Node{testUpstreamNode(t, srv)},
+			KeepalivePool: &entity.UpstreamKeepalivePool{IdleTimeout: &idle, Requests: 2, Size: 4},
+		},
+	}
+	p, err := NewProxy(route)
+	assert.NoError(t, err)
+	defer p.Close()
+	assert.Equal(t, 4, p.clients[0].MaxConns)
+	assert.Equal(t, 30*time.Second, p.clients[0].MaxIdleConnDuration)
+
+	// 每个连接的第2个请求带有Connection: close，响应后关闭连接，非幂等的请求只发送一次
+	methods := []string{fasthttp.MethodGet, fasthttp.MethodPost, fasthttp.MethodPost, fasthttp.MethodGet, fasthttp.MethodPost}
+	for _, method := range methods {
+		resp := serveTest(p, method)
+		assert.Equal(t, fasthttp.StatusOK, resp.StatusCode())
+	}
+	mu.Lock()
+	assert.Len(t, conns, 3)
+	for _, n := range conns {
+		assert.LessOrEqual(t, n, 2)
+	}
+	assert.Equal(t, []string{fasthttp.MethodPost, fasthttp.MethodGet}, closes)
+	mu.Unlock()
+
+	var stat *RoutePoolStat
+	stats := PoolStats()
+	for i := range stats {
+		if stats[i].Route == "keepalive" {
+			stat = &stats[i]
+		}
+	}
+	if assert.NotNil(t, stat) && assert.Len(t, stat.Nodes, 1) {
+		node := stat.Nodes[0]
+		assert.Equal(t, srv.Listener.Addr().String(), node.Addr)
+		assert.Equal(t, 4, node.MaxConns)
+		assert.Equal(t, int64(3), node.Dialed)
+		assert.Equal(t, int64(2), node.Recycled)
+		assert.Equal(t, int64(2), node.Closed)
+		assert.Equal(t, 1, node.Conns)
+	}
+}
+
+func TestProxyHTTPSAndPassHost(t *testing.T) {
+	plugins.InitConfCache(time.Minute)
+	var (
+		mu        sync.Mutex
+		host, sni string
+	)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		host, sni = r.Host, r.TLS.ServerName
+		mu.Unlock()
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	node := testUpstreamNode(t, srv)
+
+	tests := []struct {
+		name     string
+		passHost string
+		tls      *entity.UpstreamTLS
+		opts     []Option
+		status   int
+		host     string
+		sni      string
+	}{
+		{name: "pass", tls: &entity.UpstreamTLS{Verify: true}, opts: []Option{WithTLSConfig(&tls.Config{RootCAs: roots})},
+			status: fasthttp.StatusOK, host: "example.com"},
+		{name: "node", passHost: passHostNode, status: fasthttp.StatusOK, host: srv.Listener.Addr().String()},
+		{name: "rewrite", passHost: passHostRewrite, tls: &entity.UpstreamTLS{Verify: true}, opts: []Option{WithTLSConfig(&tls.Config{RootCAs: roots})},
+			status: fasthttp.StatusOK, host: "example.com", sni: "example.com"},
+		// 未信任上游证书的CA时校验失败
+		{name: "verify", tls: &entity.UpstreamTLS{Verify: true}, status: fasthttp.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		host, sni = "", ""
+		mu.Unlock()
+		route := entity.Route{
+			BaseInfo: entity.BaseInfo{ID: "https-" + tt.name},
+			Upstream: &entity.UpstreamDef{
+				Nodes:        []*entity.Node{node},
+				Scheme:       "https",
+				PassHost:     tt.passHost,
+				UpstreamHost: "example.com",
+				TLS:          tt.tls,
+			},
+		}
+		p, err := NewProxy(route, tt.opts...)
+		assert.NoError(t, err)
+		resp := serveTest(p, fasthttp.MethodGet)
+		p.Close()
+		assert.Equal(t, tt.status, resp.StatusCode(), tt.name)
+		if tt.status != fasthttp.StatusOK {
+			continue
+		}
+		mu.Lock()
+		assert.Equal(t, tt.host, host, tt.name)
+		assert.Equal(t, tt.sni, sni, tt.name)
+		mu.Unlock()
+	}
+}
+
+// TestUpstreamPoolTLSConn fasthttp只对没有Handshake方法的连接进行TLS握手，
+// 连接池返回的TLS连接需要实现Handshake，普通连接不能实现，否则https不会握手
+func TestUpstreamPoolTLSConn(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+
+	type handshaker interface{ Handshake() error }
+	for _, isTLS := range []bool{true, false} {
+		client := &fasthttp.HostClient{Addr: addr, IsTLS: isTLS, TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+		pool := newUpstreamPool(client, &entity.UpstreamDef{}, nil, time.Second)
+		conn, err := client.Dial(addr)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		_, ok := conn.(handshaker)
+		assert.Equal(t, isTLS, ok)
+		_ = conn.Close()
+		if !isTLS {
+			continue
+		}
+
+		// 握手只进行一次，连接可以正常处理请求
+		req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.SetRequestURI("https://" + addr + "/")
+		assert.NoError(t, client.Do(req, resp))
+		assert.Equal(t, "ok", string(resp.Body()))
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+		assert.Equal(t, int64(2), pool.dialed.Load())
+	}
+}
@@ -10,12 +10,13 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/chain5j/chain5j-pkg/util/convutil"
 	"github.com/chain5j/logger"
 	"github.com/fasthttp/websocket"
 	"github.com/valyala/fasthttp"
 	plugins2 "github.com/xwc1125/apisix-go/internal/apisix/plugins"
+	"github.com/xwc1125/apisix-go/internal/apisix/router"
 )
 
 var (
@@ -44,6 +45,10 @@ func (p *Proxy) serverWs(ctx *fasthttp.RequestCtx) {
 	defer fasthttp.ReleaseRequest(req)
 	defer fasthttp.ReleaseResponse(resp)
 	ctx.Request.CopyTo(req)
+	// consumer只能由认证插件设置
+	req.Header.Del(plugins2.HeaderConsumerUsername)
+	pctx := plugins2.NewContext(ctx, &p.route, req, resp)
+	pctx.Params = router.PathParams(ctx)
 
 	// 设置x-forward-for
 	xForwardFor(ctx, req)
@@ -52,24 +57,35 @@ func (p *Proxy) serverWs(ctx *fasthttp.RequestCtx) {
 	// 【1】预处理阶段
 	// 1）通过IP和requestURL获取对应的插件配置
 	// 2）读取预处理配置信息
-	uniqueKey := convutil.ToString(p.route.ID)
-	token, err := plugins2.PrepareConf(uniqueKey, p.route.Plugins)
+	token, err := plugins2.PrepareConf(p.confKey, p.route.Plugins)
 	if err != nil {
 		logger.Error("plugin prepare conf err", "err", err)
 		p.respToClient(ctx, resp, err)
 		return
 	}
-	// 【2】请求阶段
-	// 1）读取配置信息
-	// 2）执行请求阶段的插件
-	err = plugins2.HTTPReqCall(token, req, resp)
+	chain, err := plugins2.NewChain(token)
+	if err != nil {
+		logger.Error("plugin new chain err", "err", err)
+		p.respToClient(ctx, resp, err)
+		return
+	}
+	// 全局规则先于路由的插件执行
+	globals, err := p.globalChains()
 	if err != nil {
-		logger.Error("plugin http req call err", "err", err)
+		logger.Error("global rule new chain err", "err", err)
 		p.respToClient(ctx, resp, err)
 		return
 	}
-	if resp.StatusCode() != fasthttp.StatusOK {
-		p.respToClient(ctx, resp, nil)
+	// 【2】请求阶段
+	// rewrite -> access -> before_proxy，认证插件在rewrite阶段识别consumer
+	if !p.requestPhase(ctx, pctx, plugins2.PhaseRewrite, append(globals, chain)...) {
+		return
+	}
+	chain = chain.MergeConsumer(pctx)
+	if !p.requestPhase(ctx, pctx, plugins2.PhaseAccess, append(globals, chain)...) {
+		return
+	}
+	if !p.requestPhase(ctx, pctx, plugins2.PhaseBeforeProxy, append(globals, chain)...) {
 		return
 	}
 
@@ -82,6 +98,9 @@ func (p *Proxy) serverWs(ctx *fasthttp.RequestCtx) {
 		// 删除需要删除的header
 		forwardHeader.Del("Sec-WebSocket-Protocol")
 	}
+	if consumer := pctx.Consumer; consumer != nil {
+		forwardHeader.Set(plugins2.HeaderConsumerUsername, consumer.Username)
+	}
 	fmt.Println("=============forwardHeader===============")
 	for key, val := range forwardHeader {
 		fmt.Println(key, "=", val)
@@ -98,16 +117,19 @@ func (p *Proxy) serverWs(ctx *fasthttp.RequestCtx) {
 	// optional:
 	// http://tools.ietf.org/html/draft-ietf-hybi-websocket-multiplexing-01
 	var scheme = "ws"
-	wsUrl, err := p.GetWs(req)
+	idx, wsUrl, err := p.pickWs(req, pctx)
 	if err != nil {
 		p.log.Error("get client url err", "err", err)
 		p.respToClient(ctx, resp, err)
 		return
 	}
 	targetUrl := fmt.Sprintf("%s://%s", scheme, wsUrl)
-	// dst的conn
+	// dst的conn，握手失败时立即反馈给负载均衡，成功时在连接关闭后反馈
+	begin := time.Now()
 	connBackend, respBackend, err := dialer.Dial(targetUrl+p.route.URI, forwardHeader)
+	latency := time.Since(begin)
 	if err != nil {
+		p.done(idx, latency, err)
 		p.log.Error("websocket proxy: couldn't dial to remote backend", "err", err, "host", targetUrl)
 
 		// logger.Debugf("resp_backent =%v", respBackend)
@@ -135,6 +157,7 @@ func (p *Proxy) serverWs(ctx *fasthttp.RequestCtx) {
 	// Also pass the header that we gathered from the Dial handshake.
 	err = upgrader.Upgrade(ctx, func(connPub *websocket.Conn) {
 		defer connPub.Close()
+		defer connBackend.Close()
 		var (
 			errClient  = make(chan error, 1)
 			errBackend = make(chan error, 1)
@@ -145,23 +168,26 @@ func (p *Proxy) serverWs(ctx *fasthttp.RequestCtx) {
 		go replicateWebsocketConn(p.log, connPub, connBackend, errClient)  // response
 		go replicateWebsocketConn(p.log, connBackend, connPub, errBackend) // request
 
-		for {
-			select {
-			case err = <-errClient:
-				message = "websocketproxy: Error when copying response"
-			case err = <-errBackend:
-				message = "websocketproxy: Error when copying request"
-			}
+		// 任意一端关闭后结束代理，关闭两端的连接
+		select {
+		case err = <-errClient:
+			message = "websocketproxy: Error when copying response"
+		case err = <-errBackend:
+			message = "websocketproxy: Error when copying request"
+		}
 
-			// log error except '*websocket.CloseError'
-			if _, ok := err.(*websocket.CloseError); !ok {
-				p.log.Error(message, "err", err)
-			}
+		// log error except '*websocket.CloseError'
+		if _, ok := err.(*websocket.CloseError); !ok {
+			p.log.Error(message, "err", err)
 		}
+		// 连接关闭后才反馈给负载均衡，least_conn在连接存续期间一直计数
+		p.done(idx, latency, nil)
 	})
 
 	if err != nil {
 		p.log.Error("websocket proxy: couldn't upgrade", "err", err)
+		connBackend.Close()
+		p.done(idx, latency, err)
 		return
 	}
 	return
@@ -245,10 +271,11 @@ func replicateWebsocketConn(log logger.Logger, dst, src *websocket.Conn, errChan
 				msg = websocket.FormatCloseMessage(websocket.CloseAbnormalClosure, err.Error())
 			}
 
-			errChan <- err
-			if err = dst.WriteMessage(websocket.CloseMessage, msg); err != nil {
-				log.Error("write close message failed", "err", err)
+			// 先将close转发给另一端，再通知代理结束并关闭连接
+			if werr := dst.WriteMessage(websocket.CloseMessage, msg); werr != nil {
+				log.Error("write close message failed", "err", werr)
 			}
+			errChan <- err
 			break
 		}
 
@@ -0,0 +1,94 @@
+// Package proxy
+//
+// @author: xwc1125
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/lb"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+// wsDoneBalance 总是选择第一个节点，记录Done的调用
+type wsDoneBalance struct {
+	lb.LoadBalance
+	done chan error
+}
+
+func (wsDoneBalance) Distribute(*fasthttp.Request, lb.Vars) int64 {
+	return 0
+}
+
+func (b wsDoneBalance) Done(_ int64, _ time.Duration, err error) {
+	b.done <- err
+}
+
+func TestProxyWsDoneOnClose(t *testing.T) {
+	plugins.InitConfCache(time.Minute)
+	upgrader := websocket.Upgrader{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			msgType, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			_ = conn.WriteMessage(msgType, msg)
+		}
+	}))
+	defer backend.Close()
+
+	route := entity.Route{
+		BaseInfo:        entity.BaseInfo{ID: "ws"},
+		URI:             "/ws",
+		EnableWebsocket: true,
+		Upstream:        &entity.UpstreamDef{Nodes: []*entity.Node{testUpstreamNode(t, backend)}},
+	}
+	p, err := NewProxy(route)
+	assert.NoError(t, err)
+	defer p.Close()
+	balance := wsDoneBalance{LoadBalance: p.lb, done: make(chan error, 1)}
+	p.lb = balance
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	go func() { _ = fasthttp.Serve(ln, p.ServeHTTP) }()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/ws", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("ping")))
+	_, msg, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(msg))
+	// 连接存续期间不反馈给负载均衡
+	select {
+	case <-balance.done:
+		t.Fatal("done called before the connection is closed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.Close()
+	select {
+	case err := <-balance.done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("done not called after the connection is closed")
+	}
+}
@@ -0,0 +1,144 @@
+// Package proxy
+//
+// @author: xwc1125
+package proxy
+
+import (
+	"sync"
+
+	"github.com/chain5j/logger"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+// Registry 按路由ID缓存Proxy。
+// Proxy只在路由首次被请求或路由发生变更时创建，以便复用HostClient的连接池和负载均衡的状态
+type Registry struct {
+	log  logger.Logger
+	opts []Option
+
+	mu      sync.Mutex
+	entries map[string]*registryEntry
+}
+
+type registryEntry struct {
+	proxy    *Proxy
+	source   *entity.Route  // 创建proxy时使用的路由对象
+	inflight sync.WaitGroup // 正在处理的请求
+}
+
+// release 请求处理完毕
+func (e *registryEntry) release() {
+	e.inflight.Done()
+}
+
+// retire 等待正在处理的请求结束后关闭proxy
+// 调用时entry必须已经从Registry中移除，保证不会再有新的请求
+func (e *registryEntry) retire() {
+	go func() {
+		e.inflight.Wait()
+		e.proxy.Close()
+	}()
+}
+
+// NewRegistry 创建Registry，opts将用于创建每一个Proxy
+func NewRegistry(opts ...Option) *Registry {
+	return &Registry{
+		log:     logger.Log("proxy-registry"),
+		opts:    opts,
+		entries: make(map[string]*registryEntry),
+	}
+}
+
+// Acquire 获取路由对应的Proxy，不存在或路由已变化时重新创建。
+// 请求处理完毕后必须调用返回的release
+func (r *Registry) Acquire(key string, route *entity.Route) (*Proxy, func(), error) {
+	r.mu.Lock()
+	if e, ok := r.entries[key]; ok && e.source == route {
+		e.inflight.Add(1)
+		r.mu.Unlock()
+		return e.proxy, e.release, nil
+	}
+	r.mu.Unlock()
+
+	p, err := NewProxy(*route, r.opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.mu.Lock()
+	if e, ok := r.entries[key]; ok && e.source == route {
+		// 并发创建时，使用先创建的proxy
+		e.inflight.Add(1)
+		r.mu.Unlock()
+		p.Close()
+		return e.proxy, e.release, nil
+	}
+	old := r.entries[key]
+	e := &registryEntry{
+		proxy:  p,
+		source: route,
+	}
+	e.inflight.Add(1)
+	r.entries[key] = e
+	r.mu.Unlock()
+
+	if old != nil {
+		old.retire()
+	}
+	return e.proxy, e.release, nil
+}
+
+// Swap 路由变更时重建已缓存的Proxy，未缓存的路由在首次请求时再创建
+func (r *Registry) Swap(key string, route *entity.Route) {
+	r.mu.Lock()
+	_, ok := r.entries[key]
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	p, err := NewProxy(*route, r.opts...)
+	if err != nil {
+		r.log.Error("rebuild proxy err", "key", key, "err", err)
+		r.Remove(key)
+		return
+	}
+
+	r.mu.Lock()
+	old := r.entries[key]
+	r.entries[key] = &registryEntry{
+		proxy:  p,
+		source: route,
+	}
+	r.mu.Unlock()
+
+	if old != nil {
+		old.retire()
+	}
+	r.log.Debug("proxy swapped", "key", key)
+}
+
+// Remove 移除Proxy，正在处理的请求结束后关闭
+func (r *Registry) Remove(key string) {
+	r.mu.Lock()
+	old, ok := r.entries[key]
+	delete(r.entries, key)
+	r.mu.Unlock()
+
+	if ok {
+		old.retire()
+		r.log.Debug("proxy removed", "key", key)
+	}
+}
+
+// Close 移除所有的Proxy，正在处理的请求结束后关闭
+func (r *Registry) Close() {
+	r.mu.Lock()
+	entries := r.entries
+	r.entries = make(map[string]*registryEntry)
+	r.mu.Unlock()
+
+	for _, e := range entries {
+		e.retire()
+	}
+}
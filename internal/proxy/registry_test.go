@@ -0,0 +1,119 @@
+// Package proxy
+//
+// @author: xwc1125
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+func newTestRoute(id string) *entity.Route {
+	return &entity.Route{
+		BaseInfo: entity.BaseInfo{ID: id},
+		Upstream: &entity.UpstreamDef{
+			Type: "roundrobin",
+			Nodes: []*entity.Node{
+				{Host: "127.0.0.1", Port: 1980, Weight: 1},
+			},
+		},
+	}
+}
+
+func TestRegistryAcquire(t *testing.T) {
+	r := NewRegistry()
+	route := newTestRoute("1")
+
+	p1, release, err := r.Acquire("1", route)
+	assert.NoError(t, err)
+	release()
+	p2, release, err := r.Acquire("1", route)
+	assert.NoError(t, err)
+	release()
+	assert.Same(t, p1, p2)
+
+	// 路由对象变化时重建
+	p3, release, err := r.Acquire("1", newTestRoute("1"))
+	assert.NoError(t, err)
+	release()
+	assert.NotSame(t, p1, p3)
+}
+
+func TestRegistrySwapRemove(t *testing.T) {
+	r := NewRegistry()
+
+	// 未缓存的路由不会被创建
+	r.Swap("1", newTestRoute("1"))
+	assert.Empty(t, r.entries)
+
+	route := newTestRoute("1")
+	p1, release, err := r.Acquire("1", route)
+	assert.NoError(t, err)
+
+	updated := newTestRoute("1")
+	r.Swap("1", updated)
+	p2, release2, err := r.Acquire("1", updated)
+	assert.NoError(t, err)
+	release2()
+	assert.NotSame(t, p1, p2)
+
+	// 旧的proxy在请求结束前仍然可用
+	assert.NotEmpty(t, p1.clients)
+	release()
+
+	r.Remove("1")
+	assert.Empty(t, r.entries)
+}
+
+// slowLogPlugin log阶段较慢的插件，记录执行log时插件配置是否仍在缓存中
+type slowLogPlugin struct {
+	plugins.DefaultPluginV2
+	confAlive chan bool
+}
+
+func (p *slowLogPlugin) Name() string    { return "test-slow-log" }
+func (p *slowLogPlugin) Version() string { return "0.1" }
+func (p *slowLogPlugin) Priority() int64 { return 0 }
+func (p *slowLogPlugin) ParseConf(in []byte) (interface{}, error) {
+	return struct{}{}, nil
+}
+
+func (p *slowLogPlugin) Log(ctx *plugins.Context, conf interface{}) {
+	time.Sleep(100 * time.Millisecond)
+	_, err := plugins.GetRuleConf(ctx.ConfKey())
+	p.confAlive <- err == nil
+}
+
+func TestProxyCloseWaitsLogPhase(t *testing.T) {
+	plugins.InitConfCache(time.Minute)
+	slowLog := &slowLogPlugin{confAlive: make(chan bool, 1)}
+	assert.NoError(t, plugins.RegisterPluginV2(slowLog))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	route := entity.Route{
+		BaseInfo: entity.BaseInfo{ID: "slow-log"},
+		Upstream: &entity.UpstreamDef{Nodes: []*entity.Node{testUpstreamNode(t, srv)}},
+		Plugins:  map[string]interface{}{"test-slow-log": map[string]interface{}{}},
+	}
+	p, err := NewProxy(route)
+	assert.NoError(t, err)
+	resp := serveTest(p, fasthttp.MethodGet)
+	assert.Equal(t, fasthttp.StatusOK, resp.StatusCode())
+
+	// Close等待log阶段结束后才删除插件配置
+	p.Close()
+	select {
+	case alive := <-slowLog.confAlive:
+		assert.True(t, alive)
+	default:
+		t.Fatal("Close returned before the log phase finished")
+	}
+}
@@ -0,0 +1,162 @@
+// Package proxy
+//
+// @author: xwc1125
+package proxy
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/lb"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+const (
+	// HeaderUpstreamAttempts 发生重试时，返回给客户端的每次转发的节点和结果，如：127.0.0.1:1980 502, 127.0.0.1:1981 200
+	HeaderUpstreamAttempts = "X-Upstream-Attempts"
+)
+
+// retryPolicy 上游的重试策略
+type retryPolicy struct {
+	retries  int           // 最多重试的次数
+	timeout  time.Duration // 所有转发的总时间，超过后不再重试，0为不限制
+	statuses map[int]bool  // 上游返回这些状态码时重试
+}
+
+// newRetryPolicy 与APISIX一致，retries为空时每个节点最多转发一次，重试不会选择已经转发过的节点
+func newRetryPolicy(upstream *entity.UpstreamDef, nodes int) retryPolicy {
+	policy := retryPolicy{
+		retries: nodes - 1,
+		timeout: seconds(upstream.RetryTimeout),
+	}
+	if upstream.Retries != nil && *upstream.Retries < policy.retries {
+		policy.retries = *upstream.Retries
+	}
+	if policy.retries < 0 {
+		policy.retries = 0
+	}
+	if len(upstream.RetryStatuses) > 0 {
+		policy.statuses = make(map[int]bool, len(upstream.RetryStatuses))
+		for _, s := range upstream.RetryStatuses {
+			policy.statuses[s] = true
+		}
+	}
+	return policy
+}
+
+// attempt 一次转发的结果
+type attempt struct {
+	addr   string
+	status int
+	err    error
+}
+
+func (a attempt) String() string {
+	if a.err != nil {
+		return a.addr + " error"
+	}
+	return a.addr + " " + strconv.Itoa(a.status)
+}
+
+// forward 将请求转发给上游，连接失败、超时或返回了需要重试的状态码时，换一个节点重试。
+// 非幂等的请求，如POST、PATCH，不会重试
+func (p *Proxy) forward(ctx *plugins.Context, idx int, c *fasthttp.HostClient, req *fasthttp.Request, resp *fasthttp.Response) error {
+	var (
+		start    = time.Now()
+		tried    = map[int]bool{idx: true}
+		attempts = make([]attempt, 0, 1)
+	)
+	for {
+		// 协议由上游的scheme决定，与client一致
+		if c.IsTLS {
+			req.URI().SetScheme("https")
+		} else {
+			req.URI().SetScheme("http")
+		}
+		begin := time.Now()
+		err := p.doWithTimeout(c, req, resp)
+		p.done(idx, time.Since(begin), err)
+		if err != nil {
+			p.checker.ReportError(idx, err)
+		} else {
+			p.checker.ReportStatus(idx, resp.StatusCode())
+		}
+		attempts = append(attempts, attempt{addr: c.Addr, status: resp.StatusCode(), err: err})
+		ctx.Set("upstream_addr", c.Addr)
+
+		if !p.retryable(req, resp, err, len(attempts), start) {
+			p.recordAttempts(resp, attempts)
+			return err
+		}
+		nextIdx, next, ok := p.nextClient(req, ctx, tried)
+		if !ok {
+			p.recordAttempts(resp, attempts)
+			return err
+		}
+		p.log.Warn("proxy retry next upstream", "from", c.Addr, "to", next.Addr, "attempt", len(attempts), "status", resp.StatusCode(), "err", err)
+		// pass_host为node且Host未被插件改写时，使用新节点的Host
+		if p.route.Upstream.PassHost == passHostNode && string(req.Host()) == p.hosts[idx] {
+			req.SetHost(p.hosts[nextIdx])
+		}
+		tried[nextIdx] = true
+		idx, c = nextIdx, next
+		resp.Reset()
+	}
+}
+
+// retryable 是否需要重试
+func (p *Proxy) retryable(req *fasthttp.Request, resp *fasthttp.Response, err error, attempts int, start time.Time) bool {
+	policy := p.retry
+	if attempts > policy.retries {
+		return false
+	}
+	if err == nil && !policy.statuses[resp.StatusCode()] {
+		return false
+	}
+	if policy.timeout > 0 && time.Since(start) >= policy.timeout {
+		return false
+	}
+	return isIdempotent(req)
+}
+
+// nextClient 选择一个未转发过的节点
+func (p *Proxy) nextClient(req *fasthttp.Request, vars lb.Vars, tried map[int]bool) (int, *fasthttp.HostClient, bool) {
+	if p.lb != nil {
+		idx := lb.Next(p.lb, req, vars, len(p.clients), func(idx int) bool {
+			return !tried[idx]
+		})
+		if idx < 0 {
+			return 0, nil, false
+		}
+		return int(idx), p.clients[idx], true
+	}
+	for idx, c := range p.clients {
+		if !tried[idx] {
+			return idx, c, true
+		}
+	}
+	return 0, nil, false
+}
+
+// recordAttempts 记录转发的过程，发生重试时通过响应头返回给客户端
+func (p *Proxy) recordAttempts(resp *fasthttp.Response, attempts []attempt) {
+	if len(attempts) <= 1 {
+		return
+	}
+	list := make([]string, len(attempts))
+	for i, a := range attempts {
+		list[i] = a.String()
+	}
+	value := strings.Join(list, ", ")
+	p.log.Info("proxy upstream attempts", "attempts", value)
+	resp.Header.Set(HeaderUpstreamAttempts, value)
+}
+
+// isIdempotent 请求是否为幂等的，与nginx的proxy_next_upstream一致
+func isIdempotent(req *fasthttp.Request) bool {
+	method := req.Header.Method()
+	return !(string(method) == fasthttp.MethodPost || string(method) == fasthttp.MethodPatch || string(method) == "LOCK")
+}
@@ -0,0 +1,140 @@
+// Package proxy
+//
+// @author: xwc1125
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/lb"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+func testUpstreamNode(t *testing.T, srv *httptest.Server) *entity.Node {
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	assert.NoError(t, err)
+	p, _ := strconv.Atoi(port)
+	return &entity.Node{Host: host, Port: p, Weight: 1}
+}
+
+// serveTest 通过proxy处理一个请求
+func serveTest(p *Proxy, method string) *fasthttp.Response {
+	req := new(fasthttp.Request)
+	req.Header.SetMethod(method)
+	req.SetRequestURI("http://example.com/hello")
+	ctx := new(fasthttp.RequestCtx)
+	ctx.Init(req, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, nil)
+	p.ServeHTTP(ctx)
+	return &ctx.Response
+}
+
+func TestProxyRetry(t *testing.T) {
+	plugins.InitConfCache(time.Minute)
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer good.Close()
+
+	newProxy := func(retries *int, typ ...string) *Proxy {
+		route := entity.Route{
+			BaseInfo: entity.BaseInfo{ID: "retry"},
+			Upstream: &entity.UpstreamDef{
+				Type:          append(typ, "roundrobin")[0],
+				Nodes:         []*entity.Node{testUpstreamNode(t, bad), testUpstreamNode(t, good)},
+				Retries:       retries,
+				RetryStatuses: []int{http.StatusBadGateway},
+			},
+		}
+		p, err := NewProxy(route)
+		assert.NoError(t, err)
+		return p
+	}
+
+	// 第一个节点返回502时重试第二个节点
+	p := newProxy(nil)
+	resp := serveTest(p, fasthttp.MethodGet)
+	assert.Equal(t, fasthttp.StatusOK, resp.StatusCode())
+	assert.Equal(t, "ok", string(resp.Body()))
+	assert.Equal(t, bad.Listener.Addr().String()+" 502, "+good.Listener.Addr().String()+" 200",
+		string(resp.Header.Peek(HeaderUpstreamAttempts)))
+	p.Close()
+
+	// 非幂等的请求不重试
+	p = newProxy(nil)
+	resp = serveTest(p, fasthttp.MethodPost)
+	assert.Equal(t, fasthttp.StatusBadGateway, resp.StatusCode())
+	assert.Empty(t, resp.Header.Peek(HeaderUpstreamAttempts))
+	p.Close()
+
+	// retries为0时不重试
+	zero := 0
+	p = newProxy(&zero)
+	resp = serveTest(p, fasthttp.MethodGet)
+	assert.Equal(t, fasthttp.StatusBadGateway, resp.StatusCode())
+	p.Close()
+
+	// 每次转发的结果都反馈给负载均衡
+	p = newProxy(nil, lb.LeastConn)
+	record := &doneBalance{LoadBalance: p.lb}
+	p.lb = record
+	resp = serveTest(p, fasthttp.MethodGet)
+	assert.Equal(t, fasthttp.StatusOK, resp.StatusCode())
+	assert.Equal(t, []int64{0, 1}, record.done)
+	p.Close()
+}
+
+func TestProxyRetryPriority(t *testing.T) {
+	plugins.InitConfCache(time.Minute)
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer primary.Close()
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("standby"))
+	}))
+	defer standby.Close()
+
+	// 主数据中心的节点优先级高，转发失败后重试备用数据中心的节点
+	standbyNode := testUpstreamNode(t, standby)
+	primaryNode := testUpstreamNode(t, primary)
+	primaryNode.Priority = 1
+	p, err := NewProxy(entity.Route{
+		BaseInfo: entity.BaseInfo{ID: "priority"},
+		Upstream: &entity.UpstreamDef{
+			Type:          "roundrobin",
+			Nodes:         []*entity.Node{standbyNode, primaryNode},
+			RetryStatuses: []int{http.StatusBadGateway},
+		},
+	})
+	assert.NoError(t, err)
+	defer p.Close()
+	for i := 0; i < 3; i++ {
+		resp := serveTest(p, fasthttp.MethodGet)
+		assert.Equal(t, "standby", string(resp.Body()))
+		assert.Equal(t, primary.Listener.Addr().String()+" 502, "+standby.Listener.Addr().String()+" 200",
+			string(resp.Header.Peek(HeaderUpstreamAttempts)))
+	}
+}
+
+// doneBalance 记录Done的调用
+type doneBalance struct {
+	lb.LoadBalance
+	done []int64
+}
+
+func (b *doneBalance) Done(idx int64, latency time.Duration, err error) {
+	b.done = append(b.done, idx)
+	b.LoadBalance.Done(idx, latency, err)
+}
@@ -0,0 +1,103 @@
+// Package proxy
+//
+// @author: xwc1125
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+// pass_host的取值，与APISIX一致
+const (
+	passHostPass    = "pass"    // 保持客户端请求的Host，默认值
+	passHostNode    = "node"    // 使用节点的host
+	passHostRewrite = "rewrite" // 使用upstream_host
+)
+
+// isTLSScheme 上游的协议是否需要TLS
+func isTLSScheme(scheme string) bool {
+	return scheme == "https" || scheme == "grpcs"
+}
+
+// upstreamTLSConfig 连接上游节点的TLS配置，base为全局的配置，如信任的CA。
+// tls.verify为true时校验上游的证书，base未设置RootCAs时使用系统的CA；
+// SNI与发送给上游的Host一致，pass_host为rewrite时为upstream_host，否则为节点的host
+func upstreamTLSConfig(base *tls.Config, upstream *entity.UpstreamDef, cert *tls.Certificate, host string) *tls.Config {
+	var config *tls.Config
+	if base == nil {
+		config = &tls.Config{}
+	} else {
+		config = base.Clone()
+	}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	config.InsecureSkipVerify = upstream.TLS == nil || !upstream.TLS.Verify
+	if upstream.PassHost == passHostRewrite {
+		host = upstream.UpstreamHost
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	config.ServerName = host
+	return config
+}
+
+// nodeHost pass_host为node时发送给节点的Host，非默认端口时带上端口
+func nodeHost(node *entity.Node, isTLS bool) string {
+	if (isTLS && node.Port == 443) || (!isTLS && node.Port == 80) || node.Port == 0 {
+		return node.Host
+	}
+	return net.JoinHostPort(node.Host, strconv.Itoa(node.Port))
+}
+
+// upstreamHost 根据pass_host返回发送给节点idx的Host，pass时返回空，保持客户端请求的Host
+func (p *Proxy) upstreamHost(idx int) string {
+	switch p.route.Upstream.PassHost {
+	case passHostNode:
+		return p.hosts[idx]
+	case passHostRewrite:
+		return p.route.Upstream.UpstreamHost
+	}
+	return ""
+}
+
+// configureClient 根据上游的配置设置client，返回client的连接池
+func configureClient(client *fasthttp.HostClient, upstream *entity.UpstreamDef) *upstreamPool {
+	var (
+		dial    fasthttp.DialFunc
+		connect time.Duration
+	)
+	if timeout := upstream.Timeout; timeout != nil {
+		// connect: 建立连接的超时，send: 发送请求的超时，read: 读取响应的超时
+		if connect = seconds(timeout.Connect); connect > 0 {
+			dial = func(addr string) (net.Conn, error) {
+				return fasthttp.DialTimeout(addr, connect)
+			}
+		}
+		client.WriteTimeout = seconds(timeout.Send)
+		client.ReadTimeout = seconds(timeout.Read)
+	}
+	return newUpstreamPool(client, upstream, dial, connect)
+}
+
+// seconds 将以秒为单位的配置转换为time.Duration，精确到毫秒
+func seconds(v entity.TimeoutValue) time.Duration {
+	return time.Duration(math.Round(float64(v)*1000)) * time.Millisecond
+}
+
+// isTimeout 是否为连接、发送或读取超时
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, fasthttp.ErrTimeout) ||
+		errors.Is(err, fasthttp.ErrDialTimeout) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
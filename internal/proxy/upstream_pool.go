@@ -0,0 +1,242 @@
+// Package proxy
+//
+// @author: xwc1125
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chain5j/chain5j-pkg/util/convutil"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+)
+
+// keepalive_pool的默认值，与APISIX一致
+const (
+	defaultPoolSize        = 320
+	defaultPoolIdleTimeout = 60 * time.Second
+	defaultPoolRequests    = 1000
+)
+
+var (
+	poolProxies sync.Map // 正在使用的proxy，用于导出连接池的状态。*Proxy -> struct{}
+)
+
+// upstreamPool 一个上游节点的连接池，统计连接的数量，并在连接处理的请求数达到requests后回收连接
+type upstreamPool struct {
+	client      *fasthttp.HostClient
+	maxRequests int // 每个连接最多处理的请求数，0为不限制
+
+	dialed   atomic.Int64 // 建立的连接数
+	closed   atomic.Int64 // 关闭的连接数
+	recycled atomic.Int64 // 达到requests后回收的连接数
+}
+
+// newUpstreamPool 根据keepalive_pool设置client的连接池，未配置时使用fasthttp的默认值
+func newUpstreamPool(client *fasthttp.HostClient, upstream *entity.UpstreamDef, dial fasthttp.DialFunc, connectTimeout time.Duration) *upstreamPool {
+	pool := &upstreamPool{client: client}
+	if keepalive := upstream.KeepalivePool; keepalive != nil {
+		client.MaxConns = keepalive.Size
+		if client.MaxConns <= 0 {
+			client.MaxConns = defaultPoolSize
+		}
+		client.MaxIdleConnDuration = defaultPoolIdleTimeout
+		if keepalive.IdleTimeout != nil {
+			client.MaxIdleConnDuration = seconds(*keepalive.IdleTimeout)
+		}
+		pool.maxRequests = keepalive.Requests
+		if pool.maxRequests <= 0 {
+			pool.maxRequests = defaultPoolRequests
+		}
+		// 连接数达到上限时等待空闲的连接，而不是直接返回ErrNoFreeConns
+		client.MaxConnWaitTimeout = connectTimeout
+		if client.MaxConnWaitTimeout <= 0 {
+			client.MaxConnWaitTimeout = defaultPoolIdleTimeout
+		}
+	}
+	if dial == nil {
+		dial = client.Dial
+	}
+	client.Dial = pool.dial(dial, connectTimeout)
+	return pool
+}
+
+// dial 建立连接，TLS握手在此完成，以便只统计握手之后的请求
+func (p *upstreamPool) dial(dial fasthttp.DialFunc, connectTimeout time.Duration) fasthttp.DialFunc {
+	if dial == nil {
+		dial = fasthttp.Dial
+	}
+	return func(addr string) (net.Conn, error) {
+		conn, err := dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		if !p.client.IsTLS {
+			p.dialed.Add(1)
+			return &poolConn{Conn: conn, pool: p}, nil
+		}
+		tlsConn := tls.Client(conn, p.tlsConfig(addr))
+		if connectTimeout > 0 {
+			_ = conn.SetDeadline(time.Now().Add(connectTimeout))
+		}
+		if err = tlsConn.Handshake(); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		p.dialed.Add(1)
+		return &poolTLSConn{poolConn: &poolConn{Conn: tlsConn, pool: p}, tls: tlsConn}, nil
+	}
+}
+
+// tlsConfig 与fasthttp一致，未指定ServerName时使用节点的地址
+func (p *upstreamPool) tlsConfig(addr string) *tls.Config {
+	var config *tls.Config
+	if p.client.TLSConfig == nil {
+		config = &tls.Config{}
+	} else {
+		config = p.client.TLSConfig.Clone()
+	}
+	if len(config.ServerName) == 0 && !config.InsecureSkipVerify {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		config.ServerName = host
+	}
+	return config
+}
+
+// poolConn 统计连接处理的请求数。
+// 读取到响应后的第一次写入为一个新的请求，请求数达到上限时在该请求中添加"Connection: close"，
+// 由上游和fasthttp在读取响应后关闭连接，请求不会因回收连接而重试
+type poolConn struct {
+	net.Conn
+	pool     *upstreamPool
+	requests int
+	read     bool
+	closed   atomic.Bool
+}
+
+// connectionClose 插入在请求行之后的请求头
+var connectionClose = []byte("Connection: close\r\n")
+
+func (c *poolConn) Write(b []byte) (int, error) {
+	if c.requests == 0 || c.read {
+		c.read = false
+		c.requests++
+		if c.pool.maxRequests > 0 && c.requests >= c.pool.maxRequests {
+			c.pool.recycled.Add(1)
+			return c.writeConnectionClose(b)
+		}
+	}
+	return c.Conn.Write(b)
+}
+
+// writeConnectionClose 在请求行之后添加"Connection: close"。
+// fasthttp一次写入完整的请求头，因此请求的第一次写入中包含完整的请求行
+func (c *poolConn) writeConnectionClose(b []byte) (int, error) {
+	idx := bytes.Index(b, []byte("\r\n"))
+	if idx < 0 {
+		return c.Conn.Write(b)
+	}
+	buf := make([]byte, 0, len(b)+len(connectionClose))
+	buf = append(buf, b[:idx+2]...)
+	buf = append(buf, connectionClose...)
+	buf = append(buf, b[idx+2:]...)
+	n, err := c.Conn.Write(buf)
+	// 返回原始数据中已写入的长度
+	switch end := idx + 2; {
+	case n <= end:
+		return n, err
+	case n <= end+len(connectionClose):
+		return end, err
+	}
+	return n - len(connectionClose), err
+}
+
+func (c *poolConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.read = true
+	}
+	return n, err
+}
+
+func (c *poolConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		c.pool.closed.Add(1)
+	}
+	return c.Conn.Close()
+}
+
+// poolTLSConn 已完成握手的TLS连接，Handshake等方法转发给*tls.Conn。
+// fasthttp将实现了Handshake的连接视为TLS连接，不会再次包装
+type poolTLSConn struct {
+	*poolConn
+	tls *tls.Conn
+}
+
+func (c *poolTLSConn) Handshake() error {
+	return c.tls.Handshake()
+}
+
+func (c *poolTLSConn) ConnectionState() tls.ConnectionState {
+	return c.tls.ConnectionState()
+}
+
+// PoolStat 上游节点连接池的状态
+type PoolStat struct {
+	Addr     string `json:"addr"`
+	MaxConns int    `json:"max_conns"` // 最大连接数，0为fasthttp的默认值
+	Conns    int    `json:"conns"`     // 当前的连接数
+	Pending  int    `json:"pending"`   // 正在处理的请求数
+	Dialed   int64  `json:"dialed"`    // 累计建立的连接数
+	Closed   int64  `json:"closed"`    // 累计关闭的连接数
+	Recycled int64  `json:"recycled"`  // 达到requests后回收的连接数
+}
+
+// RoutePoolStat 路由的上游连接池状态
+type RoutePoolStat struct {
+	Route string     `json:"route"`
+	Nodes []PoolStat `json:"nodes"`
+}
+
+func (p *upstreamPool) stat() PoolStat {
+	return PoolStat{
+		Addr:     p.client.Addr,
+		MaxConns: p.client.MaxConns,
+		Conns:    p.client.ConnsCount(),
+		Pending:  p.client.PendingRequests(),
+		Dialed:   p.dialed.Load(),
+		Closed:   p.closed.Load(),
+		Recycled: p.recycled.Load(),
+	}
+}
+
+// PoolStats 所有路由的上游连接池状态，按路由排序
+func PoolStats() []RoutePoolStat {
+	stats := make([]RoutePoolStat, 0)
+	poolProxies.Range(func(key, _ interface{}) bool {
+		p := key.(*Proxy)
+		stat := RoutePoolStat{
+			Route: convutil.ToString(p.route.ID),
+			Nodes: make([]PoolStat, len(p.pools)),
+		}
+		for i, pool := range p.pools {
+			stat.Nodes[i] = pool.stat()
+		}
+		stats = append(stats, stat)
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Route < stats[j].Route
+	})
+	return stats
+}
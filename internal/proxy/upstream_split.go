@@ -0,0 +1,57 @@
+// Package proxy
+//
+// @author: xwc1125
+package proxy
+
+import (
+	"fmt"
+
+	"github.com/chain5j/chain5j-pkg/util/convutil"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+// WithUpstreams 设置upstream_id对应上游的查找方法，插件通过upstream_id指定上游时使用
+func WithUpstreams(get func(id string) (*entity.UpstreamDef, error)) Option {
+	return newFuncBuildOption(func(o *buildOption) {
+		o.upstreams = get
+	})
+}
+
+// upstreamProxy 获取插件指定的上游对应的Proxy，插件未指定时返回p。
+// 相同key且上游未变化时复用Proxy，以便复用连接池和负载均衡的状态，请求处理完毕后必须调用返回的release
+func (p *Proxy) upstreamProxy(u *plugins.Upstream) (*Proxy, func(), error) {
+	if u == nil || (u.Upstream == nil && len(u.ID) == 0) {
+		return p, func() {}, nil
+	}
+	upstream := u.Upstream
+	key := u.Key
+	if upstream == nil {
+		if p.opt.upstreams == nil {
+			return nil, nil, fmt.Errorf("upstream_id %s is not supported", u.ID)
+		}
+		var err error
+		if upstream, err = p.opt.upstreams(u.ID); err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch upstream [%s]: %w", u.ID, err)
+		}
+		if len(key) == 0 {
+			key = "upstream/" + u.ID
+		}
+	}
+	return p.splits.Acquire(key, p.splitRoute(key, upstream))
+}
+
+// splitRoute 创建上游的Proxy使用的路由，上游未变化时返回同一个路由对象。
+// 路由的插件已经由p执行，因此不包含插件
+func (p *Proxy) splitRoute(key string, upstream *entity.UpstreamDef) *entity.Route {
+	if v, ok := p.splitRoutes.Load(key); ok && v.(*entity.Route).Upstream == upstream {
+		return v.(*entity.Route)
+	}
+	route := p.route
+	route.ID = convutil.ToString(p.route.ID) + "/" + key
+	route.Upstream = upstream
+	route.UpstreamID = nil
+	route.Plugins = nil
+	p.splitRoutes.Store(key, &route)
+	return &route
+}
@@ -0,0 +1,121 @@
+// Package proxy
+//
+// @author: xwc1125
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+func TestProxyTrafficSplit(t *testing.T) {
+	plugins.InitConfCache(time.Minute)
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+	blue, green, canary := newBackend("blue"), newBackend("green"), newBackend("canary")
+	defer blue.Close()
+	defer green.Close()
+	defer canary.Close()
+
+	canaryUpstream := &entity.UpstreamDef{Nodes: []*entity.Node{testUpstreamNode(t, canary)}}
+	upstreams := func(id string) (*entity.UpstreamDef, error) {
+		if id == "canary" {
+			return canaryUpstream, nil
+		}
+		return nil, errors.New("not found")
+	}
+	route := entity.Route{
+		BaseInfo: entity.BaseInfo{ID: "split"},
+		Plugins: map[string]interface{}{
+			"traffic-split": map[string]interface{}{
+				"rules": []interface{}{
+					map[string]interface{}{
+						"match": []interface{}{
+							map[string]interface{}{"vars": []interface{}{[]interface{}{"arg_name", "==", "jack"}}},
+							map[string]interface{}{"vars": []interface{}{[]interface{}{"http_x-release", "~~", "^green"}}},
+						},
+						"weighted_upstreams": []interface{}{
+							map[string]interface{}{
+								"upstream": map[string]interface{}{
+									"type":  "roundrobin",
+									"nodes": []interface{}{map[string]interface{}{"host": "127.0.0.1", "port": float64(green.Listener.Addr().(*net.TCPAddr).Port), "weight": float64(1)}},
+								},
+							},
+						},
+					},
+					map[string]interface{}{
+						"match": []interface{}{
+							map[string]interface{}{"vars": []interface{}{[]interface{}{"arg_name", "==", "missing"}}},
+						},
+						"weighted_upstreams": []interface{}{
+							map[string]interface{}{"upstream_id": "missing"},
+						},
+					},
+					map[string]interface{}{
+						"weighted_upstreams": []interface{}{
+							map[string]interface{}{"upstream_id": "canary", "weight": 1},
+							map[string]interface{}{"weight": 3},
+						},
+					},
+				},
+			},
+		},
+		Upstream: &entity.UpstreamDef{Nodes: []*entity.Node{testUpstreamNode(t, blue)}},
+	}
+	p, err := NewProxy(route, WithUpstreams(upstreams))
+	assert.NoError(t, err)
+	defer p.Close()
+
+	serve := func(uri string, header ...string) *fasthttp.Response {
+		req := new(fasthttp.Request)
+		req.SetRequestURI(uri)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		ctx := new(fasthttp.RequestCtx)
+		ctx.Init(req, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, nil)
+		p.ServeHTTP(ctx)
+		return &ctx.Response
+	}
+	body := func(uri string, header ...string) string {
+		return string(serve(uri, header...).Body())
+	}
+
+	// 匹配的请求转发给插件配置中的上游
+	assert.Equal(t, "green", body("http://example.com/hello?name=jack"))
+	assert.Equal(t, "green", body("http://example.com/hello", "X-Release", "green-1"))
+
+	// 未匹配的请求按权重在upstream_id和路由的上游之间选择
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		counts[body("http://example.com/hello?name=rose")]++
+	}
+	assert.Equal(t, map[string]int{"canary": 2, "blue": 6}, counts)
+
+	// 上游的Proxy在请求之间复用，上游变化时重建
+	assert.Len(t, p.splits.entries, 2)
+	green1 := p.splits.entries["traffic-split/0/0"].proxy
+	serve("http://example.com/hello?name=jack")
+	assert.Same(t, green1, p.splits.entries["traffic-split/0/0"].proxy)
+	canary1 := p.splits.entries["upstream/canary"].proxy
+	canaryUpstream = &entity.UpstreamDef{Nodes: []*entity.Node{testUpstreamNode(t, canary)}}
+	for i := 0; i < 4; i++ {
+		serve("http://example.com/hello")
+	}
+	assert.NotSame(t, canary1, p.splits.entries["upstream/canary"].proxy)
+
+	// upstream_id不存在时返回503
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, serve("http://example.com/hello?name=missing").StatusCode())
+}
@@ -0,0 +1,506 @@
+// Package server
+//
+// @author: xwc1125
+package serve
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/chain5j/chain5j-pkg/util/convutil"
+	"github.com/chain5j/logger"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/store"
+	"github.com/xwc1125/apisix-go/internal/apisix/utils/uuid"
+	"github.com/xwc1125/apisix-go/internal/models"
+)
+
+const (
+	adminPrefix = "/apisix/admin/"
+
+	adminRoleAdmin  = "admin"
+	adminRoleViewer = "viewer"
+)
+
+// adminDefaultKeys APISIX公开的默认key，任何人都可以使用，不允许配置
+var adminDefaultKeys = map[string]bool{
+	"edd1c9f034335f136f87ad84b625c8f1": true,
+	"4054f7cf07e344346cd3f287985e76a2": true,
+}
+
+// adminDefaults 与APISIX的schema一致，写入前为未配置的字段设置默认值
+var adminDefaults = map[store.HubKey]map[string]interface{}{
+	store.HubKeyRoute: {"status": entity.Status(1)},
+}
+
+// adminResources Admin API的资源与store的对应关系
+var adminResources = map[string]store.HubKey{
+	"routes":         store.HubKeyRoute,
+	"services":       store.HubKeyService,
+	"upstreams":      store.HubKeyUpstream,
+	"consumers":      store.HubKeyConsumer,
+	"ssls":           store.HubKeySsl,
+	"ssl":            store.HubKeySsl, // 兼容APISIX v2的路径
+	"global_rules":   store.HubKeyGlobalRule,
+	"plugin_configs": store.HubKeyPluginConfig,
+	"protos":         store.HubKeyProto,
+}
+
+// AdminServe 与APISIX兼容的Admin API，通过store读写etcd中的配置
+//
+//	GET    /apisix/admin/{resource}               列表，支持page、page_size分页
+//	GET    /apisix/admin/{resource}/{id}          查询
+//	PUT    /apisix/admin/{resource}[/{id}]        创建或替换，未指定id时使用body中的id
+//	POST   /apisix/admin/{resource}               创建并生成id，consumers不支持
+//	PATCH  /apisix/admin/{resource}/{id}[/{path}] 合并更新，指定path时替换该路径的值
+//	DELETE /apisix/admin/{resource}/{id}          删除，仍被引用的upstream、service、plugin_config不能删除
+//
+// 请求需要在X-API-KEY请求头或者api_key参数中携带admin_key，viewer只能执行GET
+type AdminServe struct {
+	log      logger.Logger
+	keys     []models.AdminKey
+	getStore func(hubKey store.HubKey) *store.GenericStore
+}
+
+// adminRequest 解析后的Admin API请求
+type adminRequest struct {
+	hubKey  store.HubKey
+	store   *store.GenericStore
+	id      string
+	subPath string // PATCH时需要替换的路径，如：plugins/limit-count
+}
+
+// adminItem 与APISIX一致的返回格式
+type adminItem struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+type adminList struct {
+	Total int          `json:"total"`
+	List  []*adminItem `json:"list"`
+}
+
+type adminDeleted struct {
+	Deleted string `json:"deleted"`
+	Key     string `json:"key"`
+}
+
+// NewAdminServe 创建Admin API，至少需要配置一个admin_key，不能使用APISIX的默认key
+func NewAdminServe(config models.AdminConfig) (*AdminServe, error) {
+	if len(config.AdminKey) == 0 {
+		return nil, fmt.Errorf("admin_key is required")
+	}
+	for _, key := range config.AdminKey {
+		if len(key.Key) == 0 {
+			return nil, fmt.Errorf("admin_key %s: key is empty", key.Name)
+		}
+		if adminDefaultKeys[key.Key] {
+			return nil, fmt.Errorf("admin_key %s: the default key of APISIX is public, please change it", key.Name)
+		}
+		if key.Role != adminRoleAdmin && key.Role != adminRoleViewer {
+			return nil, fmt.Errorf("admin_key %s: invalid role %s", key.Name, key.Role)
+		}
+	}
+	return &AdminServe{
+		log:      logger.Log("admin"),
+		keys:     config.AdminKey,
+		getStore: store.GetStore,
+	}, nil
+}
+
+// Handler 处理Admin API的请求
+func (a *AdminServe) Handler(ctx *fasthttp.RequestCtx) {
+	role, ok := a.auth(ctx)
+	if !ok {
+		adminError(ctx, fasthttp.StatusUnauthorized, "failed to check token")
+		return
+	}
+	if role == adminRoleViewer && !ctx.IsGet() {
+		adminError(ctx, fasthttp.StatusForbidden, "invalid method for role viewer")
+		return
+	}
+	req, ok := a.parse(string(ctx.Path()))
+	if !ok {
+		adminError(ctx, fasthttp.StatusNotFound, "not found")
+		return
+	}
+
+	method := string(ctx.Method())
+	if len(req.subPath) > 0 && method != fasthttp.MethodPatch {
+		adminError(ctx, fasthttp.StatusNotFound, "not found")
+		return
+	}
+	switch {
+	case method == fasthttp.MethodGet && len(req.id) == 0:
+		a.list(ctx, req)
+	case method == fasthttp.MethodGet:
+		a.get(ctx, req)
+	case method == fasthttp.MethodPut:
+		a.put(ctx, req)
+	case method == fasthttp.MethodPost && len(req.id) == 0 && req.hubKey != store.HubKeyConsumer:
+		a.post(ctx, req)
+	case method == fasthttp.MethodPatch && len(req.id) > 0:
+		a.patch(ctx, req)
+	case method == fasthttp.MethodDelete && len(req.id) > 0:
+		a.delete(ctx, req)
+	default:
+		adminError(ctx, fasthttp.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// auth 校验admin_key，返回对应的角色
+func (a *AdminServe) auth(ctx *fasthttp.RequestCtx) (string, bool) {
+	token := ctx.Request.Header.Peek("X-API-KEY")
+	if len(token) == 0 {
+		token = ctx.QueryArgs().Peek("api_key")
+	}
+	if len(token) == 0 {
+		return "", false
+	}
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare(token, []byte(key.Key)) == 1 {
+			return key.Role, true
+		}
+	}
+	return "", false
+}
+
+// parse 解析路径：/apisix/admin/{resource}[/{id}[/{path}]]
+func (a *AdminServe) parse(path string) (*adminRequest, bool) {
+	if !strings.HasPrefix(path, adminPrefix) {
+		return nil, false
+	}
+	parts := strings.SplitN(strings.TrimSuffix(path[len(adminPrefix):], "/"), "/", 3)
+	hubKey, ok := adminResources[parts[0]]
+	if !ok {
+		return nil, false
+	}
+	req := &adminRequest{
+		hubKey: hubKey,
+		store:  a.getStore(hubKey),
+	}
+	if len(parts) > 1 {
+		req.id = parts[1]
+	}
+	if len(parts) > 2 {
+		req.subPath = parts[2]
+	}
+	return req, true
+}
+
+func (a *AdminServe) list(ctx *fasthttp.RequestCtx, req *adminRequest) {
+	input := store.ListInput{}
+	args := ctx.QueryArgs()
+	for name, value := range map[string]*int{"page": &input.PageNumber, "page_size": &input.PageSize} {
+		if !args.Has(name) {
+			continue
+		}
+		n, err := args.GetUint(name)
+		if err != nil {
+			adminError(ctx, fasthttp.StatusBadRequest, "invalid "+name)
+			return
+		}
+		*value = n
+	}
+	if req.hubKey == store.HubKeyConsumer {
+		input.Less = func(i, j interface{}) bool {
+			return i.(*entity.Consumer).Username < j.(*entity.Consumer).Username
+		}
+	}
+	output, err := req.store.List(ctx, input)
+	if err != nil {
+		adminError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	result := &adminList{
+		Total: output.TotalSize,
+		List:  make([]*adminItem, 0, len(output.Rows)),
+	}
+	for _, row := range output.Rows {
+		result.List = append(result.List, &adminItem{
+			Key:   req.store.GetObjStorageKey(row),
+			Value: row,
+		})
+	}
+	writeJSON(ctx, result)
+}
+
+func (a *AdminServe) get(ctx *fasthttp.RequestCtx, req *adminRequest) {
+	obj, err := req.store.Get(ctx, req.id)
+	if err != nil {
+		adminError(ctx, fasthttp.StatusNotFound, "Key not found")
+		return
+	}
+	writeJSON(ctx, &adminItem{
+		Key:   req.store.GetObjStorageKey(obj),
+		Value: obj,
+	})
+}
+
+// put 创建或替换，新建时返回201
+func (a *AdminServe) put(ctx *fasthttp.RequestCtx, req *adminRequest) {
+	obj, err := decodeAdminBody(req, ctx.PostBody(), req.id)
+	if err != nil {
+		adminError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	id := req.id
+	if len(id) == 0 {
+		id = adminObjID(obj)
+	}
+	if len(id) == 0 {
+		adminError(ctx, fasthttp.StatusBadRequest, "missing id")
+		return
+	}
+	setAdminObjID(obj, id)
+
+	_, err = req.store.Get(ctx, id)
+	exists := err == nil
+	if exists {
+		a.save(ctx, req, obj, fasthttp.StatusOK, func() (interface{}, error) {
+			return req.store.Update(ctx, obj, false)
+		})
+		return
+	}
+	a.save(ctx, req, obj, fasthttp.StatusCreated, func() (interface{}, error) {
+		return req.store.Create(ctx, obj)
+	})
+}
+
+// post 创建并生成id
+func (a *AdminServe) post(ctx *fasthttp.RequestCtx, req *adminRequest) {
+	obj, err := decodeAdminBody(req, ctx.PostBody(), "")
+	if err != nil {
+		adminError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	setAdminObjID(obj, uuid.GetFlakeUidStr())
+	a.save(ctx, req, obj, fasthttp.StatusCreated, func() (interface{}, error) {
+		return req.store.Create(ctx, obj)
+	})
+}
+
+// patch 按JSON Merge Patch(RFC 7386)合并，或者替换subPath对应的值，值为null时删除
+func (a *AdminServe) patch(ctx *fasthttp.RequestCtx, req *adminRequest) {
+	stored, err := req.store.Get(ctx, req.id)
+	if err != nil {
+		adminError(ctx, fasthttp.StatusNotFound, "Key not found")
+		return
+	}
+	var patch interface{}
+	if err := json.Unmarshal(ctx.PostBody(), &patch); err != nil {
+		adminError(ctx, fasthttp.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		adminError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		adminError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(req.subPath) > 0 {
+		setJSONPath(doc, strings.Split(req.subPath, "/"), patch)
+	} else {
+		patchMap, ok := patch.(map[string]interface{})
+		if !ok {
+			adminError(ctx, fasthttp.StatusBadRequest, "invalid request body: must be an object")
+			return
+		}
+		mergePatch(doc, patchMap)
+	}
+
+	data, err = json.Marshal(doc)
+	if err != nil {
+		adminError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	obj, err := decodeAdminBody(req, data, req.id)
+	if err != nil {
+		adminError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	setAdminObjID(obj, req.id)
+	a.save(ctx, req, obj, fasthttp.StatusOK, func() (interface{}, error) {
+		return req.store.Update(ctx, obj, false)
+	})
+}
+
+// save 校验后写入store，校验失败返回400
+func (a *AdminServe) save(ctx *fasthttp.RequestCtx, req *adminRequest, obj interface{}, status int, write func() (interface{}, error)) {
+	if err := req.store.Validate(obj); err != nil {
+		adminError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	ret, err := write()
+	if err != nil {
+		a.log.Error("admin write err", "hubKey", req.hubKey, "err", err)
+		adminError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.SetStatusCode(status)
+	writeJSON(ctx, &adminItem{
+		Key:   req.store.GetObjStorageKey(ret),
+		Value: ret,
+	})
+}
+
+func (a *AdminServe) delete(ctx *fasthttp.RequestCtx, req *adminRequest) {
+	obj, err := req.store.Get(ctx, req.id)
+	if err != nil {
+		adminError(ctx, fasthttp.StatusNotFound, "Key not found")
+		return
+	}
+	if err := a.checkReferences(ctx, req); err != nil {
+		adminError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	if err := req.store.BatchDelete(ctx, []string{req.id}); err != nil {
+		a.log.Error("admin delete err", "hubKey", req.hubKey, "id", req.id, "err", err)
+		adminError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(ctx, &adminDeleted{
+		Deleted: req.id,
+		Key:     req.store.GetObjStorageKey(obj),
+	})
+}
+
+// checkReferences 与APISIX一致，被route或service引用的对象不能删除
+func (a *AdminServe) checkReferences(ctx context.Context, req *adminRequest) error {
+	type reference struct {
+		hubKey store.HubKey
+		refID  func(obj interface{}) interface{}
+	}
+	var refs []reference
+	switch req.hubKey {
+	case store.HubKeyUpstream:
+		refs = []reference{
+			{store.HubKeyRoute, func(obj interface{}) interface{} { return obj.(*entity.Route).UpstreamID }},
+			{store.HubKeyService, func(obj interface{}) interface{} { return obj.(*entity.Service).UpstreamID }},
+		}
+	case store.HubKeyService:
+		refs = []reference{
+			{store.HubKeyRoute, func(obj interface{}) interface{} { return obj.(*entity.Route).ServiceID }},
+		}
+	case store.HubKeyPluginConfig:
+		refs = []reference{
+			{store.HubKeyRoute, func(obj interface{}) interface{} { return obj.(*entity.Route).PluginConfigID }},
+		}
+	}
+	for _, ref := range refs {
+		var using string
+		a.getStore(ref.hubKey).Range(ctx, func(key string, obj interface{}) bool {
+			if id := ref.refID(obj); id != nil && convutil.ToString(id) == req.id {
+				using = key
+				return false
+			}
+			return true
+		})
+		if len(using) > 0 {
+			return fmt.Errorf("can not delete this %s, %s [%s] is still using it now", req.hubKey, ref.hubKey, using)
+		}
+	}
+	return nil
+}
+
+// decodeAdminBody 将请求体解析为store对应的对象，未配置的字段使用adminDefaults中的默认值
+func decodeAdminBody(req *adminRequest, body []byte, id string) (interface{}, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(body, &m); err != nil || m == nil {
+		return nil, fmt.Errorf("invalid request body: must be a JSON object")
+	}
+	missing := false
+	for field, value := range adminDefaults[req.hubKey] {
+		if _, ok := m[field]; !ok {
+			m[field] = value
+			missing = true
+		}
+	}
+	if missing {
+		var err error
+		if body, err = json.Marshal(m); err != nil {
+			return nil, err
+		}
+	}
+	obj, err := req.store.StringToObjPtr(string(body), id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request body: %s", err)
+	}
+	return obj, nil
+}
+
+// adminObjID 对象的id，consumer使用username
+func adminObjID(obj interface{}) string {
+	switch o := obj.(type) {
+	case *entity.Consumer:
+		return o.Username
+	case entity.GetBaseInfo:
+		if id := o.GetBaseInfo().ID; id != nil {
+			return convutil.ToString(id)
+		}
+	}
+	return ""
+}
+
+func setAdminObjID(obj interface{}, id string) {
+	switch o := obj.(type) {
+	case *entity.Consumer:
+		o.Username = id
+	case entity.GetBaseInfo:
+		o.GetBaseInfo().ID = id
+	}
+}
+
+// mergePatch 将patch合并到doc中，值为null时删除对应的字段
+func mergePatch(doc, patch map[string]interface{}) {
+	for k, v := range patch {
+		if v == nil {
+			delete(doc, k)
+			continue
+		}
+		pm, ok := v.(map[string]interface{})
+		if !ok {
+			doc[k] = v
+			continue
+		}
+		dm, ok := doc[k].(map[string]interface{})
+		if !ok {
+			dm = make(map[string]interface{})
+			doc[k] = dm
+		}
+		mergePatch(dm, pm)
+	}
+}
+
+// setJSONPath 替换path对应的值，中间不存在的对象会被创建，值为null时删除
+func setJSONPath(doc map[string]interface{}, path []string, value interface{}) {
+	for _, name := range path[:len(path)-1] {
+		child, ok := doc[name].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			doc[name] = child
+		}
+		doc = child
+	}
+	name := path[len(path)-1]
+	if value == nil {
+		delete(doc, name)
+		return
+	}
+	doc[name] = value
+}
+
+func adminError(ctx *fasthttp.RequestCtx, status int, msg string) {
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.SetBodyString(models.Response{}.SetErrMsg(msg).String())
+}
@@ -0,0 +1,360 @@
+// Package server
+//
+// @author: xwc1125
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chain5j/chain5j-pkg/util/convutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/storage"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/store"
+	"github.com/xwc1125/apisix-go/internal/models"
+)
+
+// memStorage 内存中的storage，写入后通过Watch通知store
+type memStorage struct {
+	mu       sync.Mutex
+	data     map[string]string
+	watchers []chan storage.WatchResponse
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{data: make(map[string]string)}
+}
+
+func (m *memStorage) Get(_ context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	val, ok := m.data[key]
+	if !ok {
+		return "", fmt.Errorf("key: %s is not found", key)
+	}
+	return val, nil
+}
+
+func (m *memStorage) List(_ context.Context, key string) ([]storage.Keypair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ret []storage.Keypair
+	for k, v := range m.data {
+		if strings.HasPrefix(k, key+"/") {
+			ret = append(ret, storage.Keypair{Key: k, Value: v})
+		}
+	}
+	return ret, nil
+}
+
+func (m *memStorage) Create(ctx context.Context, key, val string) error {
+	if _, err := m.Get(ctx, key); err == nil {
+		return fmt.Errorf("key: %s exists", key)
+	}
+	return m.Update(ctx, key, val)
+}
+
+func (m *memStorage) Update(_ context.Context, key, val string) error {
+	m.mu.Lock()
+	m.data[key] = val
+	m.mu.Unlock()
+	m.notify(storage.Event{Keypair: storage.Keypair{Key: key, Value: val}, Type: storage.EventTypePut})
+	return nil
+}
+
+func (m *memStorage) BatchDelete(_ context.Context, keys []string) error {
+	for _, key := range keys {
+		m.mu.Lock()
+		delete(m.data, key)
+		m.mu.Unlock()
+		m.notify(storage.Event{Keypair: storage.Keypair{Key: key}, Type: storage.EventTypeDelete})
+	}
+	return nil
+}
+
+func (m *memStorage) Watch(_ context.Context, key string) <-chan storage.WatchResponse {
+	ch := make(chan storage.WatchResponse, 16)
+	m.mu.Lock()
+	m.watchers = append(m.watchers, ch)
+	m.mu.Unlock()
+	return filterWatch(ch, key)
+}
+
+func (m *memStorage) notify(event storage.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.watchers {
+		ch <- storage.WatchResponse{Events: []storage.Event{event}}
+	}
+}
+
+// filterWatch 只保留key前缀下的事件
+func filterWatch(in <-chan storage.WatchResponse, key string) <-chan storage.WatchResponse {
+	out := make(chan storage.WatchResponse, 16)
+	go func() {
+		for resp := range in {
+			if strings.HasPrefix(resp.Events[0].Key, key+"/") {
+				out <- resp
+			}
+		}
+	}()
+	return out
+}
+
+func newTestAdmin(t *testing.T) *AdminServe {
+	data, err := os.ReadFile("../../conf/schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := gjson.ParseBytes(data)
+	stg := newMemStorage()
+	stores := make(map[store.HubKey]*store.GenericStore)
+	for hubKey, opt := range map[store.HubKey]store.GenericStoreOption{
+		store.HubKeyRoute: {
+			BasePath: "/apisix/routes",
+			ObjType:  reflect.TypeOf(entity.Route{}),
+			KeyFunc:  func(obj interface{}) string { return convutil.ToString(obj.(*entity.Route).ID) },
+		},
+		store.HubKeyUpstream: {
+			BasePath: "/apisix/upstreams",
+			ObjType:  reflect.TypeOf(entity.Upstream{}),
+			KeyFunc:  func(obj interface{}) string { return convutil.ToString(obj.(*entity.Upstream).ID) },
+		},
+		store.HubKeyService: {
+			BasePath: "/apisix/services",
+			ObjType:  reflect.TypeOf(entity.Service{}),
+			KeyFunc:  func(obj interface{}) string { return convutil.ToString(obj.(*entity.Service).ID) },
+		},
+		store.HubKeyConsumer: {
+			BasePath: "/apisix/consumers",
+			ObjType:  reflect.TypeOf(entity.Consumer{}),
+			KeyFunc:  func(obj interface{}) string { return obj.(*entity.Consumer).Username },
+		},
+	} {
+		validator, err := store.NewAPISIXJsonSchemaValidator(schema, "main."+string(hubKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt.Validator = validator
+		opt.HubKey = hubKey
+		s, err := store.NewGenericStore(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Stg = stg
+		if err := s.Init(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		stores[hubKey] = s
+	}
+
+	a, err := NewAdminServe(models.AdminConfig{AdminKey: []models.AdminKey{
+		{Name: "admin", Key: "admin-key", Role: "admin"},
+		{Name: "viewer", Key: "viewer-key", Role: "viewer"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.getStore = func(hubKey store.HubKey) *store.GenericStore {
+		return stores[hubKey]
+	}
+	return a
+}
+
+func adminDo(a *AdminServe, method, uri, key, body string) (int, gjson.Result) {
+	ctx := new(fasthttp.RequestCtx)
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	if len(key) > 0 {
+		ctx.Request.Header.Set("X-API-KEY", key)
+	}
+	ctx.Request.SetBodyString(body)
+	a.Handler(ctx)
+	return ctx.Response.StatusCode(), gjson.ParseBytes(ctx.Response.Body())
+}
+
+// adminWait 写入通过watch异步同步到store的缓存，等待GET的结果满足条件
+func adminWait(t *testing.T, a *AdminServe, uri string, cond func(status int, ret gjson.Result) bool) {
+	assert.Eventually(t, func() bool {
+		return cond(adminDo(a, "GET", uri, "admin-key", ""))
+	}, time.Second, 5*time.Millisecond, uri)
+}
+
+func TestNewAdminServe(t *testing.T) {
+	_, err := NewAdminServe(models.AdminConfig{})
+	assert.Error(t, err)
+	_, err = NewAdminServe(models.AdminConfig{AdminKey: []models.AdminKey{{Name: "a", Key: "k", Role: "root"}}})
+	assert.Error(t, err)
+	_, err = NewAdminServe(models.AdminConfig{AdminKey: []models.AdminKey{{Name: "a", Role: "admin"}}})
+	assert.Error(t, err)
+	_, err = NewAdminServe(models.AdminConfig{AdminKey: []models.AdminKey{{Name: "a", Key: "edd1c9f034335f136f87ad84b625c8f1", Role: "admin"}}})
+	assert.Error(t, err)
+}
+
+func TestAdminAuth(t *testing.T) {
+	a := newTestAdmin(t)
+
+	status, _ := adminDo(a, "GET", "/apisix/admin/routes", "", "")
+	assert.Equal(t, fasthttp.StatusUnauthorized, status)
+	status, _ = adminDo(a, "GET", "/apisix/admin/routes", "wrong", "")
+	assert.Equal(t, fasthttp.StatusUnauthorized, status)
+	status, _ = adminDo(a, "GET", "/apisix/admin/routes?api_key=viewer-key", "", "")
+	assert.Equal(t, fasthttp.StatusOK, status)
+	status, ret := adminDo(a, "PUT", "/apisix/admin/routes/1", "viewer-key", `{"uri":"/a"}`)
+	assert.Equal(t, fasthttp.StatusForbidden, status)
+	assert.Equal(t, "invalid method for role viewer", ret.Get("error_msg").String())
+
+	status, _ = adminDo(a, "GET", "/apisix/admin/unknown", "admin-key", "")
+	assert.Equal(t, fasthttp.StatusNotFound, status)
+}
+
+func TestAdminCRUD(t *testing.T) {
+	a := newTestAdmin(t)
+
+	// 创建
+	status, ret := adminDo(a, "PUT", "/apisix/admin/upstreams/u1", "admin-key",
+		`{"type":"roundrobin","nodes":{"127.0.0.1:8080":1}}`)
+	assert.Equal(t, fasthttp.StatusCreated, status, ret.Raw)
+	assert.Equal(t, "/apisix/upstreams/u1", ret.Get("key").String())
+	status, ret = adminDo(a, "PUT", "/apisix/admin/routes", "admin-key",
+		`{"id":"r1","uri":"/a","upstream_id":"u1"}`)
+	assert.Equal(t, fasthttp.StatusCreated, status, ret.Raw)
+	// 未配置status时默认启用
+	assert.Equal(t, int64(1), ret.Get("value.status").Int())
+	adminWait(t, a, "/apisix/admin/routes/r1", func(status int, ret gjson.Result) bool {
+		return status == fasthttp.StatusOK && ret.Get("value.uri").String() == "/a"
+	})
+	createTime := func() int64 {
+		_, ret := adminDo(a, "GET", "/apisix/admin/routes/r1", "admin-key", "")
+		return ret.Get("value.create_time").Int()
+	}()
+	assert.NotZero(t, createTime)
+
+	// 替换
+	status, ret = adminDo(a, "PUT", "/apisix/admin/routes/r1", "admin-key",
+		`{"id":"other","uri":"/b","upstream_id":"u1","labels":{"env":"dev"}}`)
+	assert.Equal(t, fasthttp.StatusOK, status, ret.Raw)
+	assert.Equal(t, "r1", ret.Get("value.id").String())
+	adminWait(t, a, "/apisix/admin/routes/r1", func(status int, ret gjson.Result) bool {
+		return ret.Get("value.uri").String() == "/b"
+	})
+
+	// 合并更新
+	status, ret = adminDo(a, "PATCH", "/apisix/admin/routes/r1", "admin-key",
+		`{"name":"route-1","labels":null}`)
+	assert.Equal(t, fasthttp.StatusOK, status, ret.Raw)
+	assert.Equal(t, "/b", ret.Get("value.uri").String())
+	assert.Equal(t, "route-1", ret.Get("value.name").String())
+	assert.False(t, ret.Get("value.labels").Exists())
+	assert.Equal(t, createTime, ret.Get("value.create_time").Int())
+	adminWait(t, a, "/apisix/admin/routes/r1", func(status int, ret gjson.Result) bool {
+		return ret.Get("value.name").String() == "route-1"
+	})
+	status, ret = adminDo(a, "PATCH", "/apisix/admin/routes/r1/methods", "admin-key", `["GET","POST"]`)
+	assert.Equal(t, fasthttp.StatusOK, status, ret.Raw)
+	assert.Equal(t, `["GET","POST"]`, ret.Get("value.methods").Raw)
+	assert.Equal(t, "route-1", ret.Get("value.name").String())
+	status, _ = adminDo(a, "PATCH", "/apisix/admin/routes/r2", "admin-key", `{"name":"x"}`)
+	assert.Equal(t, fasthttp.StatusNotFound, status)
+
+	// 生成id
+	status, ret = adminDo(a, "POST", "/apisix/admin/routes", "admin-key", `{"uri":"/c","upstream_id":"u1"}`)
+	assert.Equal(t, fasthttp.StatusCreated, status, ret.Raw)
+	assert.NotEmpty(t, ret.Get("value.id").String())
+	adminWait(t, a, "/apisix/admin/routes", func(status int, ret gjson.Result) bool {
+		return ret.Get("total").Int() == 2
+	})
+
+	// 分页
+	status, ret = adminDo(a, "GET", "/apisix/admin/routes?page=2&page_size=1", "admin-key", "")
+	assert.Equal(t, fasthttp.StatusOK, status)
+	assert.Equal(t, int64(2), ret.Get("total").Int())
+	assert.Len(t, ret.Get("list").Array(), 1)
+	status, _ = adminDo(a, "GET", "/apisix/admin/routes?page=x", "admin-key", "")
+	assert.Equal(t, fasthttp.StatusBadRequest, status)
+
+	// 被引用的upstream不能删除
+	status, ret = adminDo(a, "DELETE", "/apisix/admin/upstreams/u1", "admin-key", "")
+	assert.Equal(t, fasthttp.StatusBadRequest, status)
+	assert.Contains(t, ret.Get("error_msg").String(), "is still using it now")
+
+	status, ret = adminDo(a, "DELETE", "/apisix/admin/routes/r1", "admin-key", "")
+	assert.Equal(t, fasthttp.StatusOK, status)
+	assert.Equal(t, "r1", ret.Get("deleted").String())
+	adminWait(t, a, "/apisix/admin/routes/r1", func(status int, ret gjson.Result) bool {
+		return status == fasthttp.StatusNotFound
+	})
+	status, _ = adminDo(a, "DELETE", "/apisix/admin/routes/r1", "admin-key", "")
+	assert.Equal(t, fasthttp.StatusNotFound, status)
+}
+
+func TestAdminValidate(t *testing.T) {
+	a := newTestAdmin(t)
+
+	bodies := []string{
+		``,
+		`[]`,
+		`{"uri":1}`,
+		`{"uri":"/a","methods":["FOO"]}`,
+		`{"uri":"/a","vars":[["arg_name","=","jack"]]}`,
+		`{"uri":"/a","upstream":{"type":"chash","nodes":{"127.0.0.1:80":1}}}`,
+	}
+	for _, body := range bodies {
+		status, ret := adminDo(a, "PUT", "/apisix/admin/routes/1", "admin-key", body)
+		assert.Equal(t, fasthttp.StatusBadRequest, status, body)
+		assert.NotEmpty(t, ret.Get("error_msg").String(), body)
+	}
+	status, _ := adminDo(a, "PUT", "/apisix/admin/routes", "admin-key", `{"uri":"/a"}`)
+	assert.Equal(t, fasthttp.StatusBadRequest, status)
+}
+
+func TestAdminRouteStatus(t *testing.T) {
+	a := newTestAdmin(t)
+
+	// 未配置status时默认启用，显式配置的status不变
+	status, ret := adminDo(a, "POST", "/apisix/admin/routes", "admin-key", `{"uri":"/a","upstream":{"type":"roundrobin","nodes":{"127.0.0.1:80":1}}}`)
+	assert.Equal(t, fasthttp.StatusCreated, status, ret.Raw)
+	assert.Equal(t, int64(1), ret.Get("value.status").Int())
+	status, ret = adminDo(a, "PUT", "/apisix/admin/routes/r2", "admin-key", `{"uri":"/b","status":0,"upstream":{"type":"roundrobin","nodes":{"127.0.0.1:80":1}}}`)
+	assert.Equal(t, fasthttp.StatusCreated, status, ret.Raw)
+	assert.Equal(t, int64(0), ret.Get("value.status").Int())
+	assert.True(t, ret.Get("value.status").Exists())
+}
+
+func TestAdminConsumer(t *testing.T) {
+	a := newTestAdmin(t)
+
+	for _, name := range []string{"jack", "alice"} {
+		status, ret := adminDo(a, "PUT", "/apisix/admin/consumers", "admin-key", `{"username":"`+name+`"}`)
+		assert.Equal(t, fasthttp.StatusCreated, status, ret.Raw)
+	}
+	adminWait(t, a, "/apisix/admin/consumers", func(status int, ret gjson.Result) bool {
+		return ret.Get("total").Int() == 2
+	})
+	_, ret := adminDo(a, "GET", "/apisix/admin/consumers", "admin-key", "")
+	var names []string
+	for _, item := range ret.Get("list").Array() {
+		names = append(names, item.Get("value.username").String())
+	}
+	assert.Equal(t, []string{"alice", "jack"}, names)
+	assert.Equal(t, "/apisix/consumers/alice", ret.Get("list.0.key").String())
+
+	status, _ := adminDo(a, "POST", "/apisix/admin/consumers", "admin-key", `{"username":"rose"}`)
+	assert.Equal(t, fasthttp.StatusMethodNotAllowed, status)
+
+	var consumer entity.Consumer
+	_, ret = adminDo(a, "GET", "/apisix/admin/consumers/jack", "admin-key", "")
+	assert.NoError(t, json.Unmarshal([]byte(ret.Get("value").Raw), &consumer))
+	assert.Equal(t, "jack", consumer.Username)
+}
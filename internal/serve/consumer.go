@@ -0,0 +1,71 @@
+// Package server
+//
+// @author: xwc1125
+package serve
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/store"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+var (
+	_ store.WatchEvent = new(WatchConsumer)
+)
+
+// WatchConsumer 将consumer同步给认证插件
+type WatchConsumer struct {
+	mu        sync.Mutex
+	consumers map[string]*entity.Consumer
+}
+
+func NewWatchConsumer() *WatchConsumer {
+	return &WatchConsumer{
+		consumers: make(map[string]*entity.Consumer),
+	}
+}
+
+// Load 加载store中已有的consumer
+func (w *WatchConsumer) Load() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	store.GetStore(store.HubKeyConsumer).Range(context.TODO(), func(key string, obj interface{}) bool {
+		if consumer, ok := obj.(*entity.Consumer); ok {
+			w.consumers[key] = consumer
+		}
+		return true
+	})
+	w.publish()
+}
+
+func (w *WatchConsumer) WatchEventPut(key string, objPtr interface{}) {
+	consumer, ok := objPtr.(*entity.Consumer)
+	if !ok {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.consumers[key] = consumer
+	w.publish()
+}
+
+func (w *WatchConsumer) WatchEventDelete(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.consumers[key]; !ok {
+		return
+	}
+	delete(w.consumers, key)
+	w.publish()
+}
+
+func (w *WatchConsumer) publish() {
+	list := make([]*entity.Consumer, 0, len(w.consumers))
+	for _, consumer := range w.consumers {
+		list = append(list, consumer)
+	}
+	plugins.StoreConsumers(list)
+}
@@ -0,0 +1,44 @@
+// Package server
+//
+// @author: xwc1125
+package serve
+
+import (
+	"encoding/json"
+
+	"github.com/valyala/fasthttp"
+	"github.com/xwc1125/apisix-go/internal/apisix/healthcheck"
+	"github.com/xwc1125/apisix-go/internal/models"
+	"github.com/xwc1125/apisix-go/internal/proxy"
+)
+
+// ControlHandler 与APISIX一致的Control API，用于查询网关内部的状态
+//
+//	GET /v1/healthcheck 上游节点的健康检查状态
+//	GET /v1/upstreams/pool 上游节点的连接池状态
+func ControlHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsGet() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		return
+	}
+	switch string(ctx.Path()) {
+	case "/v1/healthcheck":
+		writeJSON(ctx, healthcheck.Statuses())
+	case "/v1/upstreams/pool":
+		writeJSON(ctx, proxy.PoolStats())
+	default:
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.SetBodyString(models.Response{}.SetErrMsg("not found").String())
+	}
+}
+
+func writeJSON(ctx *fasthttp.RequestCtx, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(models.Response{}.SetErrMsg(err.Error()).String())
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(data)
+}
@@ -0,0 +1,98 @@
+// Package server
+//
+// @author: xwc1125
+package serve
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/store"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+	"github.com/xwc1125/apisix-go/internal/proxy"
+)
+
+var (
+	_ store.WatchEvent = new(WatchGlobalRule)
+)
+
+// WatchGlobalRule 维护当前生效的全局规则
+type WatchGlobalRule struct {
+	confCache *plugins.ConfCache
+
+	mu    sync.Mutex
+	seq   uint64
+	rules map[string]proxy.GlobalRule
+	list  atomic.Value // []proxy.GlobalRule
+}
+
+func NewWatchGlobalRule(confCache *plugins.ConfCache) *WatchGlobalRule {
+	w := &WatchGlobalRule{
+		confCache: confCache,
+		rules:     make(map[string]proxy.GlobalRule),
+	}
+	w.list.Store([]proxy.GlobalRule{})
+	return w
+}
+
+// Load 加载store中已有的全局规则
+func (w *WatchGlobalRule) Load() {
+	store.GetStore(store.HubKeyGlobalRule).Range(context.TODO(), func(key string, obj interface{}) bool {
+		w.WatchEventPut(key, obj)
+		return true
+	})
+}
+
+// Rules 当前生效的全局规则，按ID排序
+func (w *WatchGlobalRule) Rules() []proxy.GlobalRule {
+	return w.list.Load().([]proxy.GlobalRule)
+}
+
+func (w *WatchGlobalRule) WatchEventPut(key string, objPtr interface{}) {
+	rule, ok := objPtr.(*entity.GlobalPlugins)
+	if !ok {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	old, exist := w.rules[key]
+	w.seq++
+	// 每次变更使用新的key，避免正在处理的请求将旧的配置重新写入缓存
+	w.rules[key] = proxy.GlobalRule{
+		ConfKey: fmt.Sprintf("%s/%s#%d", store.HubKeyGlobalRule, key, w.seq),
+		Plugins: rule.Plugins,
+	}
+	w.rebuild()
+	if exist {
+		w.confCache.Delete(old.ConfKey)
+	}
+}
+
+func (w *WatchGlobalRule) WatchEventDelete(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	old, exist := w.rules[key]
+	if !exist {
+		return
+	}
+	delete(w.rules, key)
+	w.rebuild()
+	w.confCache.Delete(old.ConfKey)
+}
+
+func (w *WatchGlobalRule) rebuild() {
+	keys := make([]string, 0, len(w.rules))
+	for key := range w.rules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := make([]proxy.GlobalRule, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, w.rules[key])
+	}
+	w.list.Store(list)
+}
@@ -0,0 +1,40 @@
+// Package server
+//
+// @author: xwc1125
+package serve
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+func TestWatchGlobalRule(t *testing.T) {
+	confCache := plugins.InitConfCache(time.Minute)
+	w := NewWatchGlobalRule(confCache)
+	assert.Empty(t, w.Rules())
+
+	w.WatchEventPut("2", &entity.GlobalPlugins{Plugins: map[string]interface{}{"request-id": map[string]interface{}{}}})
+	w.WatchEventPut("1", &entity.GlobalPlugins{Plugins: map[string]interface{}{"ip-restriction": map[string]interface{}{}}})
+	rules := w.Rules()
+	if assert.Len(t, rules, 2) {
+		assert.Contains(t, rules[0].Plugins, "ip-restriction")
+		assert.Contains(t, rules[1].Plugins, "request-id")
+	}
+
+	// 更新后使用新的缓存key，旧的缓存被删除
+	oldKey := rules[0].ConfKey
+	_, err := plugins.PrepareConf(oldKey, rules[0].Plugins)
+	assert.NoError(t, err)
+	w.WatchEventPut("1", &entity.GlobalPlugins{Plugins: map[string]interface{}{}})
+	assert.NotEqual(t, oldKey, w.Rules()[0].ConfKey)
+	_, err = confCache.Get(oldKey)
+	assert.Error(t, err)
+
+	w.WatchEventDelete("2")
+	w.WatchEventDelete("3")
+	assert.Len(t, w.Rules(), 1)
+}
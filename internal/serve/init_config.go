@@ -11,7 +11,7 @@ import (
 	"github.com/tidwall/gjson"
 )
 
-func (p *ProxyServe) initSchema(dir string) {
+func loadSchema(dir string) (gjson.Result, error) {
 	var (
 		apisixSchemaPath       = dir + "/conf/schema.json"
 		apisixSchemaContent    []byte
@@ -20,15 +20,15 @@ func (p *ProxyServe) initSchema(dir string) {
 	)
 
 	if apisixSchemaContent, err = ioutil.ReadFile(apisixSchemaPath); err != nil {
-		panic(fmt.Errorf("fail to read configuration: %s, error: %s", apisixSchemaPath, err.Error()))
+		return gjson.Result{}, fmt.Errorf("fail to read configuration: %s, error: %s", apisixSchemaPath, err.Error())
 	}
 
 	content, err := mergeSchema(apisixSchemaContent, customizeSchemaContent)
 	if err != nil {
-		panic(err)
+		return gjson.Result{}, err
 	}
 
-	p.schema = gjson.ParseBytes(content)
+	return gjson.ParseBytes(content), nil
 }
 
 func mergeSchema(apisixSchema, customizeSchema []byte) ([]byte, error) {
@@ -4,12 +4,16 @@
 package serve
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
+	"github.com/chain5j/chain5j-pkg/util/convutil"
 	"github.com/chain5j/logger"
 	"github.com/spf13/viper"
 	"github.com/tidwall/gjson"
@@ -18,8 +22,7 @@ import (
 	"github.com/xwc1125/apisix-go/internal/apisix/core/storage"
 	"github.com/xwc1125/apisix-go/internal/apisix/core/store"
 	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
-	"github.com/xwc1125/apisix-go/internal/apisix/utils/iputils"
-	"github.com/xwc1125/apisix-go/internal/apisix/utils/reg_uri"
+	"github.com/xwc1125/apisix-go/internal/apisix/router"
 	"github.com/xwc1125/apisix-go/internal/models"
 	"github.com/xwc1125/apisix-go/internal/proxy"
 )
@@ -27,230 +30,258 @@ import (
 type ProxyServe struct {
 	log logger.Logger
 
-	schema    gjson.Result
-	confCache *plugins.ConfCache
-	testLocal bool
+	schema      gjson.Result
+	confCache   *plugins.ConfCache
+	router      *router.Router
+	resolver    *resolver
+	proxies     *proxy.Registry
+	globalRules *WatchGlobalRule
+	consumers   *WatchConsumer
+	protos      *WatchProto
+	testLocal   bool
 }
 
 func NewProxyServe() (*ProxyServe, error) {
 	confCache := plugins.InitConfCache(time.Minute * 60)
+	globalRules := NewWatchGlobalRule(confCache)
+	proxyOpts := []proxy.Option{proxy.WithGlobalRules(globalRules.Rules), proxy.WithUpstreams(getUpstream)}
+	if caFile := viper.GetString("upstream.ssl_trusted_certificate"); len(caFile) > 0 {
+		tlsConfig, err := trustedCAConfig(caFile)
+		if err != nil {
+			return nil, err
+		}
+		proxyOpts = append(proxyOpts, proxy.WithTLSConfig(tlsConfig))
+	}
 	p := &ProxyServe{
-		log:       logger.Log("proxy"),
-		confCache: confCache,
-		testLocal: viper.GetBool("test_local"),
+		log:         logger.Log("proxy"),
+		confCache:   confCache,
+		router:      router.NewRouter(),
+		resolver:    newResolver(),
+		proxies:     proxy.NewRegistry(proxyOpts...),
+		globalRules: globalRules,
+		consumers:   NewWatchConsumer(),
+		protos:      NewWatchProto(),
+		testLocal:   viper.GetBool("test_local"),
 	}
-	var etcdConfig storage.EtcdConfig
-	err := viper.UnmarshalKey("etcd", &etcdConfig)
+	watchRoute := NewWatchRoute(p.router, p.resolver, p.proxies)
+	schema, err := InitStores(".", map[store.HubKey]store.WatchEvent{
+		store.HubKeyRoute:        watchRoute,
+		store.HubKeyService:      NewWatchRouteDependency(store.HubKeyService, watchRoute),
+		store.HubKeyUpstream:     NewWatchRouteDependency(store.HubKeyUpstream, watchRoute),
+		store.HubKeyPluginConfig: NewWatchRouteDependency(store.HubKeyPluginConfig, watchRoute),
+		store.HubKeyGlobalRule:   p.globalRules,
+		store.HubKeyConsumer:     p.consumers,
+		store.HubKeyProto:        p.protos,
+	})
 	if err != nil {
-		p.log.Error("unmarshal etcd config err", "err", err)
+		p.log.Error("init stores err", "err", err)
 		return nil, err
 	}
-	err = storage.InitETCDClient(&etcdConfig)
+	p.schema = schema
+	p.loadRoutes()
+	p.globalRules.Load()
+	p.consumers.Load()
+	p.protos.Load()
+	return p, nil
+}
+
+// InitStores 按配置连接etcd或者加载standalone的配置文件，并使用dir下的conf/schema.json初始化所有的store
+func InitStores(dir string, watchEvents map[store.HubKey]store.WatchEvent) (gjson.Result, error) {
+	var etcdConfig storage.EtcdConfig
+	if err := viper.UnmarshalKey("etcd", &etcdConfig); err != nil {
+		return gjson.Result{}, fmt.Errorf("unmarshal etcd config err: %w", err)
+	}
+	var standaloneConfig storage.StandaloneConfig
+	if err := viper.UnmarshalKey("standalone", &standaloneConfig); err != nil {
+		return gjson.Result{}, fmt.Errorf("unmarshal standalone config err: %w", err)
+	}
+	if standaloneConfig.Enable {
+		if len(etcdConfig.Prefix) == 0 {
+			etcdConfig.Prefix = "/apisix"
+		}
+		if err := storage.InitStandaloneStorage(standaloneConfig.ConfigFile, etcdConfig.Prefix); err != nil {
+			return gjson.Result{}, fmt.Errorf("init standalone storage err: %w", err)
+		}
+		logger.Info("standalone mode", "config_file", standaloneConfig.ConfigFile)
+	} else if err := storage.InitETCDClient(&etcdConfig); err != nil {
+		return gjson.Result{}, fmt.Errorf("init etcd client err: %w", err)
+	}
+	schema, err := loadSchema(dir)
 	if err != nil {
-		p.log.Error("init etcd client err", "err", err)
-		return nil, err
+		return gjson.Result{}, err
+	}
+	if err := store.InitStores(schema, etcdConfig, watchEvents); err != nil {
+		return gjson.Result{}, err
 	}
+	return schema, nil
+}
 
-	p.initSchema(".")
-	err = store.InitStores(p.schema, etcdConfig, map[store.HubKey]store.WatchEvent{
-		store.HubKeyRoute: NewWatchRoute(confCache),
-	})
+// trustedCAConfig 读取PEM格式的CA证书，用于校验上游的证书
+func trustedCAConfig(caFile string) (*tls.Config, error) {
+	data, err := os.ReadFile(caFile)
 	if err != nil {
-		p.log.Error("init stores err", "err", err)
 		return nil, err
 	}
-	return p, nil
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &tls.Config{RootCAs: pool}, nil
 }
 
-var (
-	testLocal = true
-)
+// loadRoutes 将store中已有的路由编译到router中，之后的变更由WatchRoute同步
+func (p *ProxyServe) loadRoutes() {
+	routes := make(map[string]*entity.Route)
+	store.GetStore(store.HubKeyRoute).Range(context.TODO(), func(key string, obj interface{}) bool {
+		if route, ok := obj.(*entity.Route); ok {
+			routes[key] = route
+		}
+		return true
+	})
+	p.router.Load(routes)
+	p.log.Info("routes loaded", "len", len(routes))
+}
 
 // ProxyHandler ...
 func (p *ProxyServe) ProxyHandler(ctx *fasthttp.RequestCtx) {
+	px, release, ok := p.acquireProxy(ctx)
+	if !ok {
+		return
+	}
+	defer release()
+	px.ServeHTTP(ctx)
+}
+
+// GRPCHandler 处理h2/h2c的gRPC请求，路由匹配和插件与HTTP请求一致，上游通过HTTP/2转发
+func (p *ProxyServe) GRPCHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := proxy.GRPCRequestCtx(r)
+	px, release, ok := p.acquireProxy(ctx)
+	if !ok {
+		proxy.WriteResponse(w, &ctx.Response)
+		return
+	}
+	defer release()
+	px.ServeGRPC(ctx, w, r)
+}
+
+// acquireProxy 匹配路由并获取对应的Proxy，失败时错误已写入ctx
+func (p *ProxyServe) acquireProxy(ctx *fasthttp.RequestCtx) (*proxy.Proxy, func(), bool) {
 	var route = new(entity.Route)
 	if p.testLocal {
 		// 本地测试
 		bytes, err := os.ReadFile("conf/test-route.json")
 		if err != nil {
 			logger.Error("read json err", "err", err)
-			return
+			return nil, nil, false
 		}
 		err = json.Unmarshal(bytes, route)
 		if err != nil {
 			logger.Error("unmarshal route err", "err", err)
-			return
+			return nil, nil, false
 		}
 	} else {
-		routeStore := store.GetStore(store.HubKeyRoute)
-		output, err := routeStore.List(ctx, store.ListInput{
-			Predicate: func(obj interface{}) bool {
-				route, ok := obj.(*entity.Route)
-				if !ok {
-					return false
-				}
-				return MatchRoute(ctx, route)
-			},
-			Format: func(obj interface{}) interface{} {
-				route, ok := obj.(*entity.Route)
-				if !ok {
-					return obj
-				}
-				if route.Upstream != nil && route.Upstream.Nodes != nil {
-					route.Upstream.Nodes = entity.NodesFormat(route.Upstream.Nodes)
-				}
-				return route
-			},
-			Less: func(i, j interface{}) bool {
-				routeI, ok := i.(*entity.Route)
-				routeJ, ok1 := j.(*entity.Route)
-				if !ok || !ok1 {
-					return true
-				}
-				if routeI.Priority < routeJ.Priority {
-					return false
-				}
-				if routeI.CreateTime < routeJ.CreateTime {
-					return false
-				}
-				if routeI.UpdateTime < routeJ.UpdateTime {
-					return false
-				}
-				return true
-			},
-			PageSize:   10,
-			PageNumber: 0,
-		})
-		if err != nil {
-			ctx.Error("parse uri err", http.StatusInternalServerError)
-			return
-		}
-		if len(output.Rows) == 0 {
+		match, ok := p.router.Match(ctx)
+		if !ok {
 			ctx.Error(models.Response{}.SetErrMsg("404 Route Not Found").String(), http.StatusNotFound)
-			return
+			return nil, nil, false
 		}
-		logger.Debug("route match len", "len", len(output.Rows))
-		route = output.Rows[0].(*entity.Route)
+		route = match.Route
 	}
 	if route == nil {
 		ctx.Error("Not found", fasthttp.StatusNotFound)
-		return
+		return nil, nil, false
 	}
-	newProxy, err := proxy.NewProxy(*route)
+	key := convutil.ToString(route.ID)
+	resolved, err := p.resolver.Resolve(key, route)
 	if err != nil {
-		ctx.Error("New proxy err:"+err.Error(), 500)
-		return
-	}
-	newProxy.ServeHTTP(ctx)
-}
-
-func MatchRoute(ctx *fasthttp.RequestCtx, route *entity.Route) bool {
-	// status
-	if route.Status != 1 {
-		return false
+		p.log.Error("resolve route err", "key", key, "err", err)
+		ctx.Error(models.Response{}.SetErrMsg(err.Error()).String(), http.StatusServiceUnavailable)
+		return nil, nil, false
 	}
-	// uri
-	{
-		reqUri := string(ctx.RequestURI())
-		if len(route.Uris) > 0 {
-			match := false
-			for _, uri := range route.Uris {
-				if reg_uri.KeyMatch4(reqUri, uri) {
-					match = true
-					break
-				}
-			}
-			if !match {
-				return false
-			}
-		}
-		if len(route.URI) > 0 && !reg_uri.KeyMatch4(reqUri, route.URI) {
-			return false
-		}
-	}
-	// host
-	{
-		reqHost := string(ctx.Host())
-		split := strings.Split(reqHost, ":")
-		domain := split[0]
-		if len(route.Hosts) > 0 {
-			match := false
-
-			for _, host := range route.Hosts {
-				if reg_uri.DomainMatch(domain, host) {
-					match = true
-					break
-				}
-			}
-			if !match {
-				return false
-			}
-		}
-		if len(route.Host) > 0 && !reg_uri.DomainMatch(domain, route.Host) {
-			return false
-		}
-	}
-	// remoteAddr
-	{
-		remoteAddr := ctx.RemoteAddr().String()
-		split := strings.Split(remoteAddr, ":")
-		remoteIp := split[0]
-		if len(route.RemoteAddrs) > 0 {
-			match := false
-			for _, addr := range route.RemoteAddrs {
-				if iputils.Match(remoteIp, addr) {
-					match = true
-					break
-				}
-			}
-			if !match {
-				return false
-			}
-		}
-		if len(route.RemoteAddr) > 0 && !iputils.Match(remoteIp, route.RemoteAddr) {
-			return false
-		}
-	}
-	// method
-	{
-		reqMethod := string(ctx.Method())
-		if len(route.Methods) > 0 {
-			match := false
-			for _, method := range route.Methods {
-				if strings.EqualFold(reqMethod, method) {
-					match = true
-					break
-				} else if strings.EqualFold(method, "ALL") {
-					match = true
-					break
-				}
-			}
-			if !match {
-				return false
-			}
-		}
+	px, release, err := p.proxies.Acquire(key, resolved)
+	if err != nil {
+		ctx.Error("New proxy err:"+err.Error(), 500)
+		return nil, nil, false
 	}
-	return true
+	return px, release, true
 }
 
 var (
 	_ store.WatchEvent = new(WatchRoute)
+	_ store.WatchEvent = new(WatchRouteDependency)
 )
 
+// WatchRoute 路由变更时同步router，并重建路由对应的proxy
 type WatchRoute struct {
-	confCache *plugins.ConfCache
+	log      logger.Logger
+	router   *router.Router
+	resolver *resolver
+	proxies  *proxy.Registry
 }
 
-func NewWatchRoute(confCache *plugins.ConfCache) *WatchRoute {
+func NewWatchRoute(router *router.Router, resolver *resolver, proxies *proxy.Registry) *WatchRoute {
 	return &WatchRoute{
-		confCache: confCache,
+		log:      logger.Log("watch-route"),
+		router:   router,
+		resolver: resolver,
+		proxies:  proxies,
 	}
 }
 
 func (w *WatchRoute) WatchEventPut(key string, objPtr interface{}) {
-	w.confCache.Delete(key)
+	if route, ok := objPtr.(*entity.Route); ok {
+		w.router.Put(key, route)
+		w.refresh(key, route)
+	}
 }
 
 func (w *WatchRoute) WatchEventDelete(key string) {
-	w.confCache.Delete(key)
+	w.router.Delete(key)
+	w.resolver.Remove(key)
+	w.proxies.Remove(key)
+}
+
+// refresh 重新合并路由，并替换已缓存的proxy
+func (w *WatchRoute) refresh(key string, route *entity.Route) {
+	w.resolver.Remove(key)
+	resolved, err := w.resolver.Resolve(key, route)
+	if err != nil {
+		w.log.Warn("resolve route err", "key", key, "err", err)
+		w.proxies.Remove(key)
+		return
+	}
+	w.proxies.Swap(key, resolved)
+}
+
+// WatchRouteDependency service、upstream、plugin_config变更时，刷新引用了它们的路由
+type WatchRouteDependency struct {
+	hubKey store.HubKey
+	route  *WatchRoute
+}
+
+func NewWatchRouteDependency(hubKey store.HubKey, route *WatchRoute) *WatchRouteDependency {
+	return &WatchRouteDependency{
+		hubKey: hubKey,
+		route:  route,
+	}
+}
+
+func (w *WatchRouteDependency) WatchEventPut(key string, _ interface{}) {
+	w.refresh(key)
+}
+
+func (w *WatchRouteDependency) WatchEventDelete(key string) {
+	w.refresh(key)
+}
+
+func (w *WatchRouteDependency) refresh(key string) {
+	routeStore := store.GetStore(store.HubKeyRoute)
+	for _, routeKey := range w.route.resolver.Invalidate(depKey(w.hubKey, key)) {
+		obj, err := routeStore.Get(context.TODO(), routeKey)
+		if err != nil {
+			w.route.proxies.Remove(routeKey)
+			continue
+		}
+		w.route.refresh(routeKey, obj.(*entity.Route))
+	}
 }
@@ -0,0 +1,43 @@
+// Package server
+//
+// @author: xwc1125
+package serve
+
+import (
+	"context"
+
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/store"
+	"github.com/xwc1125/apisix-go/internal/apisix/plugins"
+)
+
+var (
+	_ store.WatchEvent = new(WatchProto)
+)
+
+// WatchProto 将proto同步给grpc-transcode等插件，插件在proto变化时清除缓存的描述
+type WatchProto struct{}
+
+func NewWatchProto() *WatchProto {
+	return &WatchProto{}
+}
+
+// Load 加载store中已有的proto
+func (w *WatchProto) Load() {
+	store.GetStore(store.HubKeyProto).Range(context.TODO(), func(key string, obj interface{}) bool {
+		if proto, ok := obj.(*entity.Proto); ok {
+			plugins.StoreProto(key, proto)
+		}
+		return true
+	})
+}
+
+func (w *WatchProto) WatchEventPut(key string, objPtr interface{}) {
+	if proto, ok := objPtr.(*entity.Proto); ok {
+		plugins.StoreProto(key, proto)
+	}
+}
+
+func (w *WatchProto) WatchEventDelete(key string) {
+	plugins.DeleteProto(key)
+}
@@ -0,0 +1,179 @@
+// Package server
+//
+// @author: xwc1125
+package serve
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/chain5j/chain5j-pkg/util/convutil"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/store"
+)
+
+// resolvedRoute 合并后的路由
+type resolvedRoute struct {
+	source *entity.Route // 原始路由
+	route  *entity.Route // 合并service、upstream、plugin_config后的路由
+	deps   []string      // 依赖的对象，格式为：hubKey/id
+}
+
+// resolver 按照APISIX的规则，将路由与其绑定的service、upstream、plugin_config合并为最终生效的路由。
+//
+// upstream的优先级：route.upstream > route.upstream_id > service.upstream > service.upstream_id；
+// 插件的优先级：route > plugin_config > service，同名插件使用优先级高的配置
+type resolver struct {
+	get func(hubKey store.HubKey, id string) (interface{}, error)
+
+	mu       sync.Mutex
+	resolved map[string]*resolvedRoute
+}
+
+func newResolver() *resolver {
+	return &resolver{
+		get: func(hubKey store.HubKey, id string) (interface{}, error) {
+			return store.GetStore(hubKey).Get(context.TODO(), id)
+		},
+		resolved: make(map[string]*resolvedRoute),
+	}
+}
+
+func depKey(hubKey store.HubKey, id string) string {
+	return string(hubKey) + "/" + id
+}
+
+// Resolve 获取路由合并后的结果，路由未变化时返回缓存的对象
+func (r *resolver) Resolve(key string, route *entity.Route) (*entity.Route, error) {
+	r.mu.Lock()
+	if rr, ok := r.resolved[key]; ok && rr.source == route {
+		r.mu.Unlock()
+		return rr.route, nil
+	}
+	r.mu.Unlock()
+
+	rr, err := r.resolve(route)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	if cached, ok := r.resolved[key]; ok && cached.source == route {
+		r.mu.Unlock()
+		return cached.route, nil
+	}
+	r.resolved[key] = rr
+	r.mu.Unlock()
+	return rr.route, nil
+}
+
+// Remove 删除路由的缓存
+func (r *resolver) Remove(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.resolved, key)
+}
+
+// Invalidate 删除所有依赖dep的路由缓存，并返回这些路由的key
+func (r *resolver) Invalidate(dep string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var keys []string
+	for key, rr := range r.resolved {
+		for _, d := range rr.deps {
+			if d == dep {
+				keys = append(keys, key)
+				delete(r.resolved, key)
+				break
+			}
+		}
+	}
+	return keys
+}
+
+func (r *resolver) resolve(route *entity.Route) (*resolvedRoute, error) {
+	var (
+		eff  = *route
+		deps []string
+	)
+
+	// service
+	var service *entity.Service
+	if route.ServiceID != nil {
+		id := convutil.ToString(route.ServiceID)
+		deps = append(deps, depKey(store.HubKeyService, id))
+		obj, err := r.get(store.HubKeyService, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch service [%s]: %w", id, err)
+		}
+		service = obj.(*entity.Service)
+	}
+
+	// plugin_config
+	var pluginConfig *entity.PluginConfig
+	if route.PluginConfigID != nil {
+		id := convutil.ToString(route.PluginConfigID)
+		deps = append(deps, depKey(store.HubKeyPluginConfig, id))
+		obj, err := r.get(store.HubKeyPluginConfig, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch plugin config [%s]: %w", id, err)
+		}
+		pluginConfig = obj.(*entity.PluginConfig)
+	}
+
+	// upstream
+	upstream := route.Upstream
+	upstreamID := route.UpstreamID
+	if upstream == nil && upstreamID == nil && service != nil {
+		upstream = service.Upstream
+		upstreamID = service.UpstreamID
+	}
+	if upstream == nil && upstreamID != nil {
+		id := convutil.ToString(upstreamID)
+		deps = append(deps, depKey(store.HubKeyUpstream, id))
+		obj, err := r.get(store.HubKeyUpstream, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch upstream [%s]: %w", id, err)
+		}
+		upstream = &obj.(*entity.Upstream).UpstreamDef
+	}
+	if upstream == nil {
+		return nil, fmt.Errorf("missing upstream configuration in route or service")
+	}
+	eff.Upstream = upstream
+
+	// plugins
+	plugins := make(map[string]interface{})
+	if service != nil {
+		for name, conf := range service.Plugins {
+			plugins[name] = conf
+		}
+		if service.EnableWebsocket {
+			eff.EnableWebsocket = true
+		}
+	}
+	if pluginConfig != nil {
+		for name, conf := range pluginConfig.Plugins {
+			plugins[name] = conf
+		}
+	}
+	for name, conf := range route.Plugins {
+		plugins[name] = conf
+	}
+	eff.Plugins = plugins
+
+	return &resolvedRoute{
+		source: route,
+		route:  &eff,
+		deps:   deps,
+	}, nil
+}
+
+// getUpstream 获取upstream_id对应的上游，供traffic-split等插件使用
+func getUpstream(id string) (*entity.UpstreamDef, error) {
+	obj, err := store.GetStore(store.HubKeyUpstream).Get(context.TODO(), id)
+	if err != nil {
+		return nil, err
+	}
+	return &obj.(*entity.Upstream).UpstreamDef, nil
+}
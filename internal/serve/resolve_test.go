@@ -0,0 +1,98 @@
+// Package server
+//
+// @author: xwc1125
+package serve
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/entity"
+	"github.com/xwc1125/apisix-go/internal/apisix/core/store"
+)
+
+func newTestResolver(objs map[string]interface{}) *resolver {
+	r := newResolver()
+	r.get = func(hubKey store.HubKey, id string) (interface{}, error) {
+		obj, ok := objs[depKey(hubKey, id)]
+		if !ok {
+			return nil, fmt.Errorf("not found")
+		}
+		return obj, nil
+	}
+	return r
+}
+
+func upstreamDef(host string) *entity.UpstreamDef {
+	return &entity.UpstreamDef{
+		Type:  "roundrobin",
+		Nodes: []*entity.Node{{Host: host, Port: 80, Weight: 1}},
+	}
+}
+
+func TestResolverUpstream(t *testing.T) {
+	r := newTestResolver(map[string]interface{}{
+		"upstream/u1": &entity.Upstream{UpstreamDef: *upstreamDef("u1")},
+		"upstream/u2": &entity.Upstream{UpstreamDef: *upstreamDef("u2")},
+		"service/s1":  &entity.Service{Upstream: upstreamDef("s1")},
+		"service/s2":  &entity.Service{UpstreamID: "u2"},
+	})
+
+	tests := []struct {
+		name  string
+		route *entity.Route
+		host  string
+	}{
+		{"inline", &entity.Route{Upstream: upstreamDef("inline"), UpstreamID: "u1", ServiceID: "s1"}, "inline"},
+		{"upstream_id", &entity.Route{UpstreamID: "u1", ServiceID: "s1"}, "u1"},
+		{"service upstream", &entity.Route{ServiceID: "s1"}, "s1"},
+		{"service upstream_id", &entity.Route{ServiceID: "s2"}, "u2"},
+	}
+	for _, tt := range tests {
+		resolved, err := r.Resolve(tt.name, tt.route)
+		if assert.NoError(t, err, tt.name) {
+			assert.Equal(t, tt.host, resolved.Upstream.GetNodes()[0].Host, tt.name)
+		}
+	}
+
+	_, err := r.Resolve("missing", &entity.Route{ServiceID: "s3"})
+	assert.Error(t, err)
+	_, err = r.Resolve("empty", &entity.Route{})
+	assert.Error(t, err)
+}
+
+func TestResolverPlugins(t *testing.T) {
+	r := newTestResolver(map[string]interface{}{
+		"service/s1": &entity.Service{
+			Upstream: upstreamDef("s1"),
+			Plugins:  map[string]interface{}{"a": "service", "b": "service", "c": "service"},
+		},
+		"plugin_config/p1": &entity.PluginConfig{
+			Plugins: map[string]interface{}{"b": "plugin_config", "c": "plugin_config"},
+		},
+	})
+	route := &entity.Route{
+		ServiceID:      "s1",
+		PluginConfigID: "p1",
+		Plugins:        map[string]interface{}{"c": "route"},
+	}
+	resolved, err := r.Resolve("1", route)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]interface{}{
+			"a": "service",
+			"b": "plugin_config",
+			"c": "route",
+		}, resolved.Plugins)
+		// 原始路由不变
+		assert.Equal(t, map[string]interface{}{"c": "route"}, route.Plugins)
+	}
+
+	cached, _ := r.Resolve("1", route)
+	assert.Same(t, resolved, cached)
+
+	assert.Empty(t, r.Invalidate("upstream/s1"))
+	assert.Equal(t, []string{"1"}, r.Invalidate("plugin_config/p1"))
+	again, _ := r.Resolve("1", route)
+	assert.NotSame(t, resolved, again)
+}
@@ -61,7 +61,7 @@ func initCli() *cli.Cli {
 		return nil
 	}
 
-	rootCli.AddCommands(cmd.NewServerCmd(rootCli))
+	rootCli.AddCommands(cmd.NewServerCmd(rootCli), cmd.NewConfigCmd(rootCli))
 	err = rootCli.Execute()
 	if err != nil {
 		log.Fatal(err)